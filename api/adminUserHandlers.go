@@ -0,0 +1,279 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultUserPage = 50
+	maxUserPage     = 200
+)
+
+// AdminRole may use the /admin routes and register jwt secrets
+const AdminRole = "admin_user"
+
+// ListUsers pages through users by id, filtered by domain, app_id, role,
+// created_after/created_before (RFC 3339) and a q search
+func (app *Application) ListUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := userFilter(r)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	limit := filter.Limit
+	//one more to know if there is a next page
+	filter.Limit++
+
+	users, err := app.DB.ListUsers(r.Context(), filter)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	page := models.UserPage{Users: users}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = page.Users[limit-1].ID.Hex()
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "users",
+		Data:    page,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) GetUser(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.userByID(r.Context(), chi.URLParam(r, "id"))
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user",
+		Data:    usr,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) DisableUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, true)
+}
+
+func (app *Application) EnableUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, false)
+}
+
+// a disabled user can not log in and loses the running sessions
+func (app *Application) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	userID := chi.URLParam(r, "id")
+
+	err := app.DB.SetUserDisabled(r.Context(), userID, disabled)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	msg, event := "user enabled", "user_enabled"
+
+	if disabled {
+		msg, event = "user disabled", "user_disabled"
+
+		err = app.JwtAuth.RevokeUser(r.Context(), userID)
+
+		if err != nil {
+			log.Println(err.Error())
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	logSecurityEvent(event, "user", userID)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: msg,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	err := app.DB.DeleteUser(r.Context(), userID)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	//tokens outlive the user otherwise
+	err = app.JwtAuth.RevokeUser(r.Context(), userID)
+
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	logSecurityEvent("user_deleted", "user", userID)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user deleted",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// SetUserRole moves a user to another role of its tenant, only admins grant
+// roles
+func (app *Application) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var role models.UserRole
+	err := app.readJSON(w, r, &role)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(role)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	userID := chi.URLParam(r, "id")
+
+	err = app.DB.SetUserRole(r.Context(), userID, models.UserRole{RoleNmae: role.RoleNmae, Description: role.Description})
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	logSecurityEvent("role_changed", "user", userID, "role", role.RoleNmae, "admin", r.Header.Get("userID"))
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "role updated",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// bootstrapAdmin reports whether a signup is the BOOTSTRAP_ADMIN login id of
+// the own tenant while that tenant has no admin yet
+func (app *Application) bootstrapAdmin(ctx context.Context, userAuth *models.UserAuth) (bool, error) {
+	if app.BootstrapAdmin == "" || userAuth.LoginID != app.BootstrapAdmin ||
+		userAuth.Scope.Domain != app.Domain || userAuth.Scope.AppID != app.AppID {
+		return false, nil
+	}
+
+	admins, err := app.DB.ListUsers(ctx, &models.UserFilter{Domain: app.Domain, AppID: app.AppID, Role: AdminRole, Limit: 1})
+	if err != nil {
+		return false, err
+	}
+
+	return len(admins) == 0, nil
+}
+
+// UnlockUser forgets the failed logins of a user so a locked account can log
+// in again at once
+func (app *Application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	usr, err := app.userByID(r.Context(), chi.URLParam(r, "id"))
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	err = app.Lockout.Reset(r.Context(), accountKey(&usr.UserAuth))
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	logSecurityEvent("account_unlocked", "user", usr.ID.Hex())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user unlocked",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func userFilter(r *http.Request) (*models.UserFilter, error) {
+	q := r.URL.Query()
+
+	filter := &models.UserFilter{
+		Domain: q.Get("domain"),
+		AppID:  q.Get("app_id"),
+		Role:   q.Get("role"),
+		Query:  q.Get("q"),
+		After:  q.Get("cursor"),
+		Limit:  defaultUserPage,
+	}
+
+	if filter.After != "" {
+		if _, err := primitive.ObjectIDFromHex(filter.After); err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+
+		if err != nil || n < 1 || n > maxUserPage {
+			return nil, errors.New("limit must be between 1 and " + strconv.Itoa(maxUserPage))
+		}
+
+		filter.Limit = n
+	}
+
+	var err error
+
+	if filter.CreatedAfter, err = timeParam(q.Get("created_after")); err != nil {
+		return nil, errors.New("invalid created_after")
+	}
+
+	if filter.CreatedBefore, err = timeParam(q.Get("created_before")); err != nil {
+		return nil, errors.New("invalid created_before")
+	}
+
+	return filter, nil
+}
+
+// an empty parameter is the zero time
+func timeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
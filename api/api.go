@@ -1,27 +1,61 @@
 package api
 
 import (
+	"auth/password"
 	"auth/repositores"
+	"auth/repositores/memoryRepo"
 	"auth/repositores/mongoRepo"
+	"auth/repositores/sqlRepo"
+	"auth/webauthn"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-playground/validator/v10"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type Application struct {
 	Domain          string
 	AppID           string
+	IssuerURL       string
+	EncryptionKey   string
 	DB              repositores.DatabaseRepo
-	DbOperations    *mongoRepo.Operations
+	Hasher          repositores.PasswordHasher
+	DbOperations    *repositores.Operations
 	Validator       *validator.Validate
 	JwtAuth         JwtAuth
 	MaxRefreshToken int
+	KeyRepo         repositores.KeyRepo
+	OAuth           repositores.OAuthStore
+	UserTokens      repositores.UserTokenStore
+	MFAChallenges   repositores.MFAChallengeStore
+	MFAPolicy       MFAPolicy
+	MFAIssuer       string
+	DefaultRole     string
+	BootstrapAdmin  string
+	MagicLink       MagicLinkTenants
+	Lockout         *LoginLockout
+	RateLimiter     *RateLimiter
+	TrustProxy      bool
+	WebAuthn        *webauthn.RelyingParty
+	WebAuthnStore   repositores.WebAuthnStore
+	Notifier        Notifier
+	ResetURI        string
+	VerifyEmailURI  string
+	MagicLinkURI    string
+	KeyRotation     time.Duration
+	KeyPrePublish   time.Duration
+	keyMu           sync.Mutex
+	keyHolder       string
+	keyReloaded     time.Time
 }
 
 type JSONResponse struct {
@@ -30,30 +64,60 @@ type JSONResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// OAuthError is the error body of the oauth endpoints (RFC 6749 5.2)
+type OAuthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
 func (app *Application) StartApp() {
 
 	port := os.Getenv("WEB_PORT")
 
-	//init db
-	Mongodb := mongoRepo.MongoDB{
-		Host:      os.Getenv("MONGODB_HOST"),
-		Port:      os.Getenv("MONGODB_PORT"),
-		DefualtDb: os.Getenv("MONGODB_DEFAULT_DB"),
-		Admin:     os.Getenv("MONGODB_ADMIN"),
-		Password:  os.Getenv("MONGODB_PASSWORD"),
-	}
-	Mongodb.DBClint = Mongodb.ConnectDB().(*mongo.Client)
-	dbOperatoins := &mongoRepo.Operations{
+	dbOperatoins := &repositores.Operations{
 		Create: "C",
 		Read:   "R",
 		Update: "U",
 		Delete: "D",
 	}
-	Mongodb.Operations = dbOperatoins
+
+	//init db, memory keeps everything in process and the sql drivers keep
+	//tokens in the same database, neither needs mongo
+	var Mongodb *mongoRepo.MongoDB
+	var memoryDB *memoryRepo.MemoryDB
+	var sqlDB *sqlRepo.SQLDB
+
+	hasher := passwordHasher()
+	app.Hasher = hasher
+
+	switch os.Getenv("DB_DRIVER") {
+	case "memory":
+		memoryDB = memoryRepo.NewMemoryDB()
+		memoryDB.Hasher = hasher
+		memoryDB.CleanWorker(time.Minute)
+		app.DB = memoryDB
+		app.KeyRepo = memoryDB
+		app.WebAuthnStore = memoryDB
+		log.Println("using in-memory database, data is lost on restart")
+	case sqlRepo.SQLite, sqlRepo.Postgres:
+		sqlDB = newSQLDB()
+		sqlDB.Hasher = hasher
+		autoMigrate(sqlDB)
+		sqlDB.CleanWorker(time.Minute)
+		app.DB = sqlDB
+		app.KeyRepo = sqlDB
+		app.WebAuthnStore = sqlDB
+	default:
+		Mongodb = newMongoDB()
+		Mongodb.Hasher = hasher
+		autoMigrate(Mongodb)
+		app.DB = Mongodb
+		app.KeyRepo = Mongodb
+		app.WebAuthnStore = Mongodb
+	}
 
 	//init app
 	app.DbOperations = dbOperatoins
-	app.DB = &Mongodb
 	app.Validator = validator.New()
 	app.Domain = os.Getenv("DOMAIN")
 
@@ -63,6 +127,7 @@ func (app *Application) StartApp() {
 	}
 
 	app.AppID = os.Getenv("APP_ID")
+	app.IssuerURL = os.Getenv("ISSUER_URL")
 	maxInt, err := strconv.Atoi(os.Getenv("MAX_REFRESH_TOKEN_CNT"))
 
 	if err != nil {
@@ -71,15 +136,206 @@ func (app *Application) StartApp() {
 
 	app.MaxRefreshToken = maxInt
 
+	//ENCRYPTION_KEY is required and encrypts signing keys, TOTP and third
+	//party secrets at rest. Secrets stored before it existed were encrypted
+	//with DOMAIN+APP_ID, they are still read and moved to it once used
+	app.EncryptionKey = os.Getenv("ENCRYPTION_KEY")
+
+	if len(app.EncryptionKey) < 32 {
+		log.Fatal(errors.New("ENCRYPTION_KEY must be set to at least 32 random characters, generate one with: openssl rand -hex 32"))
+	}
+
+	//mailed links need a fixed origin, the request host can be forged
+	for _, link := range []struct {
+		uri  *string
+		env  string
+		path string
+	}{
+		{&app.ResetURI, "PASSWORD_RESET_URI", "/password/reset/confirm"},
+		{&app.VerifyEmailURI, "EMAIL_VERIFICATION_URI", "/email/verify"},
+		{&app.MagicLinkURI, "MAGIC_LINK_URI", "/magic-link/verify"},
+	} {
+		if *link.uri, err = mailLinkURI(link.env, app.IssuerURL, link.path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	//the role of new users, only an admin grants others. BOOTSTRAP_ADMIN is
+	//the login id that becomes admin of the own tenant while it has none
+	app.DefaultRole = os.Getenv("DEFAULT_ROLE")
+
+	if app.DefaultRole == "" {
+		app.DefaultRole = "user"
+	}
+
+	if app.DefaultRole == AdminRole {
+		log.Fatal(errors.New("DEFAULT_ROLE can not be " + AdminRole))
+	}
+
+	app.BootstrapAdmin = os.Getenv("BOOTSTRAP_ADMIN")
+
+	//roles that need a second factor, "domain/app:role,role;*:admin_user"
+	app.MFAPolicy, err = parseMFAPolicy(os.Getenv("MFA_REQUIRED_ROLES"))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//issuer shown by authenticator apps
+	app.MFAIssuer = os.Getenv("MFA_ISSUER")
+	if app.MFAIssuer == "" {
+		app.MFAIssuer = app.Domain
+	}
+
+	//tenants with passwordless login, "domain/app;domain/app" or "*"
+	app.MagicLink, err = parseMagicLinkTenants(os.Getenv("MAGIC_LINK_TENANTS"))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//passkeys, off without WEBAUTHN_RP_ID or ISSUER_URL
+	app.WebAuthn, err = webAuthnRelyingParty(app.IssuerURL, app.Domain)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	//init jwt
 	jwtAuth := JwtAuth{
-		Issuer:            app.Domain + "_" + app.AppID,
-		TokenRefreshCache: map[string]JwtAuthCache{},
+		Issuer:        app.Domain + "_" + app.AppID,
+		Audiences:     []string{app.Domain + "_" + app.AppID},
+		Leeway:        30 * time.Second,
+		TokenExpiry:   time.Minute * 15,
+		RefreshExpiry: time.Hour * 24,
+		MaxRefresh:    app.MaxRefreshToken,
+		Passphrase:    app.EncryptionKey,
+		OldPassphrase: app.Domain + app.AppID,
+	}
+
+	//init refresh token, denylist, grant and login attempt stores
+	var loginAttempts repositores.LoginAttemptStore
+	var rateLimits repositores.RateLimitStore
+
+	switch {
+	case memoryDB != nil:
+		jwtAuth.RefreshStore = memoryDB
+		jwtAuth.Revocations = memoryDB
+		app.OAuth = memoryDB
+		app.UserTokens = memoryDB
+		app.MFAChallenges = memoryDB
+		loginAttempts = memoryDB
+		rateLimits = memoryDB
+	case sqlDB != nil:
+		jwtAuth.RefreshStore = sqlDB
+		jwtAuth.Revocations = sqlDB
+		app.OAuth = sqlDB
+		app.UserTokens = sqlDB
+		app.MFAChallenges = sqlDB
+		loginAttempts = sqlDB
+		rateLimits = sqlDB
+	case os.Getenv("TOKEN_STORE") == "memory":
+		tokenDB := memoryRepo.NewMemoryDB()
+		tokenDB.CleanWorker(time.Minute)
+		jwtAuth.RefreshStore = tokenDB
+		jwtAuth.Revocations = tokenDB
+		app.OAuth = tokenDB
+		app.UserTokens = tokenDB
+		app.MFAChallenges = tokenDB
+		loginAttempts = tokenDB
+		rateLimits = tokenDB
+	default:
+		jwtAuth.RefreshStore = Mongodb
+		jwtAuth.Revocations = Mongodb
+		app.OAuth = Mongodb
+		app.UserTokens = Mongodb
+		app.MFAChallenges = Mongodb
+		loginAttempts = Mongodb
+		rateLimits = Mongodb
+	}
+
+	//failed logins are counted in the shared store so every replica sees them
+	app.Lockout = &LoginLockout{
+		Store:         loginAttempts,
+		Window:        durationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		DelayAfter:    intEnv("LOGIN_DELAY_AFTER", 3),
+		BaseDelay:     durationEnv("LOGIN_BASE_DELAY", time.Second),
+		MaxDelay:      durationEnv("LOGIN_MAX_DELAY", 30*time.Second),
+		MaxFailures:   intEnv("LOGIN_MAX_FAILURES", 10),
+		Lockout:       durationEnv("LOGIN_LOCKOUT", 15*time.Minute),
+		IPMaxFailures: intEnv("LOGIN_IP_MAX_FAILURES", 100),
+	}
+	app.TrustProxy = os.Getenv("TRUST_PROXY") == "true"
+
+	//per route limits over the defaults, "route=algorithm:key:limit/period",
+	//RATE_LIMIT=off turns them all off
+	if os.Getenv("RATE_LIMIT") != "off" {
+		limits, err := parseRateLimits(os.Getenv("RATE_LIMITS"))
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		app.RateLimiter = &RateLimiter{Store: rateLimits, Limits: limits}
+	}
+
+	//mail goes out over SMTP_HOST, without it messages are written to
+	//NOTIFIER_FILE or the log
+	app.Notifier = &LogNotifier{Path: os.Getenv("NOTIFIER_FILE")}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		smtpNotifier := &SMTPNotifier{
+			Host:     host,
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+
+		if smtpNotifier.Port == "" {
+			smtpNotifier.Port = "587"
+		}
+
+		if smtpNotifier.From == "" {
+			log.Fatal(errors.New("SMTP_FROM must be set with SMTP_HOST"))
+		}
+
+		app.Notifier = smtpNotifier
+	}
+
+	//openid connect needs the public url as issuer
+	if app.IssuerURL != "" {
+		jwtAuth.Issuer = strings.TrimRight(app.IssuerURL, "/")
+	}
+
+	//init signing keys
+	jwtAuth.SigningAlg = os.Getenv("JWT_SIGNING_ALG")
+	if jwtAuth.SigningAlg == "" {
+		jwtAuth.SigningAlg = jwt.SigningMethodRS256.Alg()
+	}
+
+	jwtAuth.Keys = NewKeySet()
+
+	//extra accepted audiences, comma separated
+	if auds := os.Getenv("JWT_AUDIENCES"); auds != "" {
+		jwtAuth.Audiences = append(jwtAuth.Audiences, strings.Split(auds, ",")...)
 	}
 	app.JwtAuth = jwtAuth
 
-	//start clean worker
-	app.JwtAuth.CleanCacheWorker()
+	//init signing keys and rotation
+	if app.JwtAuth.SigningAlg != jwt.SigningMethodHS256.Alg() {
+		app.KeyRotation = durationEnv("JWT_KEY_ROTATION", 30*24*time.Hour)
+		app.KeyPrePublish = durationEnv("JWT_KEY_PREPUBLISH", 15*time.Minute)
+		app.keyHolder = newTokenID()
+		app.JwtAuth.ReloadKeys = app.reloadSigningKeys
+
+		err = app.InitSigningKeys(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		app.KeyRotationWorker()
+	}
 
 	log.Println("Starting application on port", port)
 
@@ -89,3 +345,105 @@ func (app *Application) StartApp() {
 		log.Fatal(err)
 	}
 }
+
+// MigrateDB applies the pending migrations of the DB_DRIVER database and
+// returns, for running them ahead of a deploy
+func (app *Application) MigrateDB() error {
+	switch os.Getenv("DB_DRIVER") {
+	case "memory":
+		return errors.New("the memory database has no migrations")
+	case sqlRepo.SQLite, sqlRepo.Postgres:
+		return newSQLDB().Migrate()
+	default:
+		return newMongoDB().Migrate()
+	}
+}
+
+func newSQLDB() *sqlRepo.SQLDB {
+	db := &sqlRepo.SQLDB{
+		Driver: os.Getenv("DB_DRIVER"),
+		DSN:    os.Getenv("DATABASE_URL"),
+	}
+	if db.Driver == sqlRepo.SQLite && db.DSN == "" {
+		db.DSN = "auth.db"
+	}
+	db.DBClint = db.ConnectDB()
+
+	return db
+}
+
+func newMongoDB() *mongoRepo.MongoDB {
+	db := &mongoRepo.MongoDB{
+		Host:      os.Getenv("MONGODB_HOST"),
+		Port:      os.Getenv("MONGODB_PORT"),
+		DefualtDb: os.Getenv("MONGODB_DEFAULT_DB"),
+		Admin:     os.Getenv("MONGODB_ADMIN"),
+		Password:  os.Getenv("MONGODB_PASSWORD"),
+	}
+	db.DBClint = db.ConnectDB()
+
+	return db
+}
+
+// migrations run at startup unless AUTO_MIGRATE=false, then the migrate
+// command has to run before the new version starts
+func autoMigrate(db interface{ Migrate() error }) {
+	if os.Getenv("AUTO_MIGRATE") == "false" {
+		return
+	}
+
+	err := db.Migrate()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// argon2id unless PASSWORD_HASH=bcrypt, existing hashes are upgraded to these
+// settings on login
+func passwordHasher() *password.Hasher {
+	hasher := password.NewHasher()
+
+	if alg := os.Getenv("PASSWORD_HASH"); alg != "" {
+		if alg != password.Argon2id && alg != password.Bcrypt {
+			log.Fatal(errors.New("PASSWORD_HASH must be argon2id or bcrypt"))
+		}
+		hasher.Algorithm = alg
+	}
+
+	hasher.Memory = uint32(intEnv("ARGON2_MEMORY_KIB", int(hasher.Memory)))
+	hasher.Time = uint32(intEnv("ARGON2_TIME", int(hasher.Time)))
+	hasher.Parallelism = uint8(intEnv("ARGON2_PARALLELISM", int(hasher.Parallelism)))
+	hasher.BcryptCost = intEnv("BCRYPT_COST", hasher.BcryptCost)
+
+	return hasher
+}
+
+// read a positive number from env
+func intEnv(name string, def int) int {
+	val := os.Getenv(name)
+	if val == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 1 {
+		log.Fatal(fmt.Errorf("%s must be a positive number", name))
+	}
+
+	return n
+}
+
+// read a duration like "720h" from env, 0 disables
+func durationEnv(name string, def time.Duration) time.Duration {
+	val := os.Getenv(name)
+	if val == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return d
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"auth/models"
+	"auth/password"
+	"auth/repositores"
+	"auth/repositores/memoryRepo"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testDomain   = "d"
+	testAppID    = "a"
+	testIssuer   = "http://auth.test"
+	testAdmin    = "root"
+	testPassword = "secret-password"
+	testKeyName  = "app"
+)
+
+// testApp is the service StartApp builds on the memory database, with cheap
+// password hashing and without env or background workers
+type testApp struct {
+	*Application
+	t       *testing.T
+	db      *memoryRepo.MemoryDB
+	handler http.Handler
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	hasher := password.NewHasher()
+	hasher.Algorithm = password.Bcrypt
+	hasher.BcryptCost = bcrypt.MinCost
+
+	db := memoryRepo.NewMemoryDB()
+	db.Hasher = hasher
+
+	limits, err := parseRateLimits("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &Application{
+		Domain:         testDomain,
+		AppID:          testAppID,
+		IssuerURL:      testIssuer,
+		EncryptionKey:  "0123456789abcdef0123456789abcdef",
+		DB:             db,
+		Hasher:         hasher,
+		DbOperations:   &repositores.Operations{Create: "C", Read: "R", Update: "U", Delete: "D"},
+		Validator:      validator.New(),
+		KeyRepo:        db,
+		OAuth:          db,
+		UserTokens:     db,
+		MFAChallenges:  db,
+		MFAIssuer:      testDomain,
+		DefaultRole:    "user",
+		BootstrapAdmin: testAdmin,
+		WebAuthnStore:  db,
+		Notifier:       &LogNotifier{Path: t.TempDir() + "/mail.log"},
+		ResetURI:       testIssuer + "/password/reset/confirm",
+		VerifyEmailURI: testIssuer + "/email/verify",
+		MagicLinkURI:   testIssuer + "/magic-link/verify",
+		KeyRotation:    30 * 24 * time.Hour,
+		KeyPrePublish:  15 * time.Minute,
+		keyHolder:      newTokenID(),
+		Lockout: &LoginLockout{
+			Store:         db,
+			Window:        15 * time.Minute,
+			DelayAfter:    3,
+			BaseDelay:     time.Second,
+			MaxDelay:      30 * time.Second,
+			MaxFailures:   10,
+			Lockout:       15 * time.Minute,
+			IPMaxFailures: 100,
+		},
+		RateLimiter: &RateLimiter{Store: db, Limits: limits},
+	}
+
+	app.JwtAuth = JwtAuth{
+		Issuer:        testIssuer,
+		SigningAlg:    jwt.SigningMethodES256.Alg(),
+		Keys:          NewKeySet(),
+		Audiences:     []string{testDomain + "_" + testAppID},
+		Leeway:        30 * time.Second,
+		TokenExpiry:   15 * time.Minute,
+		RefreshExpiry: 24 * time.Hour,
+		MaxRefresh:    5,
+		RefreshStore:  db,
+		Revocations:   db,
+		Passphrase:    app.EncryptionKey,
+		OldPassphrase: testDomain + testAppID,
+		ReloadKeys:    app.reloadSigningKeys,
+	}
+
+	err = app.InitSigningKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testApp{Application: app, t: t, db: db, handler: app.routes()}
+}
+
+// serve sends r through the routes
+func (ta *testApp) serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ta.handler.ServeHTTP(w, r)
+
+	return w
+}
+
+// do sends body as JSON, headers are pairs of name and value
+func (ta *testApp) do(method string, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+
+	var reader io.Reader = http.NoBody
+
+	if body != nil {
+		out, err := json.Marshal(body)
+		if err != nil {
+			ta.t.Fatal(err)
+		}
+		reader = bytes.NewReader(out)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	r.Header.Set("Content-Type", "application/json")
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	return ta.serve(r)
+}
+
+// postForm sends form url encoded
+func (ta *testApp) postForm(path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return ta.serve(r)
+}
+
+// decode reads the JSON body of w into data
+func decode(t *testing.T, w *httptest.ResponseRecorder, data interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(w.Body.Bytes(), data)
+	if err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+}
+
+// expectStatus fails the test unless w answered status
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
+
+func userAuth(loginID string, password string) map[string]interface{} {
+	return map[string]interface{}{
+		"login_id": loginID,
+		"password": password,
+		"scope": map[string]interface{}{
+			"user_domain": testDomain,
+			"user_app_id": testAppID,
+			"user_role":   map[string]string{"role_name": "user"},
+		},
+	}
+}
+
+// signin creates a user of the test tenant and returns its id, the first
+// one named testAdmin becomes admin
+func (ta *testApp) signin(loginID string) string {
+	ta.t.Helper()
+
+	w := ta.do(http.MethodPost, "/signin", map[string]interface{}{"user_auth": userAuth(loginID, testPassword)})
+	expectStatus(ta.t, w, http.StatusOK)
+
+	var resp struct {
+		Data struct {
+			InsertedID string `json:"InsertedID"`
+		} `json:"data"`
+	}
+	decode(ta.t, w, &resp)
+
+	return resp.Data.InsertedID
+}
+
+// admin creates testAdmin with a registered jwt secret, the user /jwtauth
+// logins are made with
+func (ta *testApp) admin() string {
+	ta.t.Helper()
+
+	id := ta.signin(testAdmin)
+
+	w := ta.do(http.MethodPost, "/registerJwt", map[string]interface{}{
+		"user_auth":           userAuth(testAdmin, testPassword),
+		"third_party_secrets": []map[string]string{{"key_name": testKeyName, "key_value": "third-party-secret"}},
+	})
+	expectStatus(ta.t, w, http.StatusOK)
+
+	return id
+}
+
+// jwtauth logs testAdmin in, the answer is either tokens or an mfa challenge
+func (ta *testApp) jwtauth() *httptest.ResponseRecorder {
+	ta.t.Helper()
+
+	return ta.do(http.MethodPost, "/jwtauth", map[string]interface{}{
+		"user_auth":           userAuth(testAdmin, testPassword),
+		"third_party_secrets": []map[string]string{{"key_name": testKeyName}},
+	})
+}
+
+// tokens logs testAdmin in without a second factor
+func (ta *testApp) tokens() *models.TokenPairs {
+	ta.t.Helper()
+
+	w := ta.jwtauth()
+	expectStatus(ta.t, w, http.StatusOK)
+
+	return decodeTokens(ta.t, w)
+}
+
+func decodeTokens(t *testing.T, w *httptest.ResponseRecorder) *models.TokenPairs {
+	t.Helper()
+
+	var resp struct {
+		Data models.TokenPairs `json:"data"`
+	}
+	decode(t, w, &resp)
+
+	if resp.Data.Token.PlainText == "" || resp.Data.RefreshToken.PlainText == "" {
+		t.Fatalf("no tokens in %s", w.Body.String())
+	}
+
+	return &resp.Data
+}
+
+func bearer(token string) string {
+	return "Bearer " + token
+}
+
+func TestHealth(t *testing.T) {
+	ta := newTestApp(t)
+
+	w := ta.do(http.MethodGet, "/health", nil)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestJwtAuthentication(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	tokens := ta.tokens()
+
+	w := ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(tokens.Token.PlainText))
+	expectStatus(t, w, http.StatusOK)
+
+	w = ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(tokens.RefreshToken.PlainText))
+	expectStatus(t, w, http.StatusUnauthorized)
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"auth/models"
+	"auth/repositores"
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -10,19 +12,79 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type JwtAuth struct {
-	Issuer            string
-	TokenExpiry       time.Duration
-	RefreshExpiry     time.Duration
-	TokenRefreshCache map[string]JwtAuthCache
+	Issuer        string
+	SigningAlg    string
+	Keys          *KeySet
+	Audiences     []string
+	Leeway        time.Duration
+	TokenExpiry   time.Duration
+	RefreshExpiry time.Duration
+	MaxRefresh    int
+	RefreshStore  repositores.RefreshTokenStore
+	Revocations   repositores.RevocationStore
+	Passphrase    string
+	OldPassphrase string
+	ReloadKeys    func(ctx context.Context) error
 }
 
-type JwtAuthCache struct {
-	Count        int
-	Secret       string
-	RefreshToken string
+// token verification errors
+var (
+	ErrNoAuth            = errors.New("no auth")
+	ErrInvalidAuthHeader = errors.New("invalid auth header")
+	ErrTokenMalformed    = errors.New("malformed token")
+	ErrTokenExpired      = errors.New("token is expired")
+	ErrTokenNotValidYet  = errors.New("token is not valid yet")
+	ErrTokenSignature    = errors.New("invalid token signature")
+	ErrTokenAudience     = errors.New("invalid token audience")
+	ErrTokenIssuer       = errors.New("invalid issuer")
+	ErrTokenClaims       = errors.New("invalid token claims")
+	ErrUnknownKey        = errors.New("unknown signing key")
+	ErrTokenRevoked      = errors.New("token is revoked")
+	ErrTokenUse          = errors.New("wrong token type")
+	ErrTokenSubject      = errors.New("unknown token subject")
+)
+
+// every verification error, anything else is a store failure
+var tokenErrors = map[error]bool{
+	ErrTokenMalformed:   true,
+	ErrTokenExpired:     true,
+	ErrTokenNotValidYet: true,
+	ErrTokenSignature:   true,
+	ErrTokenAudience:    true,
+	ErrTokenIssuer:      true,
+	ErrTokenClaims:      true,
+	ErrUnknownKey:       true,
+	ErrTokenRevoked:     true,
+	ErrTokenUse:         true,
+	ErrTokenSubject:     true,
+}
+
+// token_use claim, bearer routes only take access tokens and id tokens are
+// never taken back
+const (
+	TokenUseAccess  = "access"
+	TokenUseRefresh = "refresh"
+	TokenUseID      = "id"
+)
+
+// refresh errors
+var (
+	ErrRefreshInvalid = errors.New("invalid refresh token")
+	ErrRefreshReused  = errors.New("refresh token reused")
+	ErrRefreshRevoked = errors.New("refresh token revoked")
+	ErrRefreshLimit   = errors.New("refresh token expiried")
+)
+
+// allowed signing algorithms, each key is further pinned to its own
+var validMethods = []string{
+	jwt.SigningMethodHS256.Alg(),
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
 }
 
 // embeded jwt RegisteredClaims
@@ -30,42 +92,240 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-func (j *JwtAuth) GenerateTopenPair(usr *models.User) (*models.TokenPairs, error) {
+// GenerateTopenPair starts a new refresh token family for usr, secret is the
+// encrypted third party secret used by legacy HS256 signing. An id_token is
+// added when opts ask for the openid scope
+func (j *JwtAuth) GenerateTopenPair(ctx context.Context, usr *models.User, secret string, opts *TokenOptions) (*models.TokenPairs, error) {
 	usrID := usr.ID.Hex()
 
-	//get cache key
-	secret := j.TokenRefreshCache[usrID].Secret
-
-	//create a token
-	token := jwt.New(jwt.SigningMethodHS256)
 	//set the claims
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = fmt.Sprintf("%s %s", usr.Profile.FisrtName, usr.Profile.LastNmae)
 	claims["sub"] = usrID
 	claims["aud"] = usr.UserAuth.Scope.Domain + "_" + usr.UserAuth.Scope.AppID
+	claims["role"] = usr.UserAuth.Scope.Role.RoleNmae
+	claims["typ"] = "JWT"
+	claims["token_use"] = TokenUseAccess
+
+	if opts != nil && len(opts.Scope) > 0 {
+		claims["scope"] = strings.Join(opts.Scope, " ")
+	}
+
+	if opts != nil && opts.ClientID != "" {
+		claims["client_id"] = opts.ClientID
+	}
+
+	refresh := &models.RefreshToken{
+		ID:       newTokenID(),
+		FamilyID: newTokenID(),
+		UserID:   usrID,
+		Secret:   secret,
+	}
+
+	if opts != nil {
+		refresh.ClientID = opts.ClientID
+	}
+
+	tokenPairs, err := j.issueTokenPair(ctx, claims, refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.HasScope(ScopeOpenID) {
+		tokenPairs.IDToken, err = j.idToken(ctx, usr, opts, refresh.ID, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tokenPairs, nil
+}
+
+// GenerateClientToken issues a client_credentials access token, the client is
+// its own subject and gets no refresh token
+func (j *JwtAuth) GenerateClientToken(ctx context.Context, client *models.Client, scopes []string) (string, error) {
+	now := time.Now().UTC()
+
+	claims := jwt.MapClaims{}
+	claims["sub"] = client.ClientID
+	claims["client_id"] = client.ClientID
+	claims["aud"] = client.Domain + "_" + client.AppID
+	claims["jti"] = newTokenID()
+	claims["iss"] = j.Issuer
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(j.TokenExpiry).Unix()
+	claims["typ"] = "JWT"
+	claims["token_use"] = TokenUseAccess
+
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+
+	if j.SigningAlg == jwt.SigningMethodHS256.Alg() {
+		return "", errors.New("client tokens need asymmetric signing keys")
+	}
+
+	return j.SignToken(ctx, "", claims)
+}
+
+// GenerateExchangedToken issues a token exchange access token for the subject
+// of subjectClaims. It keeps the subject's session so revoking the login
+// revokes it too, and never outlives the subject token
+func (j *JwtAuth) GenerateExchangedToken(ctx context.Context, subjectClaims jwt.MapClaims, act map[string]interface{}, client *models.Client, audiences []string, scopes []string) (string, time.Duration, error) {
+	now := time.Now().UTC()
+	exp := now.Add(j.TokenExpiry)
+
+	if subjectExp, err := subjectClaims.GetExpirationTime(); err == nil && subjectExp != nil && subjectExp.Before(exp) {
+		exp = subjectExp.Time
+	}
+
+	claims := jwt.MapClaims{}
+	for _, name := range []string{"sub", "name", "role", "sid"} {
+		if val, ok := subjectClaims[name]; ok {
+			claims[name] = val
+		}
+	}
+
+	claims["client_id"] = client.ClientID
+	claims["aud"] = audiences
+	claims["jti"] = newTokenID()
 	claims["iss"] = j.Issuer
-	claims["iat"] = time.Now().UTC().Unix()
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp.Unix()
 	claims["typ"] = "JWT"
+	claims["token_use"] = TokenUseAccess
+
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+
+	if act != nil {
+		claims["act"] = act
+	}
+
+	if j.SigningAlg == jwt.SigningMethodHS256.Alg() {
+		return "", 0, errors.New("exchanged tokens need asymmetric signing keys")
+	}
+
+	token, err := j.SignToken(ctx, "", claims)
+
+	return token, exp.Sub(now), err
+}
+
+// RotateRefreshToken spends the one time refresh token of refreshClaims and
+// issues the next pair of its family. A token presented twice revokes the
+// whole family
+func (j *JwtAuth) RotateRefreshToken(ctx context.Context, refreshClaims jwt.MapClaims) (*models.TokenPairs, error) {
+	jti, _ := refreshClaims["jti"].(string)
+	sub, _ := refreshClaims["sub"].(string)
+
+	if jti == "" {
+		return nil, ErrRefreshInvalid
+	}
+
+	used, err := j.RefreshStore.UseRefreshToken(ctx, jti)
+
+	switch {
+	case errors.Is(err, repositores.ErrConflict):
+		//someone holds a copy of the token, kill the login
+		logSecurityEvent("refresh_token_reuse", "user", used.UserID, "family", used.FamilyID, "jti", jti)
+
+		err = j.RevokeFamily(ctx, used.FamilyID)
+		if err != nil {
+			log.Println(err)
+		}
+
+		return nil, ErrRefreshReused
+	case errors.Is(err, repositores.ErrNotFound):
+		return nil, ErrRefreshInvalid
+	case err != nil:
+		return nil, err
+	}
+
+	if used.Revoked {
+		return nil, ErrRefreshRevoked
+	}
+
+	if used.UserID != sub {
+		return nil, ErrRefreshInvalid
+	}
+
+	if used.Count >= j.MaxRefresh {
+		return nil, ErrRefreshLimit
+	}
+
+	//the last access token may be expired, only its signature matters
+	origJwtToken, err := jwt.NewParser(jwt.WithoutClaimsValidation()).Parse(used.AccessToken, j.keyFunc(ctx))
+
+	if err != nil {
+		return nil, tokenError(err)
+	}
+
+	next := &models.RefreshToken{
+		ID:       newTokenID(),
+		FamilyID: used.FamilyID,
+		UserID:   used.UserID,
+		ClientID: used.ClientID,
+		Secret:   used.Secret,
+		Count:    used.Count + 1,
+	}
+
+	//move the chain to the current key
+	if used.Secret != "" {
+		if _, resealed, err := j.openSecret(used.Secret); err == nil && resealed != "" {
+			next.Secret = resealed
+		}
+	}
+
+	return j.issueTokenPair(ctx, origJwtToken.Claims.(jwt.MapClaims), next)
+}
+
+// sign claims as access token and store refresh as its one time refresh token
+func (j *JwtAuth) issueTokenPair(ctx context.Context, claims jwt.MapClaims, refresh *models.RefreshToken) (*models.TokenPairs, error) {
+	now := time.Now().UTC()
+
+	refresh.CreatedAt = primitive.NewDateTimeFromTime(now)
+	refresh.ExpiresAt = primitive.NewDateTimeFromTime(now.Add(j.RefreshExpiry))
+
+	//legacy signing reads the secret from the entry
+	err := j.RefreshStore.SaveRefreshToken(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	claims["jti"] = newTokenID()
+	claims["sid"] = refresh.FamilyID
+	claims["token_use"] = TokenUseAccess
+	claims["iss"] = j.Issuer
+	claims["iat"] = now.Unix()
 
 	//set expriry for JWT
-	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
+	claims["exp"] = now.Add(j.TokenExpiry).Unix()
 	//create singed token
-	signedAccessToken, err := token.SignedString([]byte(secret))
+	signedAccessToken, err := j.SignToken(ctx, refresh.ID, claims)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
 	//create a refresh token and set clailms
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshClaims := refreshToken.Claims.(jwt.MapClaims)
-	refreshClaims["sub"] = usr.ID.Hex()
-	refreshClaims["iat"] = time.Now().UTC().Unix()
+	refreshClaims := jwt.MapClaims{}
+	refreshClaims["sub"] = refresh.UserID
+	refreshClaims["jti"] = refresh.ID
+	refreshClaims["iat"] = now.Unix()
 	refreshClaims["iss"] = j.Issuer
+	refreshClaims["token_use"] = TokenUseRefresh
 	//set the expiry for the refresh token
-	refreshClaims["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
+	refreshClaims["exp"] = now.Add(j.RefreshExpiry).Unix()
 	//create signed refresh token
-	signedRefreshAccessToken, err := refreshToken.SignedString([]byte(secret))
+	signedRefreshAccessToken, err := j.SignToken(ctx, refresh.ID, refreshClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh.AccessToken = signedAccessToken
+
+	err = j.RefreshStore.SaveRefreshToken(ctx, refresh)
 	if err != nil {
 		return nil, err
 	}
@@ -74,12 +334,40 @@ func (j *JwtAuth) GenerateTopenPair(usr *models.User) (*models.TokenPairs, error
 	var tokenPairs = models.TokenPairs{
 		Token:        models.Token{PlainText: signedAccessToken, Expiry: j.TokenExpiry / time.Minute},
 		RefreshToken: models.Token{PlainText: signedRefreshAccessToken, Expiry: j.RefreshExpiry / time.Hour},
+		SessionID:    refresh.FamilyID,
 	}
 	//return token pairs
 
 	return &tokenPairs, nil
 }
 
+// SignToken signs claims with the current service key, or when running with
+// legacy HS256 with the third party secret kept on refresh token kid
+func (j *JwtAuth) SignToken(ctx context.Context, kid string, claims jwt.MapClaims) (string, error) {
+	if j.SigningAlg == jwt.SigningMethodHS256.Alg() {
+		//get cache key
+		secret, err := j.legacySecret(ctx, kid)
+		if err != nil {
+			return "", err
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = kid
+
+		return token.SignedString([]byte(secret))
+	}
+
+	key, err := j.Keys.Current()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.Kid
+
+	return token.SignedString(key.Private)
+}
+
 func (j *JwtAuth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, jwt.MapClaims, error) {
 	w.Header().Add("Vary", "Authorization")
 
@@ -88,63 +376,227 @@ func (j *JwtAuth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Req
 
 	//sanity check
 	if authHeader == "" {
-		return "", nil, errors.New("no auth")
+		return "", nil, ErrNoAuth
 	}
 
 	//slpit the header
 	headerParts := strings.Split(authHeader, " ")
 
 	if len(headerParts) != 2 {
-		return "", nil, errors.New("invalid auth header")
+		return "", nil, ErrInvalidAuthHeader
 	}
 
 	//check start
 	if headerParts[0] != "Bearer" {
-		return "", nil, errors.New("invalid auth header")
+		return "", nil, ErrInvalidAuthHeader
 	}
 
 	tokenStr := headerParts[1]
 
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenStr, jwt.MapClaims{})
+	jwtClaims, err := j.VerifyToken(r.Context(), tokenStr, TokenUseAccess)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	//good token
+	return tokenStr, jwtClaims, nil
+}
+
+// VerifyToken checks the signature of tokenStr against the key of its kid,
+// validates exp, nbf, iat, iss and aud and rejects revoked tokens. use is the
+// token_use the caller takes, empty takes access and refresh tokens
+func (j *JwtAuth) VerifyToken(ctx context.Context, tokenStr string, use string) (jwt.MapClaims, error) {
+	jwtClaims, err := j.parseToken(ctx, tokenStr)
 
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("expired token")
+		return nil, err
+	}
+
+	switch tokenUse(jwtClaims) {
+	case TokenUseAccess:
+		if !j.validAudience(jwtClaims) {
+			return nil, ErrTokenAudience
 		}
+	case TokenUseRefresh:
+	default:
+		return nil, ErrTokenUse
+	}
 
-		return "", nil, err
+	if use != "" && tokenUse(jwtClaims) != use {
+		return nil, ErrTokenUse
 	}
 
-	jwtClaims, ok := token.Claims.(jwt.MapClaims)
+	return jwtClaims, nil
+}
 
-	if !ok {
-		return "", nil, err
+// parseToken checks the signature, exp, nbf, iat and iss of tokenStr and
+// rejects revoked tokens, the audience is left to the caller
+func (j *JwtAuth) parseToken(ctx context.Context, tokenStr string) (jwt.MapClaims, error) {
+	jwtClaims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenStr, jwtClaims, j.keyFunc(ctx),
+		jwt.WithValidMethods(validMethods),
+		jwt.WithIssuer(j.Issuer),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(j.Leeway),
+	)
+
+	if err != nil {
+		return nil, tokenError(err)
+	}
+
+	//exp is optional for the parser but not for us
+	if _, ok := jwtClaims["exp"]; !ok {
+		return nil, ErrTokenClaims
 	}
 
-	exp := jwtClaims["exp"].(float64)
+	revoked, err := j.IsRevoked(ctx, jwtClaims)
 
-	if exp < float64(time.Now().UTC().Unix()) {
-		return "", nil, errors.New("token is expired")
+	if err != nil {
+		return nil, err
 	}
 
-	if jwtClaims["iss"] != j.Issuer {
-		return "", nil, errors.New("invalid issuer")
+	if revoked {
+		return nil, ErrTokenRevoked
 	}
 
-	//good token
-	return tokenStr, jwtClaims, nil
+	return jwtClaims, nil
+}
+
+// tokenUse is the token_use claim, refresh tokens from before the claim are
+// the ones without an audience
+func tokenUse(claims jwt.MapClaims) string {
+	if use, ok := claims["token_use"].(string); ok {
+		return use
+	}
+
+	if _, ok := claims["aud"]; !ok {
+		return TokenUseRefresh
+	}
+
+	return ""
 }
 
-// chache clean up
-func (j *JwtAuth) CleanCache() {
-	for {
-		for k := range j.TokenRefreshCache {
-			delete(j.TokenRefreshCache, k)
+// keyFunc looks up the signing key by kid
+func (j *JwtAuth) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		return j.signingKey(ctx, token)
+	}
+}
+
+func (j *JwtAuth) signingKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := j.Keys.Get(kid)
+
+	//a key another replica added since the last reload
+	if !ok && token.Method.Alg() != jwt.SigningMethodHS256.Alg() && j.ReloadKeys != nil {
+		if err := j.ReloadKeys(ctx); err != nil {
+			log.Println(err)
+		}
+		key, ok = j.Keys.Get(kid)
+	}
+
+	//service key, pinned to its algorithm
+	if ok {
+		if token.Method.Alg() != key.Alg {
+			return nil, ErrTokenSignature
+		}
+		return key.Public(), nil
+	}
+
+	//legacy per user secret
+	if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+		return nil, ErrUnknownKey
+	}
+
+	secret, err := j.legacySecret(ctx, kid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(secret), nil
+}
+
+// the decrypted third party secret of the login refresh token kid belongs to
+func (j *JwtAuth) legacySecret(ctx context.Context, kid string) (string, error) {
+	entry, err := j.RefreshStore.GetRefreshToken(ctx, kid)
+
+	if err != nil {
+		if errors.Is(err, repositores.ErrNotFound) {
+			return "", ErrUnknownKey
 		}
-		time.Sleep(24 * time.Hour)
+		return "", err
 	}
+
+	if entry.Secret == "" || entry.Revoked {
+		return "", ErrUnknownKey
+	}
+
+	secret, _, err := j.openSecret(entry.Secret)
+
+	return secret, err
+}
+
+// openSecret decrypts a secret at rest. Secrets stored before ENCRYPTION_KEY
+// are read with OldPassphrase, resealed is then the secret encrypted with
+// Passphrase to store instead, empty otherwise
+func (j *JwtAuth) openSecret(ciphertext string) (plain string, resealed string, err error) {
+	plain, err = decrypt(j.Passphrase, ciphertext)
+
+	if err == nil || j.OldPassphrase == "" {
+		return plain, "", err
+	}
+
+	plain, oldErr := decrypt(j.OldPassphrase, ciphertext)
+
+	if oldErr != nil {
+		return "", "", err
+	}
+
+	return plain, encrypt(j.Passphrase, plain), nil
 }
 
-func (j *JwtAuth) CleanCacheWorker() {
-	go j.CleanCache()
+func (j *JwtAuth) validAudience(jwtClaims jwt.MapClaims) bool {
+	auds, err := jwtClaims.GetAudience()
+
+	if err != nil {
+		return false
+	}
+
+	for _, aud := range auds {
+		for _, allowed := range j.Audiences {
+			if aud == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// map jwt parser errors to our own
+func tokenError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnknownKey):
+		return ErrUnknownKey
+	case errors.Is(err, ErrTokenSignature):
+		return ErrTokenSignature
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ErrTokenMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ErrTokenSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrTokenNotValidYet
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return ErrTokenIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return ErrTokenAudience
+	default:
+		return ErrTokenClaims
+	}
 }
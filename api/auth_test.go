@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// accessClaims are the claims of a valid access token of the test tenant
+func accessClaims() jwt.MapClaims {
+	now := time.Now()
+
+	return jwt.MapClaims{
+		"sub":       "user",
+		"aud":       testDomain + "_" + testAppID,
+		"iss":       testIssuer,
+		"iat":       now.Unix(),
+		"exp":       now.Add(time.Minute).Unix(),
+		"jti":       newTokenID(),
+		"token_use": TokenUseAccess,
+	}
+}
+
+// sign signs claims with key under kid
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestVerifyTokenRejects(t *testing.T) {
+	ta := newTestApp(t)
+
+	current, err := ta.JwtAuth.Keys.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewSigningKey(jwt.SigningMethodES256.Alg())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withClaim := func(name string, value interface{}) jwt.MapClaims {
+		claims := accessClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	signed := sign(t, current.Method(), current.Kid, current.Private, accessClaims())
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", signed, nil},
+		{"changed claims", withSignature(sign(t, current.Method(), current.Kid, current.Private, withClaim("sub", "admin")), signed), ErrTokenSignature},
+		{"changed signature", tamper(signed), ErrTokenSignature},
+		{"other key under the kid", sign(t, other.Method(), current.Kid, other.Private, accessClaims()), ErrTokenSignature},
+		{"unknown kid", sign(t, other.Method(), other.Kid, other.Private, accessClaims()), ErrUnknownKey},
+		{"alg none", sign(t, jwt.SigningMethodNone, current.Kid, jwt.UnsafeAllowNoneSignatureType, accessClaims()), ErrTokenSignature},
+		{"hs256 with the public key", hs256WithPublicKey(t, current), ErrTokenSignature},
+		{"other audience", sign(t, current.Method(), current.Kid, current.Private, withClaim("aud", "other_app")), ErrTokenAudience},
+		{"no audience", sign(t, current.Method(), current.Kid, current.Private, withClaim("aud", nil)), ErrTokenAudience},
+		{"other issuer", sign(t, current.Method(), current.Kid, current.Private, withClaim("iss", "http://evil.test")), ErrTokenIssuer},
+		{"expired", sign(t, current.Method(), current.Kid, current.Private, withClaim("exp", time.Now().Add(-time.Minute).Unix())), ErrTokenExpired},
+		{"within leeway", sign(t, current.Method(), current.Kid, current.Private, withClaim("exp", time.Now().Add(-10*time.Second).Unix())), nil},
+		{"no expiry", sign(t, current.Method(), current.Kid, current.Private, withClaim("exp", nil)), ErrTokenClaims},
+		{"not valid yet", sign(t, current.Method(), current.Kid, current.Private, withClaim("nbf", time.Now().Add(time.Hour).Unix())), ErrTokenNotValidYet},
+		{"refresh as access", sign(t, current.Method(), current.Kid, current.Private, withClaim("token_use", TokenUseRefresh)), ErrTokenUse},
+		{"malformed", "not.a.token", ErrTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ta.JwtAuth.VerifyToken(context.Background(), tt.token, TokenUseAccess)
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// tamper changes the first character of the signature of a token
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+
+	if parts[2][0] == 'A' {
+		parts[2] = "B" + parts[2][1:]
+	} else {
+		parts[2] = "A" + parts[2][1:]
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// withSignature puts the signature of signed on token
+func withSignature(token string, signed string) string {
+	parts := strings.Split(token, ".")
+	parts[2] = strings.Split(signed, ".")[2]
+
+	return strings.Join(parts, ".")
+}
+
+// hs256WithPublicKey is the key confusion attack, the public key of an
+// asymmetric key used as HMAC secret
+func hs256WithPublicKey(t *testing.T, key *SigningKey) string {
+	t.Helper()
+
+	jwk, err := key.JWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sign(t, jwt.SigningMethodHS256, key.Kid, []byte(jwk.X+jwk.Y), accessClaims())
+}
+
+func TestRevokedSigningKeyStopsVerifying(t *testing.T) {
+	ta := newTestApp(t)
+
+	current, err := ta.JwtAuth.Keys.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := sign(t, current.Method(), current.Kid, current.Private, accessClaims())
+
+	err = ta.RevokeSigningKey(context.Background(), current.Kid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ta.JwtAuth.VerifyToken(context.Background(), signed, TokenUseAccess)
+
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("err %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestBearerChallenge(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	tokens := ta.tokens()
+
+	w := ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(tamper(tokens.Token.PlainText)))
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	challenge := w.Header().Get("WWW-Authenticate")
+
+	if !strings.Contains(challenge, `error="invalid_token"`) {
+		t.Fatalf("WWW-Authenticate %q, want invalid_token", challenge)
+	}
+}
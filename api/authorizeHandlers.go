@@ -0,0 +1,223 @@
+package api
+
+import (
+	"auth/models"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in to {{.Client}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>Login ID <input name="login_id" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+var mfaPage = template.Must(template.New("mfa").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Two-factor authentication</title></head>
+<body>
+<h1>Sign in to {{.Client}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+<label>Authentication or recovery code <input name="mfa_code" autocomplete="one-time-code" required></label>
+<button type="submit">Verify</button>
+</form>
+</body>
+</html>
+`))
+
+// Authorize shows the login form of the authorization code flow
+func (app *Application) Authorize(w http.ResponseWriter, r *http.Request) {
+	req := newAuthorizeRequest(r.URL.Query())
+
+	client, err := app.authorizeClient(r.Context(), req)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if code, description := req.validate(client); code != "" {
+		redirectWith(w, r, req.RedirectURI, url.Values{"error": {code}, "error_description": {description}, "state": {req.State}})
+		return
+	}
+
+	app.renderLogin(w, client, req, "")
+}
+
+// AuthorizeLogin checks the login form and redirects back with a code
+func (app *Application) AuthorizeLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	req := newAuthorizeRequest(r.PostForm)
+
+	client, err := app.authorizeClient(r.Context(), req)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if code, description := req.validate(client); code != "" {
+		redirectWith(w, r, req.RedirectURI, url.Values{"error": {code}, "error_description": {description}, "state": {req.State}})
+		return
+	}
+
+	if mfaToken := r.PostForm.Get("mfa_token"); mfaToken != "" {
+		app.authorizeMFA(w, r, client, req, mfaToken)
+		return
+	}
+
+	userAuth := models.UserAuth{
+		LoginID:  r.PostForm.Get("login_id"),
+		Password: r.PostForm.Get("password"),
+		Scope:    models.UserScope{Domain: client.Domain, AppID: client.AppID},
+	}
+
+	usr, err := app.verifyLogin(r, &userAuth)
+
+	var throttled *LoginThrottledError
+
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", retryAfter(throttled.RetryAfter))
+		app.renderLogin(w, client, req, throttled.Error())
+		return
+	}
+
+	if err != nil {
+		app.renderLogin(w, client, req, ErrInvalidLogin.Error())
+		return
+	}
+
+	if app.mfaRequired(usr) {
+		//enrollment needs the api, the form only takes codes
+		if !usr.MFA.Enabled {
+			app.renderLogin(w, client, req, "set up two-factor authentication before signing in")
+			return
+		}
+
+		required, err := app.newMFAChallenge(r.Context(), usr, models.MFAChallenge{ClientID: client.ClientID})
+
+		if err != nil {
+			log.Println(err.Error())
+			redirectWith(w, r, req.RedirectURI, url.Values{"error": {"server_error"}, "state": {req.State}})
+			return
+		}
+
+		app.renderMFA(w, client, req, required.MFAToken, "")
+		return
+	}
+
+	app.redirectWithCode(w, r, req, usr)
+}
+
+// authorizeMFA checks the code of the second form, the challenge allows a
+// few tries before the login starts over
+func (app *Application) authorizeMFA(w http.ResponseWriter, r *http.Request, client *models.Client, req *authorizeRequest, mfaToken string) {
+	challenge, err := app.attemptMFAChallenge(r.Context(), mfaToken)
+
+	if err == nil && challenge.ClientID != client.ClientID {
+		err = ErrInvalidMFAToken
+	}
+
+	if errors.Is(err, ErrInvalidMFAToken) {
+		app.renderLogin(w, client, req, "the sign in expired, please start again")
+		return
+	}
+
+	var usr *models.User
+
+	if err == nil {
+		usr, err = app.userByID(r.Context(), challenge.UserID)
+	}
+
+	if err == nil {
+		err = app.verifyMFACode(r.Context(), usr, r.PostForm.Get("mfa_code"))
+	}
+
+	if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrMFACodeRequired) {
+		app.renderMFA(w, client, req, mfaToken, "invalid code")
+		return
+	}
+
+	if err == nil {
+		err = app.finishMFAChallenge(r.Context(), challenge)
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		redirectWith(w, r, req.RedirectURI, url.Values{"error": {"server_error"}, "state": {req.State}})
+		return
+	}
+
+	app.redirectWithCode(w, r, req, usr)
+}
+
+func (app *Application) redirectWithCode(w http.ResponseWriter, r *http.Request, req *authorizeRequest, usr *models.User) {
+	code, err := app.issueAuthCode(r.Context(), req, usr)
+
+	if err != nil {
+		log.Println(err.Error())
+		redirectWith(w, r, req.RedirectURI, url.Values{"error": {"server_error"}, "state": {req.State}})
+		return
+	}
+
+	redirectWith(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+func (app *Application) renderLogin(w http.ResponseWriter, client *models.Client, req *authorizeRequest, loginErr string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+
+	data := map[string]interface{}{
+		"Client": client.Name,
+		"Error":  loginErr,
+		"Params": req.params(),
+	}
+
+	err := loginPage.Execute(w, data)
+
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func (app *Application) renderMFA(w http.ResponseWriter, client *models.Client, req *authorizeRequest, mfaToken string, mfaErr string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+
+	data := map[string]interface{}{
+		"Client":   client.Name,
+		"Error":    mfaErr,
+		"Params":   req.params(),
+		"MFAToken": mfaToken,
+	}
+
+	err := mfaPage.Execute(w, data)
+
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
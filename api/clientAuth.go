@@ -0,0 +1,94 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"errors"
+	"net/http"
+)
+
+var ErrInvalidClient = errors.New("invalid client")
+
+// client credentials of a parsed form, client_secret_basic wins over
+// client_secret_post
+func clientCredentials(r *http.Request) (string, string) {
+	clientID, secret, ok := r.BasicAuth()
+
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	return clientID, secret
+}
+
+// clientAuth is the outcome of authenticateClient kept in the request
+// context, the secret hash is slow to check
+type clientAuth struct {
+	client *models.Client
+	err    error
+}
+
+type clientAuthKey struct{}
+
+// authenticateClient checks the credentials of a confidential client
+func (app *Application) authenticateClient(r *http.Request) (*models.Client, error) {
+	if auth, ok := r.Context().Value(clientAuthKey{}).(*clientAuth); ok {
+		return auth.client, auth.err
+	}
+
+	clientID, secret := clientCredentials(r)
+
+	if clientID == "" || secret == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := app.DB.ValidClientSecret(r.Context(), clientID, secret)
+
+	if err != nil {
+		if errors.Is(err, repositores.ErrNotFound) || errors.Is(err, repositores.ErrInvalidCredentials) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// tokenClient identifies the client of a token request, public clients only
+// name themselves while confidential clients must authenticate
+func (app *Application) tokenClient(r *http.Request) (*models.Client, error) {
+	clientID, secret := clientCredentials(r)
+
+	if secret != "" {
+		client, err := app.authenticateClient(r)
+
+		if errors.Is(err, ErrInvalidClient) {
+			return nil, &grantError{http.StatusUnauthorized, "invalid_client", "client authentication failed"}
+		}
+
+		return client, err
+	}
+
+	client, err := app.DB.GetClientByID(r.Context(), clientID)
+
+	if err != nil {
+		if errors.Is(err, repositores.ErrNotFound) {
+			return nil, &grantError{http.StatusUnauthorized, "invalid_client", "unknown client"}
+		}
+		return nil, err
+	}
+
+	if !client.Public {
+		return nil, &grantError{http.StatusUnauthorized, "invalid_client", "client authentication required"}
+	}
+
+	return client, nil
+}
+
+// reply 401 invalid_client with a basic challenge
+func (app *Application) invalidClientJSON(w http.ResponseWriter) error {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+app.JwtAuth.Issuer+`"`)
+
+	return app.oauthErrorJSON(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"auth/models"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+var supportedGrants = []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials, models.GrantDeviceCode, models.GrantTokenExchange}
+
+// CreateClient registers an oauth client, the secret is only returned here
+func (app *Application) CreateClient(w http.ResponseWriter, r *http.Request) {
+	var client models.Client
+	err := app.readJSON(w, r, &client)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(client)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if client.Domain == "" && client.AppID == "" {
+		client.Domain = app.Domain
+		client.AppID = app.AppID
+	}
+
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{models.GrantAuthorizationCode, models.GrantRefreshToken}
+	}
+
+	if len(client.Scopes) == 0 {
+		client.Scopes = supportedScopes
+	}
+
+	for _, grant := range client.GrantTypes {
+		if !hasScope(supportedGrants, grant) {
+			app.errorJSON(w, errors.New("unsupported grant type "+grant), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if client.Public && client.AllowsGrant(models.GrantClientCredentials) {
+		app.errorJSON(w, errors.New("public clients can not use client_credentials"), http.StatusBadRequest)
+		return
+	}
+
+	if client.AllowsGrant(models.GrantTokenExchange) && (client.Public || client.Exchange == nil) {
+		app.errorJSON(w, errors.New("token exchange needs a confidential client with a token_exchange policy"), http.StatusBadRequest)
+		return
+	}
+
+	if client.AllowsGrant(models.GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		app.errorJSON(w, errors.New("redirect_uris are required for authorization_code"), http.StatusBadRequest)
+		return
+	}
+
+	client.ClientID = newTokenID()
+	client.Secret = ""
+	client.SecretHash = ""
+
+	if !client.Public {
+		client.Secret = newTokenID() + newTokenID()
+	}
+
+	err = app.DB.CreateClient(r.Context(), &client)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "client not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "client created",
+		Data:    client,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) ListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.DB.ListClients(r.Context())
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "client not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "clients",
+		Data:    clients,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) GetClient(w http.ResponseWriter, r *http.Request) {
+	client, err := app.DB.GetClientByID(r.Context(), chi.URLParam(r, "id"))
+
+	if err != nil {
+		app.clientErrorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "client",
+		Data:    client,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// RotateClientSecret replaces the secret of a confidential client
+func (app *Application) RotateClientSecret(w http.ResponseWriter, r *http.Request) {
+	client, err := app.DB.GetClientByID(r.Context(), chi.URLParam(r, "id"))
+
+	if err != nil {
+		app.clientErrorJSON(w, err)
+		return
+	}
+
+	if client.Public {
+		app.errorJSON(w, errors.New("public clients have no secret"), http.StatusBadRequest)
+		return
+	}
+
+	client.Secret = newTokenID() + newTokenID()
+
+	err = app.DB.UpdateClientSecret(r.Context(), client.ClientID, client.Secret)
+
+	if err != nil {
+		app.clientErrorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "client secret rotated",
+		Data:    client,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	err := app.DB.DeleteClient(r.Context(), chi.URLParam(r, "id"))
+
+	if err != nil {
+		app.clientErrorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "client deleted",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) clientErrorJSON(w http.ResponseWriter, err error) {
+	app.dbErrorJSON(w, err, "client not found")
+}
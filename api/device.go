@@ -0,0 +1,235 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	deviceCodeExpiry   = 10 * time.Minute
+	devicePollInterval = 5
+	// no vowels so user codes can't spell words, see RFC 8628 section 6.1
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength  = 8
+)
+
+var ErrUnknownUserCode = errors.New("unknown or expired user code")
+
+// newUserCode returns a random code of userCodeCharset without separator
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeCharset)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeCharset[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// normalizeUserCode drops separators and case from what the user typed
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// formatUserCode shows a code as XXXX-XXXX
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+
+	return code[:4] + "-" + code[4:]
+}
+
+// issueDeviceCode starts a device authorization for client, the device code is
+// only stored by its hash
+func (app *Application) issueDeviceCode(ctx context.Context, client *models.Client, scopes []string) (string, *models.DeviceCode, error) {
+	deviceCode := newTokenID() + newTokenID()
+	now := time.Now().UTC()
+
+	entry := models.DeviceCode{
+		ID:        hashToken(deviceCode),
+		ClientID:  client.ClientID,
+		Scope:     scopes,
+		Status:    models.DevicePending,
+		Interval:  devicePollInterval,
+		ExpiresAt: primitive.NewDateTimeFromTime(now.Add(deviceCodeExpiry)),
+	}
+
+	//user codes are short, retry on the rare collision
+	for i := 0; i < 3; i++ {
+		userCode, err := newUserCode()
+		if err != nil {
+			return "", nil, err
+		}
+		entry.UserCode = userCode
+
+		err = app.OAuth.SaveDeviceCode(ctx, &entry)
+
+		if err == nil {
+			return deviceCode, &entry, nil
+		}
+
+		if !errors.Is(err, repositores.ErrDuplicate) {
+			return "", nil, err
+		}
+	}
+
+	return "", nil, errors.New("could not allocate a user code")
+}
+
+// verifyDeviceCode approves or denies a pending user code for usr, the user
+// must belong to the tenant of the client
+func (app *Application) verifyDeviceCode(ctx context.Context, userCode string, usr *models.User, approve bool) (*models.DeviceCode, error) {
+	entry, err := app.OAuth.GetDeviceCodeByUserCode(ctx, normalizeUserCode(userCode))
+
+	if err != nil {
+		if errors.Is(err, repositores.ErrNotFound) {
+			return nil, ErrUnknownUserCode
+		}
+		return nil, err
+	}
+
+	if entry.Status != models.DevicePending {
+		return nil, ErrUnknownUserCode
+	}
+
+	client, err := app.DB.GetClientByID(ctx, entry.ClientID)
+
+	if err != nil {
+		if errors.Is(err, repositores.ErrNotFound) {
+			return nil, ErrUnknownUserCode
+		}
+		return nil, err
+	}
+
+	if usr.UserAuth.Scope.Domain != client.Domain || usr.UserAuth.Scope.AppID != client.AppID {
+		return nil, errors.New("user can not sign in to this client")
+	}
+
+	entry.Status = models.DeviceDenied
+	if approve {
+		entry.Status = models.DeviceApproved
+		entry.UserID = usr.ID.Hex()
+		entry.AuthTime = primitive.NewDateTimeFromTime(time.Now().UTC())
+	}
+
+	err = app.OAuth.SaveDeviceCode(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// deviceCodeGrant answers the polling of a device, tokens are issued once
+// the user approved its code
+func (app *Application) deviceCodeGrant(r *http.Request) (*models.TokenResponse, error) {
+	deviceCode := r.PostForm.Get("device_code")
+
+	if deviceCode == "" {
+		return nil, invalidRequest("device_code is required")
+	}
+
+	client, err := app.tokenClient(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.AllowsGrant(models.GrantDeviceCode) {
+		return nil, unauthorizedClient()
+	}
+
+	now := time.Now().UTC()
+	id := hashToken(deviceCode)
+
+	entry, err := app.OAuth.PollDeviceCode(r.Context(), id, now)
+
+	if err != nil {
+		if errors.Is(err, repositores.ErrNotFound) {
+			return nil, &grantError{http.StatusBadRequest, "expired_token", "device code is unknown or expired"}
+		}
+		return nil, err
+	}
+
+	if entry.ClientID != client.ClientID {
+		return nil, invalidGrant("device code was issued to another client")
+	}
+
+	if entry.ExpiresAt.Time().Before(now) {
+		return nil, &grantError{http.StatusBadRequest, "expired_token", "device code is unknown or expired"}
+	}
+
+	switch entry.Status {
+	case models.DeviceDenied:
+		return nil, &grantError{http.StatusBadRequest, "access_denied", "the user denied the request"}
+	case models.DeviceUsed:
+		return nil, invalidGrant("device code was already used")
+	case models.DevicePending:
+		//polling faster than the interval adds 5 seconds to it
+		if entry.LastPolledAt != 0 && now.Sub(entry.LastPolledAt.Time()) < time.Duration(entry.Interval)*time.Second {
+			entry.Interval += devicePollInterval
+			entry.LastPolledAt = primitive.NewDateTimeFromTime(now)
+
+			err = app.OAuth.SaveDeviceCode(r.Context(), entry)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, &grantError{http.StatusBadRequest, "slow_down", "poll every " + strconv.Itoa(entry.Interval) + " seconds"}
+		}
+
+		return nil, &grantError{http.StatusBadRequest, "authorization_pending", ""}
+	}
+
+	entry, err = app.OAuth.UseDeviceCode(r.Context(), id)
+
+	if err != nil {
+		if errors.Is(err, repositores.ErrConflict) {
+			return nil, invalidGrant("device code was already used")
+		}
+		return nil, err
+	}
+
+	usr, err := app.userByID(r.Context(), entry.UserID)
+
+	if err != nil {
+		return nil, invalidGrant("unknown user")
+	}
+
+	opts := &TokenOptions{
+		Scope:    entry.Scope,
+		ClientID: client.ClientID,
+		AuthTime: entry.AuthTime.Time(),
+	}
+
+	tokenPairs, err := app.JwtAuth.GenerateTopenPair(r.Context(), usr, "", opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return app.tokenResponse(tokenPairs, opts.Scope), nil
+}
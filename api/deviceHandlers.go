@@ -0,0 +1,144 @@
+package api
+
+import (
+	"auth/models"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DeviceCode starts the device authorization grant of RFC 8628
+func (app *Application) DeviceCode(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+
+	if err != nil {
+		app.oauthErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, err := app.tokenClient(r)
+
+	if err != nil {
+		var gerr *grantError
+
+		if errors.As(err, &gerr) {
+			app.invalidClientJSON(w)
+			return
+		}
+
+		log.Println(err.Error())
+		app.oauthErrorJSON(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	if !client.AllowsGrant(models.GrantDeviceCode) {
+		app.oauthErrorJSON(w, http.StatusBadRequest, "unauthorized_client", "client may not use the device flow")
+		return
+	}
+
+	scopes, ok := clientScope(client, r.PostForm.Get("scope"))
+
+	if !ok {
+		app.oauthErrorJSON(w, http.StatusBadRequest, "invalid_scope", "scope is not allowed for the client")
+		return
+	}
+
+	deviceCode, entry, err := app.issueDeviceCode(r.Context(), client, scopes)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.oauthErrorJSON(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	verificationURI := app.deviceVerificationURI(r)
+	userCode := formatUserCode(entry.UserCode)
+
+	resp := models.DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int64(deviceCodeExpiry / time.Second),
+		Interval:                entry.Interval,
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	app.writeJSON(w, http.StatusOK, resp, headers)
+}
+
+// VerifyDevice lets the user of the bearer access token approve or deny a
+// user code
+func (app *Application) VerifyDevice(w http.ResponseWriter, r *http.Request) {
+	_, claims, err := app.JwtAuth.GetTokenFromHeaderAndVerify(w, r)
+
+	if err != nil {
+		app.unauthorizedJSON(w, err)
+		return
+	}
+
+	//refresh tokens carry no audience
+	if claims["aud"] == nil {
+		app.unauthorizedJSON(w, ErrTokenClaims)
+		return
+	}
+
+	var verification models.DeviceVerification
+	err = app.readJSON(w, r, &verification)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(verification)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	sub, _ := claims.GetSubject()
+
+	usr, err := app.userByID(r.Context(), sub)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("only users can verify devices"), http.StatusForbidden)
+		return
+	}
+
+	entry, err := app.verifyDeviceCode(r.Context(), verification.UserCode, usr, verification.Approve)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	logSecurityEvent("device_"+entry.Status, "client", entry.ClientID, "user", usr.ID.Hex())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "device " + entry.Status,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// the page users are sent to, a front end can take over with
+// DEVICE_VERIFICATION_URI and post to /device/verify
+func (app *Application) deviceVerificationURI(r *http.Request) string {
+	if uri := os.Getenv("DEVICE_VERIFICATION_URI"); uri != "" {
+		return uri
+	}
+
+	return app.baseURL(r) + "/device/verify"
+}
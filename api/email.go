@@ -0,0 +1,213 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	emailVerificationExpiry = 24 * time.Hour
+	magicLinkExpiry         = 15 * time.Minute
+)
+
+var (
+	ErrNoEmail                = errors.New("user has no email")
+	ErrEmailVerified          = errors.New("email is already verified")
+	ErrInvalidEmailToken      = errors.New("invalid or expired verification token")
+	ErrInvalidMagicLink       = errors.New("invalid or expired magic link")
+	ErrMagicLinkDisabled      = errors.New("magic link login is not enabled for this tenant")
+	ErrEmailChanged           = errors.New("email was changed, verify the new one")
+	errMagicLinkUndeliverable = errors.New("user can not receive magic links")
+)
+
+// MagicLinkTenants are the "domain/app" tenants that allow passwordless
+// login, "*" allows it for every tenant
+type MagicLinkTenants map[string]bool
+
+// parseMagicLinkTenants reads "domain/app;domain/app" or "*"
+func parseMagicLinkTenants(value string) (MagicLinkTenants, error) {
+	tenants := MagicLinkTenants{}
+
+	for _, tenant := range strings.Split(value, ";") {
+		tenant = strings.TrimSpace(tenant)
+		if tenant == "" {
+			continue
+		}
+
+		if tenant != "*" && !strings.Contains(tenant, "/") {
+			return nil, errors.New("invalid magic link tenant " + tenant)
+		}
+
+		tenants[tenant] = true
+	}
+
+	return tenants, nil
+}
+
+// Allows reports whether users of scope may log in with a magic link
+func (t MagicLinkTenants) Allows(scope *models.UserScope) bool {
+	return t["*"] || t[scope.Domain+"/"+scope.AppID]
+}
+
+// newUserToken stores a single use token for usr, only its hash is kept
+func (app *Application) newUserToken(ctx context.Context, usr *models.User, purpose string, expiry time.Duration) (string, error) {
+	token := newTokenID() + newTokenID()
+
+	entry := models.UserToken{
+		ID:        hashToken(token),
+		Purpose:   purpose,
+		UserID:    usr.ID.Hex(),
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(expiry)),
+	}
+
+	err := app.UserTokens.SaveUserToken(ctx, &entry)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// sendEmailVerification mails a link that confirms the current email
+func (app *Application) sendEmailVerification(ctx context.Context, usr *models.User, verifyURI string) error {
+	if usr.Profile.Email == "" {
+		return ErrNoEmail
+	}
+
+	if usr.Profile.EmailVerified {
+		return ErrEmailVerified
+	}
+
+	token, err := app.newUserToken(ctx, usr, models.TokenEmailVerification, emailVerificationExpiry)
+	if err != nil {
+		return err
+	}
+
+	link := verifyURI + "?" + url.Values{"token": {token}}.Encode()
+
+	return app.Notifier.Notify(ctx, &Notification{
+		Kind:    models.TokenEmailVerification,
+		To:      usr.Profile.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Open %s within %d hours to confirm %s as the email of %s.\n\n"+
+			"If you did not add this address, ignore this message.\n",
+			link, int(emailVerificationExpiry/time.Hour), usr.Profile.Email, usr.UserAuth.LoginID),
+	})
+}
+
+// verifyEmail marks the email verified, the link is only good for the
+// address it was sent to
+func (app *Application) verifyEmail(ctx context.Context, token string) (*models.User, error) {
+	entry, err := app.UserTokens.UseUserToken(ctx, hashToken(token), models.TokenEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	usr, err := app.userByID(ctx, entry.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if usr.Profile.Email == "" {
+		return nil, ErrEmailChanged
+	}
+
+	err = app.DB.SetEmailVerified(ctx, usr.ID.Hex(), usr.Profile.Email, primitive.NewDateTimeFromTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	return usr, nil
+}
+
+// emailChanged drops the links sent to the old address and verifies the new
+// one, mail errors are only logged
+func (app *Application) emailChanged(ctx context.Context, usr *models.User, verifyURI string) error {
+	for _, purpose := range []string{models.TokenEmailVerification, models.TokenMagicLink} {
+		err := app.UserTokens.DeleteUserTokens(ctx, usr.ID.Hex(), purpose)
+		if err != nil {
+			return err
+		}
+	}
+
+	if usr.Profile.Email == "" {
+		return nil
+	}
+
+	err := app.sendEmailVerification(ctx, usr, verifyURI)
+	if err != nil {
+		logSecurityEvent("email_verification_not_sent", "user", usr.ID.Hex(), "reason", err.Error())
+	}
+
+	return nil
+}
+
+// sendMagicLink mails a single use login link, only to verified emails
+func (app *Application) sendMagicLink(ctx context.Context, usr *models.User, loginURI string) error {
+	if usr.Disabled || usr.Profile.Email == "" || !usr.Profile.EmailVerified {
+		return errMagicLinkUndeliverable
+	}
+
+	token, err := app.newUserToken(ctx, usr, models.TokenMagicLink, magicLinkExpiry)
+	if err != nil {
+		return err
+	}
+
+	link := loginURI + "?" + url.Values{"token": {token}}.Encode()
+
+	return app.Notifier.Notify(ctx, &Notification{
+		Kind:    models.TokenMagicLink,
+		To:      usr.Profile.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Open %s within %d minutes to log in as %s.\n\n"+
+			"If you did not ask to log in, ignore this message.\n",
+			link, int(magicLinkExpiry/time.Minute), usr.UserAuth.LoginID),
+	})
+}
+
+// useMagicLink returns the user of a magic link, once
+func (app *Application) useMagicLink(ctx context.Context, token string) (*models.User, error) {
+	entry, err := app.UserTokens.UseUserToken(ctx, hashToken(token), models.TokenMagicLink)
+	if err != nil {
+		return nil, err
+	}
+
+	usr, err := app.userByID(ctx, entry.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	//the tenant may have turned it off or the email changed since
+	if !app.MagicLink.Allows(&usr.UserAuth.Scope) {
+		return nil, ErrMagicLinkDisabled
+	}
+
+	if !usr.Profile.EmailVerified {
+		return nil, ErrInvalidMagicLink
+	}
+
+	return usr, nil
+}
+
+// mailLinkURI is the page a mailed link points to, the env var can send it
+// to a front end that posts to path. Links are never built from the request,
+// a forged Host header would send the token to someone else
+func mailLinkURI(env, issuerURL, path string) (string, error) {
+	if uri := os.Getenv(env); uri != "" {
+		return uri, nil
+	}
+
+	if issuerURL == "" {
+		return "", errors.New(env + " or ISSUER_URL must be set")
+	}
+
+	return strings.TrimRight(issuerURL, "/") + path, nil
+}
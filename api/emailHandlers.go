@@ -0,0 +1,229 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RequestEmailVerification mails a new verification link to the email of the
+// user of the bearer access token
+func (app *Application) RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	err := app.sendEmailVerification(r.Context(), usr, app.VerifyEmailURI)
+
+	if err != nil {
+		app.emailErrorJSON(w, err)
+		return
+	}
+
+	logSecurityEvent("email_verification_requested", "user", usr.ID.Hex())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "verification link sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ConfirmEmail redeems a verification link, GET takes the token from the
+// link itself and POST from a JSON body
+func (app *Application) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	verification := models.EmailVerification{Token: r.URL.Query().Get("token")}
+
+	if r.Method == http.MethodPost {
+		err := app.readJSON(w, r, &verification)
+
+		if err != nil {
+			log.Println(err.Error())
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := app.Validator.Struct(verification)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	usr, err := app.verifyEmail(r.Context(), verification.Token)
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		err = ErrInvalidEmailToken
+	}
+
+	if errors.Is(err, repositores.ErrConflict) {
+		err = ErrEmailChanged
+	}
+
+	if err != nil {
+		app.emailErrorJSON(w, err)
+		return
+	}
+
+	logSecurityEvent("email_verified", "user", usr.ID.Hex())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "email verified",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// RequestMagicLink mails a login link to a user with a verified email. The
+// answer is the same whether or not a link was sent
+func (app *Application) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req models.MagicLinkRequest
+	err := app.readJSON(w, r, &req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	userAuth := models.UserAuth{
+		LoginID: req.LoginID,
+		Scope:   models.UserScope{Domain: req.Domain, AppID: req.AppID},
+	}
+
+	if userAuth.Scope.Domain == "" && userAuth.Scope.AppID == "" {
+		userAuth.Scope.Domain = app.Domain
+		userAuth.Scope.AppID = app.AppID
+	}
+
+	if !app.MagicLink.Allows(&userAuth.Scope) {
+		app.emailErrorJSON(w, ErrMagicLinkDisabled)
+		return
+	}
+
+	usr, err := app.DB.GetUserByLoginID(r.Context(), &userAuth)
+
+	switch {
+	case errors.Is(err, repositores.ErrNotFound):
+		logSecurityEvent("magic_link_unknown_user", "login_id", req.LoginID)
+	case err != nil:
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	default:
+		err = app.sendMagicLink(r.Context(), usr, app.MagicLinkURI)
+
+		switch {
+		case errors.Is(err, errMagicLinkUndeliverable):
+			logSecurityEvent("magic_link_not_sent", "user", usr.ID.Hex())
+		case err != nil:
+			log.Println(err.Error())
+		default:
+			logSecurityEvent("magic_link_requested", "user", usr.ID.Hex())
+		}
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if the user exists a login link was sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// MagicLinkLogin redeems a magic link for tokens like /jwtauth, scope and
+// nonce are optional query parameters. The link is used up even when the
+// second factor is wrong
+func (app *Application) MagicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.MagicLinkLogin
+	err := app.readJSON(w, r, &req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	usr, err := app.useMagicLink(r.Context(), req.Token)
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		err = ErrInvalidMagicLink
+	}
+
+	if err != nil {
+		app.emailErrorJSON(w, err)
+		return
+	}
+
+	if usr.Disabled {
+		app.dbErrorJSON(w, repositores.ErrUserDisabled, "user not found")
+		return
+	}
+
+	if !app.checkMFAJSON(w, r, usr, req.MFACode) {
+		return
+	}
+
+	opts := &TokenOptions{
+		Scope:    parseScope(r.URL.Query().Get("scope")),
+		Nonce:    r.URL.Query().Get("nonce"),
+		AuthTime: time.Now(),
+	}
+
+	tokens, err := app.JwtAuth.GenerateTopenPair(r.Context(), usr, "", opts)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	logSecurityEvent("magic_link_login", "user", usr.ID.Hex())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "jwt token",
+		Data:    tokens,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// emailErrorJSON maps the email link errors to status codes
+func (app *Application) emailErrorJSON(w http.ResponseWriter, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidEmailToken), errors.Is(err, ErrInvalidMagicLink):
+		return app.errorJSON(w, err, http.StatusBadRequest)
+	case errors.Is(err, ErrNoEmail):
+		return app.errorJSON(w, err, http.StatusBadRequest)
+	case errors.Is(err, ErrEmailVerified), errors.Is(err, ErrEmailChanged):
+		return app.errorJSON(w, err, http.StatusConflict)
+	case errors.Is(err, ErrMagicLinkDisabled):
+		return app.errorJSON(w, err, http.StatusForbidden)
+	}
+
+	return app.dbErrorJSON(w, err, "user not found")
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Introspect implements RFC 7662 for authenticated clients
+func (app *Application) Introspect(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+
+	if err != nil {
+		app.oauthErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	_, err = app.authenticateClient(r)
+
+	if err != nil {
+		if !errors.Is(err, ErrInvalidClient) {
+			log.Println(err.Error())
+		}
+		app.invalidClientJSON(w)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+
+	if token == "" {
+		app.oauthErrorJSON(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	result, err := app.JwtAuth.Introspect(r.Context(), token)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.oauthErrorJSON(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	app.writeJSON(w, http.StatusOK, result, headers)
+}
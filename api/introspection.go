@@ -0,0 +1,63 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Introspect reports whether tokenStr is active, an error is only returned
+// when the stores fail. Resource servers ask about tokens for any audience,
+// so only the signature, the lifetime and the denylist count
+func (j *JwtAuth) Introspect(ctx context.Context, tokenStr string) (*models.Introspection, error) {
+	claims, err := j.parseToken(ctx, tokenStr)
+
+	if err != nil {
+		if _, ok := tokenErrors[err]; ok {
+			return &models.Introspection{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	//id tokens are not presented to resource servers
+	if use := tokenUse(claims); use != TokenUseAccess && use != TokenUseRefresh {
+		return &models.Introspection{Active: false}, nil
+	}
+
+	result := &models.Introspection{Active: true, TokenType: "Bearer"}
+
+	entry, isRefresh, err := j.refreshEntry(ctx, claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if isRefresh {
+		if entry.Used || entry.Revoked {
+			return &models.Introspection{Active: false}, nil
+		}
+		result.TokenType = "refresh_token"
+	}
+
+	result.Sub, _ = claims.GetSubject()
+	result.Iss, _ = claims.GetIssuer()
+	result.Aud, _ = claims.GetAudience()
+	result.Jti, _ = claims["jti"].(string)
+	result.Scope, _ = claims["scope"].(string)
+	result.ClientID, _ = claims["client_id"].(string)
+	result.Exp = unixClaim(claims.GetExpirationTime)
+	result.Iat = unixClaim(claims.GetIssuedAt)
+	result.Nbf = unixClaim(claims.GetNotBefore)
+
+	return result, nil
+}
+
+func unixClaim(get func() (*jwt.NumericDate, error)) int64 {
+	date, err := get()
+	if err != nil || date == nil {
+		return 0
+	}
+
+	return date.Unix()
+}
@@ -2,14 +2,11 @@ package api
 
 import (
 	"auth/models"
+	"context"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"strings"
-	"time"
-
-	"github.com/golang-jwt/jwt/v5"
 )
 
 func (app *Application) JwtAuthentication(w http.ResponseWriter, r *http.Request) {
@@ -36,43 +33,53 @@ func (app *Application) JwtAuthentication(w http.ResponseWriter, r *http.Request
 	}
 
 	//validateuser
-	usr, userID, err := app.DB.ValidUserByLonginUser(&user.UserAuth)
+	usr, err := app.verifyLogin(r, &user.UserAuth)
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.loginErrorJSON(w, err)
 		return
 	}
 
 	//get jwt secret
-	secretKey, err := app.DB.GetJwtSecret(userID, user.ThirdPartySecrets[0].KeyName)
+	secretKey, err := app.jwtSecret(r.Context(), usr.ID.Hex(), user.ThirdPartySecrets[0].KeyName)
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, err, "secret key not found")
 		return
 	}
 
-	secretKey = app.Decrypt(app.Domain+app.AppID, secretKey)
-
-	app.JwtAuth.TokenRefreshCache[userID] = JwtAuthCache{
-		Secret: secretKey,
-		Count:  0,
+	//optional openid connect parameters
+	opts := &TokenOptions{
+		Scope: parseScope(r.URL.Query().Get("scope")),
+		Nonce: r.URL.Query().Get("nonce"),
 	}
 
-	//init jwt
-	app.JwtAuth.TokenExpiry = time.Minute * 15
-	app.JwtAuth.RefreshExpiry = time.Hour * 24
+	//the tokens wait for the second factor at /mfa/verify
+	if app.mfaRequired(usr) {
+		challenge := models.MFAChallenge{
+			KeyName: user.ThirdPartySecrets[0].KeyName,
+			Scope:   opts.Scope,
+			Nonce:   opts.Nonce,
+		}
 
-	tokens, err := app.JwtAuth.GenerateTopenPair(usr)
+		required, err := app.newMFAChallenge(r.Context(), usr, challenge)
 
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		if err != nil {
+			log.Println(err.Error())
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		resp := JSONResponse{
+			Error:   false,
+			Message: "mfa required",
+			Data:    required,
+		}
+		app.writeJSON(w, http.StatusOK, resp)
 		return
 	}
 
-	jwtAuthCatch := app.JwtAuth.TokenRefreshCache[userID]
-	jwtAuthCatch.RefreshToken = tokens.Token.PlainText
-
-	app.JwtAuth.TokenRefreshCache[userID] = jwtAuthCatch
+	tokens, err := app.JwtAuth.GenerateTopenPair(r.Context(), usr, secretKey, opts)
 
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
@@ -88,6 +95,31 @@ func (app *Application) JwtAuthentication(w http.ResponseWriter, r *http.Request
 	app.writeJSON(w, http.StatusOK, resp)
 }
 
+// jwtSecret returns the stored third party secret of keyName, one stored
+// before ENCRYPTION_KEY is encrypted with it on the way
+func (app *Application) jwtSecret(ctx context.Context, userID string, keyName string) (string, error) {
+	secret, err := app.DB.GetJwtSecret(ctx, userID, keyName)
+
+	if err != nil {
+		return "", err
+	}
+
+	_, resealed, err := app.JwtAuth.openSecret(secret)
+
+	if err != nil || resealed == "" {
+		return secret, nil
+	}
+
+	err = app.DB.ReplaceJwtSecret(ctx, userID, keyName, secret, resealed)
+
+	if err != nil {
+		log.Println(err.Error())
+		return secret, nil
+	}
+
+	return resealed, nil
+}
+
 func (app *Application) RegisterJwt(w http.ResponseWriter, r *http.Request) {
 	updateJwtRegister(w, r, app, app.DbOperations.Create)
 }
@@ -129,27 +161,35 @@ func updateJwtRegister(w http.ResponseWriter, r *http.Request, app *Application,
 		return
 	}
 
-	user.ThirdPartySecrets[0].KeyValue = app.Encrypt(app.Domain+app.AppID, user.ThirdPartySecrets[0].KeyValue)
+	user.ThirdPartySecrets[0].KeyValue = app.Encrypt(app.EncryptionKey, user.ThirdPartySecrets[0].KeyValue)
 
 	//validate user
-	userDetails, usrID, err := app.DB.ValidUserByLonginUser(&user.UserAuth)
+	userDetails, err := app.verifyLogin(r, &user.UserAuth)
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.loginErrorJSON(w, err)
 		return
 	}
 
 	//only admin user can register key
-	if userDetails.UserAuth.Scope.Role.RoleNmae != "admin_user" {
+	if userDetails.UserAuth.Scope.Role.RoleNmae != AdminRole {
 		app.errorJSON(w, errors.New("not admin user"), http.StatusBadRequest)
 		return
 	}
 
-	res, err := app.DB.UpdateThirdPartySecretsByID(usrID, user.ThirdPartySecrets, operation)
+	//user_auth.mfa_code takes a TOTP or recovery code
+	if !app.checkMFAJSON(w, r, userDetails, user.UserAuth.MFACode) {
+		return
+	}
+
+	if operation == app.DbOperations.Create {
+		err = app.DB.AddThirdPartySecret(r.Context(), userDetails.ID.Hex(), user.ThirdPartySecrets[0])
+	} else {
+		err = app.DB.UpdateThirdPartySecret(r.Context(), userDetails.ID.Hex(), user.ThirdPartySecrets[0])
+	}
 
 	if err != nil {
-		log.Println(err.Error())
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, err, "secret key not found")
 		return
 	}
 
@@ -164,106 +204,52 @@ func updateJwtRegister(w http.ResponseWriter, r *http.Request, app *Application,
 	resp := JSONResponse{
 		Error:   false,
 		Message: msg,
-		Data:    res,
 	}
 
 	app.writeJSON(w, http.StatusOK, resp)
 }
 
+// Jwks publishes the public signing keys
+func (app *Application) Jwks(w http.ResponseWriter, r *http.Request) {
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	app.writeJSON(w, http.StatusOK, app.JwtAuth.Keys.JWKS(), headers)
+}
+
 func (app *Application) TestJwt(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("here")
 }
 
 func (app *Application) RefreshJwtauth(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("userID")
-
 	authHeader := r.Header.Get("Authorization")
 
 	//slpit the header
 	headerParts := strings.Split(authHeader, " ")
 
-	refreshtokenStr := headerParts[1]
-
-	secret := app.JwtAuth.TokenRefreshCache[userID].Secret
-
-	//parse token
-	jwtRefreshToken, err := jwt.Parse(refreshtokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(secret), nil
-	})
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusExpectationFailed)
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		app.unauthorizedJSON(w, ErrInvalidAuthHeader)
 		return
 	}
 
-	//
-	jwtCache := app.JwtAuth.TokenRefreshCache[userID]
-
-	refcnt := jwtCache.Count
-
-	if err != nil {
-		app.errorJSON(w, errors.New("refresh token expiried"), http.StatusExpectationFailed)
-		return
-	}
-
-	if refcnt > app.MaxRefreshToken {
-		//remove the cache
-		delete(app.JwtAuth.TokenRefreshCache, userID)
-		app.errorJSON(w, errors.New("refresh token expiried"), http.StatusExpectationFailed)
-		return
-	}
-
-	origToken := app.JwtAuth.TokenRefreshCache[userID].RefreshToken
-
-	origJwtToken, err := jwt.Parse(origToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(secret), nil
-	})
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusExpectationFailed)
-		return
-	}
-
-	origJwtClaims := origJwtToken.Claims.(jwt.MapClaims)
-
-	origJwtClaims["iat"] = time.Now().UTC().Unix()
+	refreshtokenStr := headerParts[1]
 
-	//set expriry for JWT
-	origJwtClaims["exp"] = time.Now().UTC().Add(app.JwtAuth.TokenExpiry).Unix()
-	//create singed token
-	signedAccessToken, err := origJwtToken.SignedString([]byte(secret))
+	//parse token
+	jwtRefreshClaims, err := app.JwtAuth.VerifyToken(r.Context(), refreshtokenStr, TokenUseRefresh)
 
 	if err != nil {
 		app.errorJSON(w, err, http.StatusExpectationFailed)
 		return
 	}
 
-	jwtCache.RefreshToken = signedAccessToken
-
-	jwtCache.Count += 1
-	app.JwtAuth.TokenRefreshCache[userID] = jwtCache
+	tokenPairs, err := app.JwtAuth.RotateRefreshToken(r.Context(), jwtRefreshClaims)
 
-	signedRefreshAccessToken, err := jwtRefreshToken.SignedString([]byte(secret))
 	if err != nil {
 		app.errorJSON(w, err, http.StatusExpectationFailed)
 		return
 	}
 
-	//create toke pairs with signed tokens
-	var tokenPairs = models.TokenPairs{
-		Token:        models.Token{PlainText: signedAccessToken, Expiry: app.JwtAuth.TokenExpiry / time.Minute},
-		RefreshToken: models.Token{PlainText: signedRefreshAccessToken, Expiry: app.JwtAuth.RefreshExpiry / time.Hour},
-	}
-
 	resp := JSONResponse{
 
 		Error:   false,
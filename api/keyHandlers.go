@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+func (app *Application) ListSigningKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.KeyRepo.ListSigningKeys(r.Context())
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "signing keys",
+		Data:    keys,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) RotateSigningKeys(w http.ResponseWriter, r *http.Request) {
+	immediate := r.URL.Query().Get("immediate") == "true"
+
+	key, err := app.RotateSigningKey(r.Context(), immediate)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := app.encodeSigningKey(key)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "signing key rotated",
+		Data:    stored,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Application) RevokeSigningKeys(w http.ResponseWriter, r *http.Request) {
+	kid := chi.URLParam(r, "kid")
+
+	err := app.RevokeSigningKey(r.Context(), kid)
+
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "signing key revoked",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
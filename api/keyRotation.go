@@ -0,0 +1,408 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// replicas share the key states, the holder of this lease moves them forward
+// and rotates. It is renewed every tick, so it only moves when a holder stops
+const (
+	keyLease    = "signing_keys"
+	keyLeaseTTL = 3 * time.Minute
+
+	keyReloadInterval = time.Second
+)
+
+// InitSigningKeys loads the stored keys, imports JWT_SIGNING_KEY_FILE once
+// and makes sure there is an active key
+func (app *Application) InitSigningKeys(ctx context.Context) error {
+	app.keyMu.Lock()
+	defer app.keyMu.Unlock()
+
+	err := app.loadSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if file := os.Getenv("JWT_SIGNING_KEY_FILE"); file != "" {
+		pemBytes, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		key, err := ParseSigningKey(app.JwtAuth.SigningAlg, pemBytes)
+		if err != nil {
+			return err
+		}
+
+		stored, err := app.signingKeyStored(ctx, key.Kid)
+		if err != nil {
+			return err
+		}
+
+		//a retired or revoked key stays that way across restarts
+		if !stored {
+			key.State = models.KeyActive
+			if err := app.saveSigningKey(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	leader, err := app.acquireKeyLease(ctx)
+	if err != nil {
+		return err
+	}
+
+	//another replica moves the keys, only make sure there is one to sign with
+	if !leader {
+		if _, err := app.JwtAuth.Keys.Current(); err == nil {
+			return nil
+		}
+	}
+
+	return app.advanceSigningKeys(ctx)
+}
+
+// RotateSigningKey creates a new key, it is published as pending first unless
+// immediate is set or there is no active key yet
+func (app *Application) RotateSigningKey(ctx context.Context, immediate bool) (*SigningKey, error) {
+	app.keyMu.Lock()
+	defer app.keyMu.Unlock()
+
+	key, err := app.rotateSigningKey(ctx, immediate)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, app.advanceSigningKeys(ctx)
+}
+
+// RevokeSigningKey stops key from signing and verifying at once
+func (app *Application) RevokeSigningKey(ctx context.Context, kid string) error {
+	app.keyMu.Lock()
+	defer app.keyMu.Unlock()
+
+	var key *SigningKey
+	for _, k := range app.JwtAuth.Keys.List() {
+		if k.Kid == kid {
+			copyKey := *k
+			key = &copyKey
+		}
+	}
+
+	if key == nil {
+		return ErrUnknownKey
+	}
+
+	key.State = models.KeyRevoked
+	key.ExpireAt = time.Now().UTC()
+
+	err := app.saveSigningKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return app.advanceSigningKeys(ctx)
+}
+
+func (app *Application) KeyRotationWorker() {
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			if err := app.tickSigningKeys(context.Background()); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+}
+
+// reload keys shared with other replicas, the lease holder moves states
+// forward and rotates when due
+func (app *Application) tickSigningKeys(ctx context.Context) error {
+	app.keyMu.Lock()
+	defer app.keyMu.Unlock()
+
+	err := app.loadSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	leader, err := app.acquireKeyLease(ctx)
+	if err != nil || !leader {
+		return err
+	}
+
+	if app.KeyRotation > 0 {
+		var newest *SigningKey
+		for _, k := range app.JwtAuth.Keys.List() {
+			if k.State != models.KeyPending && k.State != models.KeyActive {
+				continue
+			}
+			if newest == nil || k.CreatedAt.After(newest.CreatedAt) {
+				newest = k
+			}
+		}
+
+		if newest != nil && time.Since(newest.CreatedAt) >= app.KeyRotation {
+			log.Println("rotating signing key", newest.Kid)
+
+			if _, err := app.rotateSigningKey(ctx, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return app.advanceSigningKeys(ctx)
+}
+
+func (app *Application) acquireKeyLease(ctx context.Context) (bool, error) {
+	return app.KeyRepo.AcquireLease(ctx, keyLease, app.keyHolder, primitive.NewDateTimeFromTime(time.Now()), keyLeaseTTL)
+}
+
+func (app *Application) rotateSigningKey(ctx context.Context, immediate bool) (*SigningKey, error) {
+	key, err := NewSigningKey(app.JwtAuth.SigningAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	key.State = models.KeyActive
+
+	if _, err := app.JwtAuth.Keys.Current(); err == nil && !immediate && app.KeyPrePublish > 0 {
+		key.State = models.KeyPending
+		key.ActivateAt = key.CreatedAt.Add(app.KeyPrePublish)
+	}
+
+	err = app.saveSigningKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// activate due pending keys, retire older active keys and drop retiring keys
+// once the longest lived token signed with them has expired
+func (app *Application) advanceSigningKeys(ctx context.Context) error {
+	now := time.Now().UTC()
+	retireAfter := app.JwtAuth.TokenExpiry + app.JwtAuth.RefreshExpiry
+
+	keys := []*SigningKey{}
+	for _, k := range app.JwtAuth.Keys.List() {
+		copyKey := *k
+		keys = append(keys, &copyKey)
+	}
+
+	changed := map[string]bool{}
+	var current *SigningKey
+
+	for _, k := range keys {
+		if k.State == models.KeyPending && !now.Before(k.ActivateAt) {
+			k.State = models.KeyActive
+			changed[k.Kid] = true
+		}
+
+		//keys are sorted by activation, the last active one wins
+		if k.State == models.KeyActive {
+			current = k
+		}
+	}
+
+	//never leave the service without a signing key
+	if current == nil {
+		key, err := app.rotateSigningKey(ctx, true)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		current = key
+	}
+
+	for _, k := range keys {
+		if k.State == models.KeyActive && k.Kid != current.Kid {
+			k.State = models.KeyRetiring
+			k.RetireAt = now
+			k.ExpireAt = now.Add(retireAfter)
+			changed[k.Kid] = true
+		}
+
+		if k.State == models.KeyRetiring && !now.Before(k.ExpireAt) {
+			k.State = models.KeyRevoked
+			changed[k.Kid] = true
+		}
+	}
+
+	for _, k := range keys {
+		if !changed[k.Kid] {
+			continue
+		}
+
+		err := app.saveSigningKey(ctx, k)
+		if err != nil {
+			return err
+		}
+	}
+
+	app.JwtAuth.Keys.Replace(keys)
+
+	return nil
+}
+
+// signingKeyStored reports whether kid was ever stored, in any state
+func (app *Application) signingKeyStored(ctx context.Context, kid string) (bool, error) {
+	stored, err := app.KeyRepo.ListSigningKeys(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, s := range stored {
+		if s.Kid == kid {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// reloadSigningKeys picks up keys other replicas added, tokens with unknown
+// kids call it so it runs at most once per keyReloadInterval
+func (app *Application) reloadSigningKeys(ctx context.Context) error {
+	app.keyMu.Lock()
+	defer app.keyMu.Unlock()
+
+	if time.Since(app.keyReloaded) < keyReloadInterval {
+		return nil
+	}
+
+	app.keyReloaded = time.Now()
+
+	return app.loadSigningKeys(ctx)
+}
+
+func (app *Application) loadSigningKeys(ctx context.Context) error {
+	stored, err := app.KeyRepo.ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := []*SigningKey{}
+	for _, s := range stored {
+		if s.State == models.KeyRevoked {
+			continue
+		}
+
+		key, err := app.decodeSigningKey(&s)
+		if err != nil {
+			log.Println("skip signing key", s.Kid, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	app.JwtAuth.Keys.Replace(keys)
+
+	return nil
+}
+
+func (app *Application) saveSigningKey(ctx context.Context, key *SigningKey) error {
+	stored, err := app.encodeSigningKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = app.KeyRepo.SaveSigningKey(ctx, stored)
+	if err != nil {
+		return err
+	}
+
+	if key.State == models.KeyRevoked {
+		app.JwtAuth.Keys.Replace(removeKey(app.JwtAuth.Keys.List(), key.Kid))
+		return nil
+	}
+
+	app.JwtAuth.Keys.Add(key)
+
+	return nil
+}
+
+func (app *Application) encodeSigningKey(key *SigningKey) (*models.SigningKey, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return nil, err
+	}
+
+	pemStr := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	return &models.SigningKey{
+		Kid:        key.Kid,
+		Alg:        key.Alg,
+		State:      key.State,
+		PrivateKey: app.Encrypt(app.EncryptionKey, pemStr),
+		CreatedAt:  dateTime(key.CreatedAt),
+		ActivateAt: dateTime(key.ActivateAt),
+		RetireAt:   dateTime(key.RetireAt),
+		ExpireAt:   dateTime(key.ExpireAt),
+	}, nil
+}
+
+func (app *Application) decodeSigningKey(stored *models.SigningKey) (*SigningKey, error) {
+	pemStr, _, err := app.JwtAuth.openSecret(stored.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseSigningKey(stored.Alg, []byte(pemStr))
+	if err != nil {
+		return nil, err
+	}
+
+	if key.Kid != stored.Kid {
+		return nil, errors.New("signing key does not match its kid")
+	}
+
+	key.State = stored.State
+	key.CreatedAt = timeOf(stored.CreatedAt)
+	key.ActivateAt = timeOf(stored.ActivateAt)
+	key.RetireAt = timeOf(stored.RetireAt)
+	key.ExpireAt = timeOf(stored.ExpireAt)
+
+	return key, nil
+}
+
+func removeKey(keys []*SigningKey, kid string) []*SigningKey {
+	kept := []*SigningKey{}
+	for _, k := range keys {
+		if k.Kid != kid {
+			kept = append(kept, k)
+		}
+	}
+
+	return kept
+}
+
+// zero times are stored as zero
+func dateTime(t time.Time) primitive.DateTime {
+	if t.IsZero() {
+		return 0
+	}
+
+	return primitive.NewDateTimeFromTime(t)
+}
+
+func timeOf(d primitive.DateTime) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+
+	return d.Time().UTC()
+}
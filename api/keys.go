@@ -0,0 +1,309 @@
+package api
+
+import (
+	"auth/models"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+
+// SigningKey is a service managed asymmetric key
+type SigningKey struct {
+	Kid        string
+	Alg        string
+	State      string
+	Private    crypto.Signer
+	CreatedAt  time.Time
+	ActivateAt time.Time
+	RetireAt   time.Time
+	ExpireAt   time.Time
+}
+
+// KeySet holds the service signing keys by kid
+type KeySet struct {
+	mu   sync.RWMutex
+	keys map[string]*SigningKey
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: map[string]*SigningKey{}}
+}
+
+// Add stores key, a key without state becomes active
+func (ks *KeySet) Add(key *SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key.State == "" {
+		key.State = models.KeyActive
+	}
+	ks.keys[key.Kid] = key
+}
+
+// Replace swaps the whole set
+func (ks *KeySet) Replace(keys []*SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keys = map[string]*SigningKey{}
+	for _, key := range keys {
+		ks.keys[key.Kid] = key
+	}
+}
+
+// Get returns a key that may still verify tokens
+func (ks *KeySet) Get(kid string) (*SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	if !ok || !key.verifies(time.Now().UTC()) {
+		return nil, false
+	}
+
+	return key, true
+}
+
+// Current returns the newest active key, new tokens are signed with it
+func (ks *KeySet) Current() (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var current *SigningKey
+	for _, key := range ks.keys {
+		if key.State != models.KeyActive {
+			continue
+		}
+		if current == nil || key.ActivateAt.After(current.ActivateAt) {
+			current = key
+		}
+	}
+
+	if current == nil {
+		return nil, ErrUnknownKey
+	}
+
+	return current, nil
+}
+
+// JWKS returns the public part of every pending, active and retiring key
+func (ks *KeySet) JWKS() models.JWKSet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := time.Now().UTC()
+	set := models.JWKSet{Keys: []models.JWK{}}
+	for _, key := range ks.keys {
+		if key.State != models.KeyPending && !key.verifies(now) {
+			continue
+		}
+
+		jwk, err := key.JWK()
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// List returns every key sorted by activation time
+func (ks *KeySet) List() []*SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ActivateAt.Before(keys[j].ActivateAt)
+	})
+
+	return keys
+}
+
+// NewSigningKey generates a key for alg (RS256, ES256 or EdDSA)
+func NewSigningKey(alg string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(alg, private)
+}
+
+// ParseSigningKey reads a PEM encoded private key (PKCS8, PKCS1 or SEC1)
+func ParseSigningKey(alg string, pemBytes []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var private interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedAlg
+	}
+
+	return newSigningKey(alg, signer)
+}
+
+func newSigningKey(alg string, private crypto.Signer) (*SigningKey, error) {
+	now := time.Now().UTC()
+	key := &SigningKey{
+		Alg:        alg,
+		Private:    private,
+		CreatedAt:  now,
+		ActivateAt: now,
+	}
+
+	//the key must match the algorithm
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		if alg != jwt.SigningMethodRS256.Alg() {
+			return nil, ErrUnsupportedAlg
+		}
+	case *ecdsa.PrivateKey:
+		if alg != jwt.SigningMethodES256.Alg() || private.(*ecdsa.PrivateKey).Curve != elliptic.P256() {
+			return nil, ErrUnsupportedAlg
+		}
+	case ed25519.PrivateKey:
+		if alg != jwt.SigningMethodEdDSA.Alg() {
+			return nil, ErrUnsupportedAlg
+		}
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+
+	kid, err := key.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	key.Kid = kid
+
+	return key, nil
+}
+
+// active keys and retiring keys inside their window verify tokens. Pending
+// keys verify from ActivateAt on, the replica that activates them may sign
+// with them before the others reloaded
+func (k *SigningKey) verifies(now time.Time) bool {
+	switch k.State {
+	case models.KeyActive:
+		return true
+	case models.KeyPending:
+		return !now.Before(k.ActivateAt)
+	case models.KeyRetiring:
+		return k.ExpireAt.IsZero() || now.Before(k.ExpireAt)
+	default:
+		return false
+	}
+}
+
+func (k *SigningKey) Method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Alg)
+}
+
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// JWK returns the public key as a json web key
+func (k *SigningKey) JWK() (models.JWK, error) {
+	jwk := models.JWK{Kid: k.Kid, Use: "sig", Alg: k.Alg}
+
+	switch pub := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return jwk, ErrUnsupportedAlg
+	}
+
+	return jwk, nil
+}
+
+// kid is the RFC 7638 thumbprint of the public key
+func (k *SigningKey) thumbprint() (string, error) {
+	jwk, err := k.JWK()
+	if err != nil {
+		return "", err
+	}
+
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Crv, jwk.X)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+
+	return b64(sum[:]), nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidLogin = errors.New("invalid login id or password")
+
+// LoginThrottledError is returned while an account or address has to wait
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "too many failed logins, try again later"
+}
+
+// LoginLockout slows down password guessing. After DelayAfter failures of an
+// account every try has to wait BaseDelay, doubled per failure up to
+// MaxDelay, and at MaxFailures the account is locked for Lockout. An address
+// with IPMaxFailures failures is throttled until its count expires. Counts
+// are forgotten Window after the last failure, unknown login ids are counted
+// like real ones
+type LoginLockout struct {
+	Store         repositores.LoginAttemptStore
+	Window        time.Duration
+	DelayAfter    int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	MaxFailures   int
+	Lockout       time.Duration
+	IPMaxFailures int
+}
+
+// the key of an account, login ids are only unique per tenant
+func accountKey(userAuth *models.UserAuth) string {
+	scope := userAuth.Scope
+	return "account:" + hashToken(scope.Domain+"/"+scope.AppID+"/"+userAuth.LoginID)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// window keeps a count at least as long as its lockout
+func (l *LoginLockout) window() time.Duration {
+	if l.Window < l.Lockout {
+		return l.Lockout
+	}
+
+	return l.Window
+}
+
+// accountWait is how long an account has to wait after its failures
+func (l *LoginLockout) accountWait(attempts *models.LoginAttempts, now time.Time) time.Duration {
+	var wait time.Duration
+
+	switch {
+	case attempts.Failures >= l.MaxFailures:
+		wait = l.Lockout
+	case attempts.Failures >= l.DelayAfter:
+		wait = l.BaseDelay
+		for i := l.DelayAfter; i < attempts.Failures && wait < l.MaxDelay; i++ {
+			wait *= 2
+		}
+
+		if wait > l.MaxDelay {
+			wait = l.MaxDelay
+		}
+	}
+
+	return attempts.LastFailure.Time().Add(wait).Sub(now)
+}
+
+// Reserve counts a try of ip and account as failed before the password is
+// checked, so parallel tries can not all pass a check made before any of
+// them failed. It returns how long the failures before this try still have
+// to wait, zero when the try is allowed, and the failures of the account
+// with this try. A try that has to wait is taken back
+func (l *LoginLockout) Reserve(ctx context.Context, ip string, account string) (time.Duration, int, error) {
+	now := time.Now()
+	var wait time.Duration
+
+	if ip != "" {
+		attempts, err := l.Store.AddLoginFailure(ctx, ipKey(ip), primitive.NewDateTimeFromTime(now), l.window())
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if attempts.Failures > l.IPMaxFailures {
+			wait = attempts.PreviousFailure.Time().Add(l.window()).Sub(now)
+		}
+	}
+
+	attempts, err := l.Store.AddLoginFailure(ctx, account, primitive.NewDateTimeFromTime(now), l.window())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	//the count as it was before this try
+	before := &models.LoginAttempts{Failures: attempts.Failures - 1, LastFailure: attempts.PreviousFailure}
+
+	if accountWait := l.accountWait(before, now); accountWait > wait {
+		wait = accountWait
+	}
+
+	if wait <= 0 {
+		return 0, attempts.Failures, nil
+	}
+
+	if err := l.Release(ctx, ip, account); err != nil {
+		return 0, 0, err
+	}
+
+	return wait, attempts.Failures - 1, nil
+}
+
+// Release takes back the try Reserve counted for ip and, when set, account
+// once the password turned out right
+func (l *LoginLockout) Release(ctx context.Context, ip string, account string) error {
+	if ip != "" {
+		if err := l.Store.RemoveLoginFailure(ctx, ipKey(ip)); err != nil {
+			return err
+		}
+	}
+
+	if account == "" {
+		return nil
+	}
+
+	return l.Store.RemoveLoginFailure(ctx, account)
+}
+
+// Failed counts a failure of ip and account and reports whether it locked
+// the account
+func (l *LoginLockout) Failed(ctx context.Context, ip string, account string) (bool, error) {
+	now := primitive.NewDateTimeFromTime(time.Now())
+
+	if ip != "" {
+		_, err := l.Store.AddLoginFailure(ctx, ipKey(ip), now, l.window())
+		if err != nil {
+			return false, err
+		}
+	}
+
+	attempts, err := l.Store.AddLoginFailure(ctx, account, now, l.window())
+	if err != nil {
+		return false, err
+	}
+
+	return attempts.Failures == l.MaxFailures, nil
+}
+
+// Reset forgets the failures of account after a complete login or an unlock
+func (l *LoginLockout) Reset(ctx context.Context, account string) error {
+	return l.Store.ResetLoginAttempts(ctx, account)
+}
+
+// clientIP is the address of the caller, behind a proxy TRUST_PROXY takes the
+// last X-Forwarded-For entry, the one the proxy added
+func (app *Application) clientIP(r *http.Request) string {
+	if app.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// verifyLogin checks the password of userAuth behind the lockout. Unknown
+// users and wrong passwords fail alike with ErrInvalidLogin, the counter of
+// users with a second factor is reset once that is verified too
+func (app *Application) verifyLogin(r *http.Request, userAuth *models.UserAuth) (*models.User, error) {
+	ctx := r.Context()
+	ip := app.clientIP(r)
+	account := accountKey(userAuth)
+
+	wait, failures, err := app.Lockout.Reserve(ctx, ip, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if wait > 0 {
+		logSecurityEvent("login_throttled", "login_id", userAuth.LoginID, "ip", ip)
+		return nil, &LoginThrottledError{RetryAfter: wait}
+	}
+
+	usr, err := app.DB.ValidUserByLonginUser(ctx, userAuth)
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		//take as long as a wrong password
+		app.dummyPasswordCheck(userAuth.Password)
+	}
+
+	//the reserved try is the failure
+	if errors.Is(err, repositores.ErrNotFound) || errors.Is(err, repositores.ErrInvalidCredentials) {
+		logSecurityEvent("login_failed", "login_id", userAuth.LoginID, "ip", ip)
+
+		if failures == app.Lockout.MaxFailures {
+			logSecurityEvent("account_locked", "login_id", userAuth.LoginID, "ip", ip)
+		}
+
+		return nil, ErrInvalidLogin
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if app.mfaRequired(usr) {
+		err = app.Lockout.Release(ctx, ip, account)
+	} else {
+		err = app.Lockout.Release(ctx, ip, "")
+		if err == nil {
+			err = app.Lockout.Reset(ctx, account)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return usr, nil
+}
+
+// mfaFailed counts a wrong second factor against the account, the password
+// step stays locked until it expires
+func (app *Application) mfaFailed(ctx context.Context, usr *models.User) {
+	locked, err := app.Lockout.Failed(ctx, "", accountKey(&usr.UserAuth))
+
+	if err != nil {
+		logSecurityEvent("lockout_error", "user", usr.ID.Hex(), "reason", err.Error())
+		return
+	}
+
+	if locked {
+		logSecurityEvent("account_locked", "user", usr.ID.Hex())
+	}
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordCheck verifies password against a throwaway hash with the
+// current settings
+func (app *Application) dummyPasswordCheck(password string) {
+	if app.Hasher == nil {
+		return
+	}
+
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = app.Hasher.Hash(newTokenID())
+	})
+
+	app.Hasher.Verify(dummyHash, password)
+}
+
+// retryAfter rounds a wait up to whole seconds for the Retry-After header
+func retryAfter(wait time.Duration) string {
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return fmt.Sprint(seconds)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func (ta *testApp) login(loginID string, password string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+
+	return ta.do(http.MethodPost, "/login", userAuth(loginID, password))
+}
+
+// expectRetryAfter checks a 429 and returns its Retry-After in seconds
+func expectRetryAfter(t *testing.T, w *httptest.ResponseRecorder) int {
+	t.Helper()
+
+	expectStatus(t, w, http.StatusTooManyRequests)
+
+	seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || seconds < 1 {
+		t.Fatalf("Retry-After %q, want seconds", w.Header().Get("Retry-After"))
+	}
+
+	return seconds
+}
+
+func TestLoginDelay(t *testing.T) {
+	ta := newTestApp(t)
+	ta.signin("alice")
+
+	for i := 0; i < ta.Lockout.DelayAfter; i++ {
+		expectStatus(t, ta.login("alice", "wrong"), http.StatusUnauthorized)
+	}
+
+	//even the right password has to wait
+	seconds := expectRetryAfter(t, ta.login("alice", testPassword))
+
+	if seconds != 1 {
+		t.Fatalf("Retry-After %d, want 1", seconds)
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+	id := ta.signin("alice")
+
+	ta.Lockout.DelayAfter = 5
+	ta.Lockout.MaxFailures = 5
+
+	for i := 0; i < ta.Lockout.MaxFailures; i++ {
+		expectStatus(t, ta.login("alice", "wrong"), http.StatusUnauthorized)
+	}
+
+	seconds := expectRetryAfter(t, ta.login("alice", testPassword))
+
+	if seconds < int(ta.Lockout.Lockout.Seconds())-5 {
+		t.Fatalf("Retry-After %d, want the lockout of %s", seconds, ta.Lockout.Lockout)
+	}
+
+	//other accounts of the address are not locked
+	expectStatus(t, ta.login(testAdmin, testPassword), http.StatusOK)
+
+	w := ta.do(http.MethodPost, "/admin/users/"+id+"/unlock", nil, "Authorization", bearer(ta.tokens().Token.PlainText))
+	expectStatus(t, w, http.StatusOK)
+
+	expectStatus(t, ta.login("alice", testPassword), http.StatusOK)
+}
+
+func TestUnknownLoginIDIsLocked(t *testing.T) {
+	ta := newTestApp(t)
+
+	for i := 0; i < ta.Lockout.DelayAfter; i++ {
+		expectStatus(t, ta.login("nobody", "wrong"), http.StatusUnauthorized)
+	}
+
+	//no answer tells an unknown user from a real one
+	expectRetryAfter(t, ta.login("nobody", "wrong"))
+}
+
+func TestAddressLockout(t *testing.T) {
+	ta := newTestApp(t)
+	ta.signin("alice")
+
+	ta.Lockout.IPMaxFailures = 3
+
+	for _, loginID := range []string{"bob", "carol", "dave"} {
+		expectStatus(t, ta.login(loginID, "wrong"), http.StatusUnauthorized)
+	}
+
+	expectRetryAfter(t, ta.login("alice", testPassword))
+}
+
+func TestLoginResetsFailures(t *testing.T) {
+	ta := newTestApp(t)
+	ta.signin("alice")
+
+	for i := 0; i < ta.Lockout.DelayAfter-1; i++ {
+		expectStatus(t, ta.login("alice", "wrong"), http.StatusUnauthorized)
+	}
+
+	expectStatus(t, ta.login("alice", testPassword), http.StatusOK)
+
+	for i := 0; i < ta.Lockout.DelayAfter-1; i++ {
+		expectStatus(t, ta.login("alice", "wrong"), http.StatusUnauthorized)
+	}
+
+	expectStatus(t, ta.login("alice", testPassword), http.StatusOK)
+}
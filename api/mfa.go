@@ -0,0 +1,363 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	mfaChallengeExpiry = 5 * time.Minute
+	mfaMaxAttempts     = 5
+	recoveryCodeCount  = 10
+	recoveryCodeBytes  = 10 //80 bits, too many to guess against the unsalted hash
+)
+
+var (
+	ErrMFACodeRequired       = errors.New("mfa code required")
+	ErrInvalidMFACode        = errors.New("invalid mfa code")
+	ErrInvalidMFAToken       = errors.New("invalid or expired mfa token")
+	ErrMFAEnrollmentRequired = errors.New("mfa enrollment required")
+	ErrMFAEnabled            = errors.New("mfa is already enabled")
+	ErrMFANotEnabled         = errors.New("mfa is not enabled")
+	ErrMFANotPending         = errors.New("no pending mfa enrollment")
+	ErrMFARequired           = errors.New("mfa is required for this role")
+)
+
+// MFAPolicy lists the roles that need a second factor by "domain/app", the
+// "*" entry applies to every tenant
+type MFAPolicy map[string][]string
+
+// parseMFAPolicy reads "domain/app:role,role;*:admin_user"
+func parseMFAPolicy(value string) (MFAPolicy, error) {
+	policy := MFAPolicy{}
+
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		tenant, roles, ok := strings.Cut(entry, ":")
+		tenant = strings.TrimSpace(tenant)
+
+		if !ok || (tenant != "*" && !strings.Contains(tenant, "/")) {
+			return nil, errors.New("invalid mfa policy entry " + entry)
+		}
+
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				policy[tenant] = append(policy[tenant], role)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// Requires reports whether the role of scope has to use a second factor
+func (p MFAPolicy) Requires(scope *models.UserScope) bool {
+	for _, tenant := range []string{scope.Domain + "/" + scope.AppID, "*"} {
+		for _, role := range p[tenant] {
+			if role == scope.Role.RoleNmae {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (app *Application) mfaRequired(usr *models.User) bool {
+	return usr.MFA.Enabled || app.MFAPolicy.Requires(&usr.UserAuth.Scope)
+}
+
+// newMFAChallenge stores the rest of a login until the second factor, only
+// the hash of the returned token is kept. Users without MFA get an
+// enrollment challenge
+func (app *Application) newMFAChallenge(ctx context.Context, usr *models.User, challenge models.MFAChallenge) (*models.MFARequired, error) {
+	token := newTokenID() + newTokenID()
+
+	challenge.ID = hashToken(token)
+	challenge.UserID = usr.ID.Hex()
+	challenge.Enroll = !usr.MFA.Enabled
+	challenge.ExpiresAt = primitive.NewDateTimeFromTime(time.Now().Add(mfaChallengeExpiry))
+
+	err := app.MFAChallenges.SaveMFAChallenge(ctx, &challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := []string{models.MFAMethodTOTP}
+	if usr.MFA.Enabled {
+		methods = append(methods, models.MFAMethodRecoveryCode)
+	}
+
+	return &models.MFARequired{
+		MFAToken:           token,
+		Methods:            methods,
+		EnrollmentRequired: challenge.Enroll,
+		ExpiresIn:          int64(mfaChallengeExpiry / time.Second),
+	}, nil
+}
+
+// attemptMFAChallenge counts a try on the challenge of token
+func (app *Application) attemptMFAChallenge(ctx context.Context, token string) (*models.MFAChallenge, error) {
+	challenge, err := app.MFAChallenges.AttemptMFAChallenge(ctx, hashToken(token), mfaMaxAttempts)
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		return nil, ErrInvalidMFAToken
+	}
+
+	return challenge, err
+}
+
+// finishMFAChallenge makes sure only one request completes the login
+func (app *Application) finishMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) error {
+	err := app.MFAChallenges.DeleteMFAChallenge(ctx, challenge.ID)
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		return ErrInvalidMFAToken
+	}
+
+	return err
+}
+
+// verifyMFA accepts a TOTP code once or uses up a recovery code
+func (app *Application) verifyMFA(ctx context.Context, usr *models.User, code string, recoveryCode string) error {
+	if !usr.MFA.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	userID := usr.ID.Hex()
+
+	if recoveryCode != "" {
+		err := app.DB.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+
+		if errors.Is(err, repositores.ErrNotFound) {
+			logSecurityEvent("mfa_failed", "user", userID, "method", models.MFAMethodRecoveryCode)
+			app.mfaFailed(ctx, usr)
+			return ErrInvalidMFACode
+		}
+
+		if err != nil {
+			return err
+		}
+
+		logSecurityEvent("recovery_code_used", "user", userID)
+
+		return app.Lockout.Reset(ctx, accountKey(&usr.UserAuth))
+	}
+
+	if code == "" {
+		return ErrMFACodeRequired
+	}
+
+	secret, _, err := app.JwtAuth.openSecret(usr.MFA.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := validTOTP(secret, code, time.Now())
+
+	if !ok {
+		logSecurityEvent("mfa_failed", "user", userID, "method", models.MFAMethodTOTP)
+		app.mfaFailed(ctx, usr)
+		return ErrInvalidMFACode
+	}
+
+	err = app.DB.UseTOTPStep(ctx, userID, step)
+
+	//the code was already used
+	if errors.Is(err, repositores.ErrConflict) {
+		logSecurityEvent("mfa_replayed", "user", userID)
+		app.mfaFailed(ctx, usr)
+		return ErrInvalidMFACode
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return app.Lockout.Reset(ctx, accountKey(&usr.UserAuth))
+}
+
+// verifyMFACode is verifyMFA for a single field, six digits are a TOTP
+// code and anything else a recovery code
+func (app *Application) verifyMFACode(ctx context.Context, usr *models.User, code string) error {
+	code = strings.TrimSpace(code)
+
+	if code == "" || isDigits(code) {
+		return app.verifyMFA(ctx, usr, code, "")
+	}
+
+	return app.verifyMFA(ctx, usr, "", code)
+}
+
+// checkMFA is the second factor of the password only endpoints, users the
+// policy covers have to enroll first
+func (app *Application) checkMFA(ctx context.Context, usr *models.User, code string) error {
+	if !app.mfaRequired(usr) {
+		return nil
+	}
+
+	if !usr.MFA.Enabled {
+		return ErrMFAEnrollmentRequired
+	}
+
+	return app.verifyMFACode(ctx, usr, code)
+}
+
+// checkMFAJSON answers for checkMFA, a user who has to enroll gets a
+// challenge to do it with
+func (app *Application) checkMFAJSON(w http.ResponseWriter, r *http.Request, usr *models.User, code string) bool {
+	err := app.checkMFA(r.Context(), usr, code)
+
+	if err == nil {
+		return true
+	}
+
+	if !errors.Is(err, ErrMFAEnrollmentRequired) {
+		app.mfaErrorJSON(w, err)
+		return false
+	}
+
+	required, err := app.newMFAChallenge(r.Context(), usr, models.MFAChallenge{})
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return false
+	}
+
+	resp := JSONResponse{
+		Error:   true,
+		Message: ErrMFAEnrollmentRequired.Error(),
+		Data:    required,
+	}
+
+	app.writeJSON(w, http.StatusForbidden, resp)
+	return false
+}
+
+// startTOTPEnrollment keeps a new secret pending until a code confirms it
+func (app *Application) startTOTPEnrollment(ctx context.Context, usr *models.User) (*models.TOTPEnrollment, error) {
+	if usr.MFA.Enabled {
+		return nil, ErrMFAEnabled
+	}
+
+	secret := newTOTPSecret()
+
+	mfa := models.MFA{PendingSecret: encrypt(app.EncryptionKey, secret)}
+
+	err := app.DB.SetMFA(ctx, usr.ID.Hex(), &mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TOTPEnrollment{
+		Secret:     secret,
+		OtpauthURI: totpURI(app.MFAIssuer, usr.UserAuth.LoginID, secret),
+	}, nil
+}
+
+// confirmTOTP enables the pending secret and returns new recovery codes,
+// they are only shown this once
+func (app *Application) confirmTOTP(ctx context.Context, usr *models.User, code string) ([]string, error) {
+	if usr.MFA.Enabled {
+		return nil, ErrMFAEnabled
+	}
+
+	if usr.MFA.PendingSecret == "" {
+		return nil, ErrMFANotPending
+	}
+
+	secret, _, err := app.JwtAuth.openSecret(usr.MFA.PendingSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := validTOTP(secret, strings.TrimSpace(code), time.Now())
+
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes := newRecoveryCodes()
+
+	mfa := models.MFA{
+		Enabled:       true,
+		Secret:        usr.MFA.PendingSecret,
+		LastStep:      step,
+		RecoveryCodes: hashes,
+	}
+
+	err = app.DB.SetMFA(ctx, usr.ID.Hex(), &mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	logSecurityEvent("mfa_enabled", "user", usr.ID.Hex())
+
+	return codes, nil
+}
+
+// newRecoveryCodes returns codes like "k3x9-p2mq-7wze-d4ha" and their hashes
+func newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		rand.Read(b)
+
+		code := strings.ToLower(encoding.EncodeToString(b))
+
+		groups := []string{}
+		for j := 0; j < len(code); j += 4 {
+			groups = append(groups, code[j:j+4])
+		}
+
+		codes[i] = strings.Join(groups, "-")
+		hashes[i] = hashToken(code)
+	}
+
+	return codes, hashes
+}
+
+// users may type recovery codes in any case and without the dash
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mfaErrorJSON maps the second factor errors to status codes
+func (app *Application) mfaErrorJSON(w http.ResponseWriter, err error) error {
+	switch {
+	case errors.Is(err, ErrMFACodeRequired), errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrInvalidMFAToken):
+		return app.errorJSON(w, err, http.StatusUnauthorized)
+	case errors.Is(err, ErrMFAEnrollmentRequired), errors.Is(err, ErrMFARequired):
+		return app.errorJSON(w, err, http.StatusForbidden)
+	case errors.Is(err, ErrMFAEnabled), errors.Is(err, ErrMFANotEnabled), errors.Is(err, ErrMFANotPending):
+		return app.errorJSON(w, err, http.StatusConflict)
+	}
+
+	return app.dbErrorJSON(w, err, "user not found")
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// VerifyMFA finishes a /jwtauth login with the mfa_token and a TOTP or
+// recovery code. An enrollment challenge confirms the new TOTP instead and
+// also returns the recovery codes
+func (app *Application) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var verification models.MFAVerification
+	err := app.readJSON(w, r, &verification)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(verification)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := app.attemptMFAChallenge(r.Context(), verification.MFAToken)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	//authorization code logins finish on the login form
+	if challenge.ClientID != "" {
+		app.mfaErrorJSON(w, ErrInvalidMFAToken)
+		return
+	}
+
+	usr, err := app.userByID(r.Context(), challenge.UserID)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	if usr.Disabled {
+		app.dbErrorJSON(w, repositores.ErrUserDisabled, "user not found")
+		return
+	}
+
+	//enrollments started by /login or /registerJwt end without tokens
+	var secretKey string
+
+	if challenge.KeyName != "" {
+		secretKey, err = app.jwtSecret(r.Context(), challenge.UserID, challenge.KeyName)
+
+		if err != nil {
+			app.dbErrorJSON(w, err, "secret key not found")
+			return
+		}
+	}
+
+	var recoveryCodes []string
+
+	if challenge.Enroll {
+		recoveryCodes, err = app.confirmTOTP(r.Context(), usr, verification.Code)
+	} else {
+		err = app.verifyMFA(r.Context(), usr, verification.Code, verification.RecoveryCode)
+	}
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	err = app.finishMFAChallenge(r.Context(), challenge)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	enabled := models.MFAEnabled{RecoveryCodes: recoveryCodes}
+
+	if challenge.KeyName != "" {
+		opts := &TokenOptions{
+			Scope: challenge.Scope,
+			Nonce: challenge.Nonce,
+		}
+
+		enabled.Tokens, err = app.JwtAuth.GenerateTopenPair(r.Context(), usr, secretKey, opts)
+
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "mfa enabled",
+		Data:    enabled,
+	}
+
+	if !challenge.Enroll {
+		resp.Message = "jwt token"
+		resp.Data = enabled.Tokens
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// EnrollMFAChallenge starts the TOTP setup of a user the policy stopped at
+// login, the code then goes to /mfa/verify
+func (app *Application) EnrollMFAChallenge(w http.ResponseWriter, r *http.Request) {
+	var req models.MFAEnrollmentRequest
+	err := app.readJSON(w, r, &req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := app.attemptMFAChallenge(r.Context(), req.MFAToken)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	if !challenge.Enroll {
+		app.mfaErrorJSON(w, ErrMFAEnabled)
+		return
+	}
+
+	usr, err := app.userByID(r.Context(), challenge.UserID)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	enrollment, err := app.startTOTPEnrollment(r.Context(), usr)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "totp enrollment",
+		Data:    enrollment,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// StartTOTPEnrollment creates a pending TOTP secret for the user of the
+// bearer access token
+func (app *Application) StartTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	enrollment, err := app.startTOTPEnrollment(r.Context(), usr)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "totp enrollment",
+		Data:    enrollment,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// ConfirmTOTPEnrollment enables MFA with a code of the pending secret
+func (app *Application) ConfirmTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	var req models.MFACode
+	err := app.readJSON(w, r, &req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" {
+		app.mfaErrorJSON(w, ErrMFACodeRequired)
+		return
+	}
+
+	recoveryCodes, err := app.confirmTOTP(r.Context(), usr, req.Code)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "mfa enabled",
+		Data:    models.MFAEnabled{RecoveryCodes: recoveryCodes},
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// DisableMFA turns MFA off after a last code, not for roles the policy covers
+func (app *Application) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	var req models.MFACode
+	err := app.readJSON(w, r, &req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if app.MFAPolicy.Requires(&usr.UserAuth.Scope) {
+		app.mfaErrorJSON(w, ErrMFARequired)
+		return
+	}
+
+	err = app.verifyMFA(r.Context(), usr, req.Code, req.RecoveryCode)
+
+	if err != nil {
+		app.mfaErrorJSON(w, err)
+		return
+	}
+
+	err = app.DB.SetMFA(r.Context(), usr.ID.Hex(), &models.MFA{})
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	logSecurityEvent("mfa_disabled", "user", usr.ID.Hex())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "mfa disabled",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// ResetUserMFA removes the second factor of a user who lost it, a user the
+// policy covers has to enroll again on the next login
+func (app *Application) ResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	err := app.DB.SetMFA(r.Context(), userID, &models.MFA{})
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	logSecurityEvent("mfa_reset", "user", userID, "admin", r.Header.Get("userID"))
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "mfa reset",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
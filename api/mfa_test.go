@@ -0,0 +1,149 @@
+package api
+
+import (
+	"auth/models"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// totpAt is the code of secret for step
+func totpAt(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return totpCode(key, step)
+}
+
+// enrollTOTP turns on TOTP for the holder of accessToken with a code of the
+// current step and returns the secret, that step and the recovery codes
+func (ta *testApp) enrollTOTP(accessToken string) (string, int64, []string) {
+	ta.t.Helper()
+
+	w := ta.do(http.MethodPost, "/me/mfa/totp", nil, "Authorization", bearer(accessToken))
+	expectStatus(ta.t, w, http.StatusOK)
+
+	var enrollment struct {
+		Data models.TOTPEnrollment `json:"data"`
+	}
+	decode(ta.t, w, &enrollment)
+
+	step := time.Now().Unix() / totpPeriod
+	code := totpAt(ta.t, enrollment.Data.Secret, step)
+
+	w = ta.do(http.MethodPost, "/me/mfa/totp/confirm", map[string]string{"code": code}, "Authorization", bearer(accessToken))
+	expectStatus(ta.t, w, http.StatusOK)
+
+	var enabled struct {
+		Data models.MFAEnabled `json:"data"`
+	}
+	decode(ta.t, w, &enabled)
+
+	if len(enabled.Data.RecoveryCodes) != recoveryCodeCount {
+		ta.t.Fatalf("%d recovery codes, want %d", len(enabled.Data.RecoveryCodes), recoveryCodeCount)
+	}
+
+	return enrollment.Data.Secret, step, enabled.Data.RecoveryCodes
+}
+
+// mfaToken starts a /jwtauth login that waits for the second factor
+func (ta *testApp) mfaToken() string {
+	ta.t.Helper()
+
+	w := ta.jwtauth()
+	expectStatus(ta.t, w, http.StatusOK)
+
+	var resp struct {
+		Data models.MFARequired `json:"data"`
+	}
+	decode(ta.t, w, &resp)
+
+	if resp.Data.MFAToken == "" {
+		ta.t.Fatalf("no mfa_token in %s", w.Body.String())
+	}
+
+	return resp.Data.MFAToken
+}
+
+func TestTOTPLogin(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	secret, step, _ := ta.enrollTOTP(ta.tokens().Token.PlainText)
+
+	w := ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: ta.mfaToken(), Code: "000000"})
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: ta.mfaToken(), Code: totpAt(t, secret, step+1)})
+	expectStatus(t, w, http.StatusOK)
+
+	tokens := decodeTokens(t, w)
+
+	w = ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(tokens.Token.PlainText))
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestTOTPReplay(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	secret, step, _ := ta.enrollTOTP(ta.tokens().Token.PlainText)
+
+	//the code that confirmed the enrollment is spent
+	w := ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: ta.mfaToken(), Code: totpAt(t, secret, step)})
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	code := totpAt(t, secret, step+1)
+
+	w = ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: ta.mfaToken(), Code: code})
+	expectStatus(t, w, http.StatusOK)
+
+	w = ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: ta.mfaToken(), Code: code})
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestRecoveryCodeWorksOnce(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	_, _, recoveryCodes := ta.enrollTOTP(ta.tokens().Token.PlainText)
+
+	w := ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: ta.mfaToken(), RecoveryCode: recoveryCodes[0]})
+	expectStatus(t, w, http.StatusOK)
+	decodeTokens(t, w)
+
+	w = ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: ta.mfaToken(), RecoveryCode: recoveryCodes[0]})
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	//the others still work, typed without dashes in upper case
+	other := normalizeRecoveryCode(recoveryCodes[1])
+
+	w = ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: ta.mfaToken(), RecoveryCode: strings.ToUpper(other)})
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestMFATokenWorksOnce(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	secret, step, _ := ta.enrollTOTP(ta.tokens().Token.PlainText)
+	mfaToken := ta.mfaToken()
+
+	w := ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: mfaToken, Code: totpAt(t, secret, step+1)})
+	expectStatus(t, w, http.StatusOK)
+
+	w = ta.do(http.MethodPost, "/mfa/verify", models.MFAVerification{MFAToken: mfaToken, Code: totpAt(t, secret, step+2)})
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	var resp JSONResponse
+	decode(t, w, &resp)
+
+	if resp.Message != ErrInvalidMFAToken.Error() {
+		t.Fatalf("message %q, want %q", resp.Message, ErrInvalidMFAToken.Error())
+	}
+}
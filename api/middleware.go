@@ -1,6 +1,8 @@
 package api
 
 import (
+	"auth/repositores"
+	"errors"
 	"net/http"
 )
 
@@ -10,7 +12,7 @@ func (app *Application) enableCORS(h http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization, If-Match")
 			return
 		} else {
 			h.ServeHTTP(w, r)
@@ -23,12 +25,48 @@ func (app *Application) authRequired(h http.Handler) http.Handler {
 		_, clailms, err := app.JwtAuth.GetTokenFromHeaderAndVerify(w, r)
 
 		if err != nil {
-			app.errorJSON(w, err, http.StatusUnauthorized)
+			app.unauthorizedJSON(w, err)
 			return
 		}
 
-		if clailms["aud"] == nil {
-			r.Header.Set("userID", clailms["sub"].(string))
+		//never trust these from the client
+		r.Header.Del("userID")
+		r.Header.Del("userRole")
+
+		//client_credentials tokens carry the client as sub, not a user
+		if sub, ok := clailms["sub"].(string); ok && sub != clailms["client_id"] {
+			r.Header.Set("userID", sub)
+		}
+
+		if role, ok := clailms["role"].(string); ok {
+			r.Header.Set("userRole", role)
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// must run after authRequired, the role is read from the stored user so a
+// demoted or disabled admin loses access before the token expires
+func (app *Application) adminRequired(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("userID")
+
+		if userID == "" {
+			app.errorJSON(w, errors.New("not admin user"), http.StatusForbidden)
+			return
+		}
+
+		usr, err := app.userByID(r.Context(), userID)
+
+		if err != nil && !errors.Is(err, repositores.ErrNotFound) {
+			app.dbErrorJSON(w, err, "user not found")
+			return
+		}
+
+		if err != nil || usr.Disabled || usr.UserAuth.Scope.Role.RoleNmae != AdminRole {
+			app.errorJSON(w, errors.New("not admin user"), http.StatusForbidden)
+			return
 		}
 
 		h.ServeHTTP(w, r)
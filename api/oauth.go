@@ -0,0 +1,401 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const authCodeExpiry = 2 * time.Minute
+
+// grantError is an oauth error answered by the token endpoint
+type grantError struct {
+	status      int
+	code        string
+	description string
+}
+
+func (e *grantError) Error() string {
+	return e.code + ": " + e.description
+}
+
+func invalidGrant(description string) error {
+	return &grantError{http.StatusBadRequest, "invalid_grant", description}
+}
+
+func unauthorizedClient() error {
+	return &grantError{http.StatusBadRequest, "unauthorized_client", "grant type is not allowed for the client"}
+}
+
+func invalidRequest(description string) error {
+	return &grantError{http.StatusBadRequest, "invalid_request", description}
+}
+
+// authorizeRequest holds the parameters of /authorize
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	scopes              []string
+}
+
+func newAuthorizeRequest(values url.Values) *authorizeRequest {
+	return &authorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+// params to carry through the login form
+func (req *authorizeRequest) params() map[string]string {
+	return map[string]string{
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	}
+}
+
+// authorizeClient checks the client and redirect uri, its errors must not be
+// sent to the redirect uri
+func (app *Application) authorizeClient(ctx context.Context, req *authorizeRequest) (*models.Client, error) {
+	client, err := app.DB.GetClientByID(ctx, req.ClientID)
+
+	if err != nil {
+		if errors.Is(err, repositores.ErrNotFound) {
+			return nil, errors.New("unknown client")
+		}
+		return nil, err
+	}
+
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		return nil, errors.New("redirect_uri is not registered for the client")
+	}
+
+	return client, nil
+}
+
+// validate returns the oauth error code and description sent to the client
+func (req *authorizeRequest) validate(client *models.Client) (string, string) {
+	if req.ResponseType != "code" {
+		return "unsupported_response_type", "only response_type code is supported"
+	}
+
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return "unauthorized_client", "client may not use the authorization code flow"
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return "invalid_request", "code_challenge with method S256 is required"
+	}
+
+	scopes, ok := clientScope(client, req.Scope)
+	if !ok {
+		return "invalid_scope", "scope is not allowed for the client"
+	}
+	req.scopes = scopes
+
+	return "", ""
+}
+
+// clientScope splits a scope parameter, every scope must be allowed for the client
+func clientScope(client *models.Client, scope string) ([]string, bool) {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if !client.AllowsScope(s) {
+			return nil, false
+		}
+		if !hasScope(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes, true
+}
+
+// issueAuthCode stores a single use code for usr, only its hash is kept
+func (app *Application) issueAuthCode(ctx context.Context, req *authorizeRequest, usr *models.User) (string, error) {
+	code := newTokenID() + newTokenID()
+	now := time.Now().UTC()
+
+	entry := models.AuthCode{
+		ID:                  hashToken(code),
+		ClientID:            req.ClientID,
+		UserID:              usr.ID.Hex(),
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.scopes,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            primitive.NewDateTimeFromTime(now),
+		ExpiresAt:           primitive.NewDateTimeFromTime(now.Add(authCodeExpiry)),
+	}
+
+	err := app.OAuth.SaveAuthCode(ctx, &entry)
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// authorizationCodeGrant exchanges a code and its PKCE verifier for tokens
+func (app *Application) authorizationCodeGrant(r *http.Request) (*models.TokenResponse, error) {
+	code := r.PostForm.Get("code")
+	redirectURI := r.PostForm.Get("redirect_uri")
+	verifier := r.PostForm.Get("code_verifier")
+
+	if code == "" || redirectURI == "" || verifier == "" {
+		return nil, invalidRequest("code, redirect_uri and code_verifier are required")
+	}
+
+	client, err := app.tokenClient(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return nil, unauthorizedClient()
+	}
+
+	clientID := client.ClientID
+
+	//the code is only spent by the client that can prove it owns it
+	entry, err := app.OAuth.GetAuthCode(r.Context(), hashToken(code))
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		return nil, invalidGrant("unknown or expired code")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.ClientID != clientID || entry.RedirectURI != redirectURI {
+		return nil, invalidGrant("code was issued to another client or redirect_uri")
+	}
+
+	if !verifyPKCE(entry.CodeChallenge, verifier) {
+		return nil, invalidGrant("code_verifier does not match")
+	}
+
+	entry, err = app.OAuth.UseAuthCode(r.Context(), entry.ID)
+
+	switch {
+	case errors.Is(err, repositores.ErrConflict):
+		//a replayed code takes the tokens it got with it
+		logSecurityEvent("auth_code_reuse", "client", entry.ClientID, "user", entry.UserID)
+
+		if entry.FamilyID != "" {
+			if err := app.JwtAuth.RevokeFamily(r.Context(), entry.FamilyID); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, invalidGrant("code was already used")
+	case errors.Is(err, repositores.ErrNotFound):
+		return nil, invalidGrant("unknown or expired code")
+	case err != nil:
+		return nil, err
+	}
+
+	usr, err := app.userByID(r.Context(), entry.UserID)
+
+	if err != nil {
+		return nil, invalidGrant("unknown user")
+	}
+
+	opts := &TokenOptions{
+		Scope:    entry.Scope,
+		Nonce:    entry.Nonce,
+		ClientID: clientID,
+		AuthTime: entry.AuthTime.Time(),
+	}
+
+	tokenPairs, err := app.JwtAuth.GenerateTopenPair(r.Context(), usr, "", opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//remember the login so a replay can revoke it
+	entry.FamilyID = tokenPairs.SessionID
+
+	err = app.OAuth.SaveAuthCode(r.Context(), entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.tokenResponse(tokenPairs, opts.Scope), nil
+}
+
+// refreshTokenGrant rotates a refresh token through the token endpoint
+func (app *Application) refreshTokenGrant(r *http.Request) (*models.TokenResponse, error) {
+	refreshToken := r.PostForm.Get("refresh_token")
+
+	if refreshToken == "" {
+		return nil, invalidRequest("refresh_token is required")
+	}
+
+	claims, err := app.JwtAuth.VerifyToken(r.Context(), refreshToken, TokenUseRefresh)
+
+	if err != nil {
+		return nil, invalidGrant(err.Error())
+	}
+
+	entry, isRefresh, err := app.JwtAuth.refreshEntry(r.Context(), claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRefresh {
+		return nil, invalidGrant("not a refresh token")
+	}
+
+	//refresh tokens are bound to the client they were issued to
+	if entry.ClientID != "" {
+		client, err := app.tokenClient(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !client.AllowsGrant(models.GrantRefreshToken) {
+			return nil, unauthorizedClient()
+		}
+
+		if entry.ClientID != client.ClientID {
+			return nil, invalidGrant("refresh token was issued to another client")
+		}
+	}
+
+	tokenPairs, err := app.JwtAuth.RotateRefreshToken(r.Context(), claims)
+
+	if err != nil {
+		return nil, invalidGrant(err.Error())
+	}
+
+	accessClaims, err := app.JwtAuth.VerifyToken(r.Context(), tokenPairs.Token.PlainText, TokenUseAccess)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return app.tokenResponse(tokenPairs, scopeClaim(accessClaims)), nil
+}
+
+// clientCredentialsGrant issues an access token to a confidential client acting
+// on its own behalf
+func (app *Application) clientCredentialsGrant(r *http.Request) (*models.TokenResponse, error) {
+	client, err := app.authenticateClient(r)
+
+	if err != nil {
+		if errors.Is(err, ErrInvalidClient) {
+			return nil, &grantError{http.StatusUnauthorized, "invalid_client", "client authentication failed"}
+		}
+		return nil, err
+	}
+
+	if !client.AllowsGrant(models.GrantClientCredentials) {
+		return nil, unauthorizedClient()
+	}
+
+	scopes := client.Scopes
+	if r.PostForm.Get("scope") != "" {
+		var ok bool
+		scopes, ok = clientScope(client, r.PostForm.Get("scope"))
+
+		if !ok {
+			return nil, &grantError{http.StatusBadRequest, "invalid_scope", "scope is not allowed for the client"}
+		}
+	}
+
+	accessToken, err := app.JwtAuth.GenerateClientToken(r.Context(), client, scopes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(app.JwtAuth.TokenExpiry / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+func (app *Application) tokenResponse(tokenPairs *models.TokenPairs, scope []string) *models.TokenResponse {
+	return &models.TokenResponse{
+		AccessToken:  tokenPairs.Token.PlainText,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(app.JwtAuth.TokenExpiry / time.Second),
+		RefreshToken: tokenPairs.RefreshToken.PlainText,
+		IDToken:      tokenPairs.IDToken,
+		Scope:        strings.Join(scope, " "),
+	}
+}
+
+// S256 is the only method accepted
+func verifyPKCE(challenge string, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// codes and other bearer secrets are stored by their hash
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// redirect to uri with params added to its query
+func redirectWith(w http.ResponseWriter, r *http.Request, uri string, params url.Values) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	query := target.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			query.Set(k, v[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
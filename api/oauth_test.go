@@ -0,0 +1,214 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const (
+	testRedirectURI = "https://app.test/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K1uhbUJU1p1r_wW1gFWFOEjXkAA"
+)
+
+// publicClient registers a public authorization code client of the test
+// tenant
+func (ta *testApp) publicClient() *models.Client {
+	ta.t.Helper()
+
+	client := &models.Client{
+		ClientID:     newTokenID(),
+		Name:         "test app",
+		Public:       true,
+		GrantTypes:   []string{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		Scopes:       supportedScopes,
+		RedirectURIs: []string{testRedirectURI},
+		Domain:       testDomain,
+		AppID:        testAppID,
+	}
+
+	err := ta.DB.CreateClient(context.Background(), client)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+
+	return client
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize logs in on the login form and returns the code of the redirect
+func (ta *testApp) authorize(client *models.Client, challenge string) string {
+	ta.t.Helper()
+
+	w := ta.postForm("/authorize", url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"login_id":              {testAdmin},
+		"password":              {testPassword},
+	})
+	expectStatus(ta.t, w, http.StatusFound)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+
+	query := location.Query()
+
+	if query.Get("state") != "xyz" || query.Get("code") == "" {
+		ta.t.Fatalf("redirect %s, want a code and the state", location)
+	}
+
+	return query.Get("code")
+}
+
+func (ta *testApp) exchangeCode(client *models.Client, code string, verifier string) *httptest.ResponseRecorder {
+	return ta.postForm("/token", url.Values{
+		"grant_type":    {models.GrantAuthorizationCode},
+		"client_id":     {client.ClientID},
+		"redirect_uri":  {testRedirectURI},
+		"code":          {code},
+		"code_verifier": {verifier},
+	})
+}
+
+func expectOAuthError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+
+	expectStatus(t, w, status)
+
+	var resp OAuthError
+	decode(t, w, &resp)
+
+	if resp.Error != code {
+		t.Fatalf("error %q, want %q", resp.Error, code)
+	}
+}
+
+func TestAuthorizationCodeWithPKCE(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+	client := ta.publicClient()
+
+	code := ta.authorize(client, codeChallenge(testVerifier))
+
+	w := ta.exchangeCode(client, code, testVerifier)
+	expectStatus(t, w, http.StatusOK)
+
+	var tokens models.TokenResponse
+	decode(t, w, &tokens)
+
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.IDToken == "" {
+		t.Fatalf("missing tokens in %s", w.Body.String())
+	}
+
+	w = ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(tokens.AccessToken))
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestAuthorizeRequiresPKCE(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+	client := ta.publicClient()
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {client.ClientID},
+		"redirect_uri":  {testRedirectURI},
+		"state":         {"xyz"},
+	}
+
+	w := ta.do(http.MethodGet, "/authorize?"+query.Encode(), nil)
+	expectStatus(t, w, http.StatusFound)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if location.Query().Get("error") != "invalid_request" {
+		t.Fatalf("redirect %s, want invalid_request", location)
+	}
+}
+
+func TestPKCEFailure(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+	client := ta.publicClient()
+
+	code := ta.authorize(client, codeChallenge(testVerifier))
+
+	for _, verifier := range []string{
+		"wrong-verifier-of-the-right-length-0123456789abcdef",
+		testVerifier[:42],
+		"",
+	} {
+		w := ta.exchangeCode(client, code, verifier)
+
+		if verifier == "" {
+			expectOAuthError(t, w, http.StatusBadRequest, "invalid_request")
+			continue
+		}
+
+		expectOAuthError(t, w, http.StatusBadRequest, "invalid_grant")
+	}
+
+	//a failed verifier does not spend the code of the real client
+	w := ta.exchangeCode(client, code, testVerifier)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestAuthorizationCodeReplay(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+	client := ta.publicClient()
+
+	code := ta.authorize(client, codeChallenge(testVerifier))
+
+	w := ta.exchangeCode(client, code, testVerifier)
+	expectStatus(t, w, http.StatusOK)
+
+	var tokens models.TokenResponse
+	decode(t, w, &tokens)
+
+	w = ta.exchangeCode(client, code, testVerifier)
+	expectOAuthError(t, w, http.StatusBadRequest, "invalid_grant")
+
+	//the replay takes the tokens of the first exchange with it
+	w = ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(tokens.AccessToken))
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = ta.postForm("/token", url.Values{
+		"grant_type":    {models.GrantRefreshToken},
+		"client_id":     {client.ClientID},
+		"refresh_token": {tokens.RefreshToken},
+	})
+	expectOAuthError(t, w, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestAuthorizationCodeOtherClient(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+	client := ta.publicClient()
+	other := ta.publicClient()
+
+	code := ta.authorize(client, codeChallenge(testVerifier))
+
+	w := ta.exchangeCode(other, code, testVerifier)
+	expectOAuthError(t, w, http.StatusBadRequest, "invalid_grant")
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// scopes understood by the provider
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopePhone   = "phone"
+	ScopeAddress = "address"
+)
+
+var supportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopePhone, ScopeAddress}
+
+// TokenOptions are the optional parts of a token request
+type TokenOptions struct {
+	Scope    []string
+	Nonce    string
+	ClientID string
+	AuthTime time.Time
+}
+
+func (o *TokenOptions) HasScope(scope string) bool {
+	return o != nil && hasScope(o.Scope, scope)
+}
+
+// parseScope splits a scope parameter and drops unknown scopes
+func parseScope(scope string) []string {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if hasScope(supportedScopes, s) && !hasScope(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scopeClaim reads the space separated scope claim of a token
+func scopeClaim(claims jwt.MapClaims) []string {
+	scope, _ := claims["scope"].(string)
+
+	return strings.Fields(scope)
+}
+
+// userClaims returns the standard OIDC claims of usr allowed by scopes
+func userClaims(usr *models.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": usr.ID.Hex(),
+	}
+
+	profile := usr.Profile
+
+	if hasScope(scopes, ScopeProfile) {
+		claims["name"] = strings.TrimSpace(fmt.Sprintf("%s %s", profile.FisrtName, profile.LastNmae))
+		claims["given_name"] = profile.FisrtName
+		claims["family_name"] = profile.LastNmae
+		claims["preferred_username"] = usr.UserAuth.LoginID
+
+		if usr.UpdatedAt != 0 {
+			claims["updated_at"] = usr.UpdatedAt.Time().Unix()
+		}
+	}
+
+	if hasScope(scopes, ScopeEmail) && profile.Email != "" {
+		claims["email"] = profile.Email
+		claims["email_verified"] = profile.EmailVerified
+	}
+
+	if hasScope(scopes, ScopePhone) && profile.Phone != "" {
+		claims["phone_number"] = profile.Phone
+		claims["phone_number_verified"] = false
+	}
+
+	if hasScope(scopes, ScopeAddress) && profile.Address != (models.Address{}) {
+		address := profile.Address
+		claims["address"] = map[string]string{
+			"formatted":      formatAddress(address),
+			"street_address": address.Street,
+			"locality":       address.City,
+			"region":         address.State,
+			"postal_code":    address.Zipcode,
+		}
+	}
+
+	return claims
+}
+
+func formatAddress(address models.Address) string {
+	lines := []string{}
+	for _, part := range []string{address.Street, strings.TrimSpace(address.City + " " + address.State + " " + address.Zipcode)} {
+		if part != "" {
+			lines = append(lines, part)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// idToken signs the OIDC id_token of usr for the token request
+func (j *JwtAuth) idToken(ctx context.Context, usr *models.User, opts *TokenOptions, kid string, now time.Time) (string, error) {
+	claims := jwt.MapClaims{}
+	for k, v := range userClaims(usr, opts.Scope) {
+		claims[k] = v
+	}
+
+	aud := opts.ClientID
+	if aud == "" {
+		aud = usr.UserAuth.Scope.Domain + "_" + usr.UserAuth.Scope.AppID
+	}
+
+	authTime := opts.AuthTime
+	if authTime.IsZero() {
+		authTime = now
+	}
+
+	claims["iss"] = j.Issuer
+	claims["aud"] = aud
+	claims["jti"] = newTokenID()
+	claims["token_use"] = TokenUseID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(j.TokenExpiry).Unix()
+	claims["auth_time"] = authTime.Unix()
+
+	if opts.ClientID != "" {
+		claims["azp"] = opts.ClientID
+	}
+
+	if opts.Nonce != "" {
+		claims["nonce"] = opts.Nonce
+	}
+
+	return j.SignToken(ctx, kid, claims)
+}
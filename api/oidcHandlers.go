@@ -0,0 +1,97 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+func (app *Application) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	base := app.baseURL(r)
+
+	config := models.OpenIDConfiguration{
+		Issuer:                            app.JwtAuth.Issuer,
+		AuthorizationEndpoint:             base + "/authorize",
+		TokenEndpoint:                     base + "/token",
+		UserinfoEndpoint:                  base + "/userinfo",
+		JwksURI:                           base + "/.well-known/jwks.json",
+		RevocationEndpoint:                base + "/revoke",
+		IntrospectionEndpoint:             base + "/introspect",
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		DeviceAuthorizationEndpoint:       base + "/device/code",
+		GrantTypesSupported:               supportedGrants,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{app.JwtAuth.SigningAlg},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "azp",
+			"name", "given_name", "family_name", "preferred_username", "updated_at",
+			"email", "email_verified", "phone_number", "phone_number_verified", "address",
+		},
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=3600")
+
+	app.writeJSON(w, http.StatusOK, config, headers)
+}
+
+// UserInfo returns the claims of the bearer token's user allowed by its scopes
+func (app *Application) UserInfo(w http.ResponseWriter, r *http.Request) {
+	_, claims, err := app.JwtAuth.GetTokenFromHeaderAndVerify(w, r)
+
+	if err != nil {
+		app.unauthorizedJSON(w, err)
+		return
+	}
+
+	scopes := scopeClaim(claims)
+
+	//refresh tokens carry no scope
+	if !hasScope(scopes, ScopeOpenID) {
+		app.insufficientScopeJSON(w, ScopeOpenID)
+		return
+	}
+
+	sub, _ := claims.GetSubject()
+
+	usr, err := app.userByID(r.Context(), sub)
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		app.unauthorizedJSON(w, ErrTokenSubject)
+		return
+	}
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	app.writeJSON(w, http.StatusOK, userClaims(usr, scopes), headers)
+}
+
+func (app *Application) userByID(ctx context.Context, id string) (*models.User, error) {
+	return app.DB.GetUserByID(ctx, id)
+}
+
+// public url of the service, ISSUER_URL or the request host
+func (app *Application) baseURL(r *http.Request) string {
+	if app.IssuerURL != "" {
+		return strings.TrimRight(app.IssuerURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const passwordResetExpiry = 30 * time.Minute
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// setPassword stores a new password, drops pending reset tokens and ends
+// every session of the user
+func (app *Application) setPassword(ctx context.Context, userID string, password string) error {
+	err := app.DB.UpdatePassword(ctx, userID, password)
+	if err != nil {
+		return err
+	}
+
+	err = app.UserTokens.DeleteUserTokens(ctx, userID, models.TokenPasswordReset)
+	if err != nil {
+		return err
+	}
+
+	return app.JwtAuth.RevokeUser(ctx, userID)
+}
+
+// sendPasswordReset mails a single use reset link to the user's email
+func (app *Application) sendPasswordReset(ctx context.Context, usr *models.User, resetURI string) error {
+	if usr.Profile.Email == "" {
+		return errors.New("user has no email")
+	}
+
+	token, err := app.newUserToken(ctx, usr, models.TokenPasswordReset, passwordResetExpiry)
+	if err != nil {
+		return err
+	}
+
+	link := resetURI + "?" + url.Values{"token": {token}}.Encode()
+
+	return app.Notifier.Notify(ctx, &Notification{
+		Kind:    models.TokenPasswordReset,
+		To:      usr.Profile.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of %s.\n\nOpen %s within %d minutes to choose a new one, "+
+			"or ignore this message to keep the current password.\n", usr.UserAuth.LoginID, link, int(passwordResetExpiry/time.Minute)),
+	})
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// ChangePassword sets a new password for the user of the bearer access token,
+// the current password is required and all sessions end
+func (app *Application) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	var change models.PasswordChange
+	err := app.readJSON(w, r, &change)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(change)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	userAuth := models.UserAuth{
+		LoginID:  usr.UserAuth.LoginID,
+		Password: change.CurrentPassword,
+		Scope:    usr.UserAuth.Scope,
+	}
+
+	_, err = app.verifyLogin(r, &userAuth)
+
+	if errors.Is(err, ErrInvalidLogin) {
+		app.errorJSON(w, errors.New("current password is wrong"), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		app.loginErrorJSON(w, err)
+		return
+	}
+
+	err = app.setPassword(r.Context(), usr.ID.Hex(), change.NewPassword)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	logSecurityEvent("password_changed", "user", usr.ID.Hex())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password changed, log in again",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// RequestPasswordReset sends a reset link, the answer is the same whether the
+// user exists or not
+func (app *Application) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordResetRequest
+	err := app.readJSON(w, r, &req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	userAuth := models.UserAuth{
+		LoginID: req.LoginID,
+		Scope:   models.UserScope{Domain: req.Domain, AppID: req.AppID},
+	}
+
+	if userAuth.Scope.Domain == "" && userAuth.Scope.AppID == "" {
+		userAuth.Scope.Domain = app.Domain
+		userAuth.Scope.AppID = app.AppID
+	}
+
+	usr, err := app.DB.GetUserByLoginID(r.Context(), &userAuth)
+
+	switch {
+	case errors.Is(err, repositores.ErrNotFound):
+		logSecurityEvent("password_reset_unknown_user", "login_id", req.LoginID)
+	case err != nil:
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	case usr.Disabled:
+		logSecurityEvent("password_reset_disabled_user", "user", usr.ID.Hex())
+	default:
+		err = app.sendPasswordReset(r.Context(), usr, app.ResetURI)
+
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			logSecurityEvent("password_reset_requested", "user", usr.ID.Hex())
+		}
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if the user exists a reset link was sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ConfirmPasswordReset sets the new password of a reset token, once
+func (app *Application) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var reset models.PasswordReset
+	err := app.readJSON(w, r, &reset)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(reset)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	entry, err := app.UserTokens.UseUserToken(r.Context(), hashToken(reset.Token), models.TokenPasswordReset)
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		app.errorJSON(w, ErrInvalidResetToken, http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	err = app.setPassword(r.Context(), entry.UserID, reset.NewPassword)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	logSecurityEvent("password_reset", "user", entry.UserID)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password reset, log in again",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errStaleProfile = errors.New("profile was changed, reload it and try again")
+
+// GetMe returns the user of the bearer access token, the ETag is the version
+// PATCH /me expects in If-Match
+func (app *Application) GetMe(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", userETag(usr))
+	headers.Set("Cache-Control", "private, no-cache")
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user",
+		Data:    usr,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp, headers)
+}
+
+// UpdateMe applies a partial profile update if If-Match is still current
+func (app *Application) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	var patch models.ProfilePatch
+	err := app.readJSON(w, r, &patch)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(patch)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+
+	if ifMatch == "" {
+		app.errorJSON(w, errors.New("If-Match header is required"), http.StatusPreconditionRequired)
+		return
+	}
+
+	if !etagMatches(ifMatch, userETag(usr)) {
+		app.errorJSON(w, errStaleProfile, http.StatusPreconditionFailed)
+		return
+	}
+
+	profile := patch.Apply(usr.Profile)
+
+	version, err := app.DB.UpdateUserProfile(r.Context(), usr.ID.Hex(), profile, usr.UpdatedAt)
+
+	if errors.Is(err, repositores.ErrConflict) {
+		app.errorJSON(w, errStaleProfile, http.StatusPreconditionFailed)
+		return
+	}
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	emailChanged := profile.Email != usr.Profile.Email
+
+	usr.Profile = profile
+	usr.UpdatedAt = version
+
+	if emailChanged {
+		err = app.emailChanged(r.Context(), usr, app.VerifyEmailURI)
+
+		if err != nil {
+			app.dbErrorJSON(w, err, "user not found")
+			return
+		}
+
+		logSecurityEvent("email_changed", "user", usr.ID.Hex())
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", userETag(usr))
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "profile updated",
+		Data:    usr,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp, headers)
+}
+
+// currentUser loads the user of the bearer access token or answers 401
+func (app *Application) currentUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	_, claims, err := app.JwtAuth.GetTokenFromHeaderAndVerify(w, r)
+
+	if err != nil {
+		app.unauthorizedJSON(w, err)
+		return nil, false
+	}
+
+	sub, _ := claims.GetSubject()
+
+	usr, err := app.userByID(r.Context(), sub)
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		app.unauthorizedJSON(w, ErrTokenSubject)
+		return nil, false
+	}
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return nil, false
+	}
+
+	return usr, true
+}
+
+// strong etag of the stored version of a user
+func userETag(usr *models.User) string {
+	return `"` + hashToken(usr.ID.Hex()+":"+strconv.FormatInt(int64(usr.UpdatedAt), 10)) + `"`
+}
+
+// If-Match uses the strong comparison, weak tags never match (RFC 9110 13.1.1)
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
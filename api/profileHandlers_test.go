@@ -0,0 +1,87 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateMeIfMatch(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	auth := bearer(ta.tokens().Token.PlainText)
+
+	w := ta.do(http.MethodGet, "/me", nil, "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	patch := map[string]string{"first_name": "Ada"}
+
+	w = ta.do(http.MethodPatch, "/me", patch, "Authorization", auth)
+	expectStatus(t, w, http.StatusPreconditionRequired)
+
+	w = ta.do(http.MethodPatch, "/me", patch, "Authorization", auth, "If-Match", `W/`+etag)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+
+	w = ta.do(http.MethodPatch, "/me", patch, "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusOK)
+
+	next := w.Header().Get("ETag")
+	if next == "" || next == etag {
+		t.Fatalf("ETag %q after the update, want a new one", next)
+	}
+
+	//a second writer with the old version loses
+	w = ta.do(http.MethodPatch, "/me", map[string]string{"first_name": "Grace"}, "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+
+	w = ta.do(http.MethodGet, "/me", nil, "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+
+	var resp struct {
+		Data models.User `json:"data"`
+	}
+	decode(t, w, &resp)
+
+	if resp.Data.Profile.FisrtName != "Ada" || w.Header().Get("ETag") != next {
+		t.Fatalf("profile %+v with ETag %q, want the first update", resp.Data.Profile, w.Header().Get("ETag"))
+	}
+}
+
+func TestUpdateMeConflictsWithConcurrentWrite(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.admin()
+
+	auth := bearer(ta.tokens().Token.PlainText)
+
+	w := ta.do(http.MethodGet, "/me", nil, "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+
+	etag := w.Header().Get("ETag")
+
+	//another request writes after the ETag was read
+	usr, err := ta.DB.GetUserByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := usr.Profile
+	profile.LastNmae = "Lovelace"
+
+	_, err = ta.DB.UpdateUserProfile(context.Background(), id, profile, usr.UpdatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = ta.do(http.MethodPatch, "/me", map[string]string{"first_name": "Ada"}, "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+
+	w = ta.do(http.MethodPatch, "/me", map[string]string{"first_name": "Ada"}, "Authorization", auth, "If-Match", "*")
+	expectStatus(t, w, http.StatusOK)
+}
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// rate limit algorithms
+const (
+	TokenBucket   = "token_bucket"
+	SlidingWindow = "sliding_window"
+)
+
+// what requests are counted by
+const (
+	RateLimitByIP       = "ip"
+	RateLimitByLoginID  = "login_id"
+	RateLimitByClientID = "client_id"
+	RateLimitByTenant   = "tenant"
+)
+
+// defaultRateLimits guard the routes that create accounts, check secrets or
+// send mail, RATE_LIMITS entries replace them by name. Login ids are chosen by
+// the caller, so their limits come with one per address
+const defaultRateLimits = "signin=sliding_window:ip:10/1h;" +
+	"login=token_bucket:login_id:30/1m,sliding_window:ip:300/1m;" +
+	"mfa=token_bucket:ip:10/1m;" +
+	"token=token_bucket:client_id:120/1m;" +
+	"email=sliding_window:login_id:5/1h,sliding_window:ip:50/1h"
+
+var ErrRateLimited = errors.New("too many requests, try again later")
+
+// RateLimit allows Limit requests per Period for every key of a route. A
+// token bucket holds Limit tokens and refills over Period, a sliding window
+// weighs the count of the last window by how much of it is still in Period
+type RateLimit struct {
+	Algorithm string
+	Key       string
+	Limit     int
+	Period    time.Duration
+}
+
+// RateLimits are the limits by route name, "route@domain/app" overrides a
+// route for one tenant. A request has to pass every limit of its route, no
+// limits turn the route off
+type RateLimits map[string][]*RateLimit
+
+// parseRateLimits reads "route=algorithm:key:limit/period" entries over the
+// defaults, "route@domain/app=..." for a tenant and "route=off" to disable.
+// Several limits of a route are separated by ","
+func parseRateLimits(value string) (RateLimits, error) {
+	limits := RateLimits{}
+
+	for _, entry := range strings.Split(defaultRateLimits+";"+value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, spec, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+
+		if !ok || name == "" {
+			return nil, errors.New("invalid rate limit entry " + entry)
+		}
+
+		if route, tenant, ok := strings.Cut(name, "@"); ok && (route == "" || !strings.Contains(tenant, "/")) {
+			return nil, errors.New("invalid rate limit entry " + entry)
+		}
+
+		if spec = strings.TrimSpace(spec); spec == "off" {
+			limits[name] = nil
+			continue
+		}
+
+		var routeLimits []*RateLimit
+		keys := map[string]bool{}
+
+		for _, part := range strings.Split(spec, ",") {
+			limit, err := parseRateLimit(strings.TrimSpace(part))
+			if err != nil {
+				return nil, errors.New("invalid rate limit entry " + entry + ": " + err.Error())
+			}
+
+			//the key names the counter in the store
+			if keys[limit.Key] {
+				return nil, errors.New("invalid rate limit entry " + entry + ": key " + limit.Key + " used twice")
+			}
+
+			keys[limit.Key] = true
+			routeLimits = append(routeLimits, limit)
+		}
+
+		limits[name] = routeLimits
+	}
+
+	return limits, nil
+}
+
+// parseRateLimit reads "algorithm:key:limit/period"
+func parseRateLimit(spec string) (*RateLimit, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return nil, errors.New("want algorithm:key:limit/period")
+	}
+
+	limit := &RateLimit{Algorithm: parts[0], Key: parts[1]}
+
+	switch limit.Algorithm {
+	case TokenBucket, SlidingWindow:
+	default:
+		return nil, errors.New("unknown algorithm " + limit.Algorithm)
+	}
+
+	switch limit.Key {
+	case RateLimitByIP, RateLimitByLoginID, RateLimitByClientID, RateLimitByTenant:
+	default:
+		return nil, errors.New("unknown key " + limit.Key)
+	}
+
+	count, period, ok := strings.Cut(parts[2], "/")
+	if !ok {
+		return nil, errors.New("want limit/period")
+	}
+
+	var err error
+	limit.Limit, err = strconv.Atoi(count)
+	if err != nil || limit.Limit < 1 {
+		return nil, errors.New("limit must be a positive number")
+	}
+
+	limit.Period, err = time.ParseDuration(period)
+	if err != nil || limit.Period < time.Second {
+		return nil, errors.New("period must be a duration of at least 1s")
+	}
+
+	return limit, nil
+}
+
+// For returns the limits of route for the tenant of scope, none when the
+// route is not limited
+func (l RateLimits) For(route string, scope *models.UserScope) []*RateLimit {
+	if limit, ok := l[route+"@"+scope.Domain+"/"+scope.AppID]; ok {
+		return limit
+	}
+
+	return l[route]
+}
+
+// RateLimiter counts requests in the shared store so every replica enforces
+// the same limits
+type RateLimiter struct {
+	Store  repositores.RateLimitStore
+	Limits RateLimits
+}
+
+// rateLimitResult is the state of a key after a request, it fills the
+// RateLimit headers
+type rateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Take counts a request of key against limit
+func (l *RateLimiter) Take(ctx context.Context, key string, limit *RateLimit) (*rateLimitResult, error) {
+	now := time.Now()
+
+	if limit.Algorithm == TokenBucket {
+		return l.takeToken(ctx, key, limit, now)
+	}
+
+	return l.addHit(ctx, key, limit, now)
+}
+
+func (l *RateLimiter) takeToken(ctx context.Context, key string, limit *RateLimit, now time.Time) (*rateLimitResult, error) {
+	rate := float64(limit.Limit) / limit.Period.Seconds()
+
+	bucket, err := l.Store.TakeRateLimitToken(ctx, key, primitive.NewDateTimeFromTime(now), rate, limit.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &rateLimitResult{
+		Allowed:   bucket.Allowed,
+		Remaining: int(bucket.Tokens),
+		Reset:     secondsDuration((float64(limit.Limit) - bucket.Tokens) / rate),
+	}
+
+	if !bucket.Allowed {
+		result.RetryAfter = secondsDuration((1 - bucket.Tokens) / rate)
+	}
+
+	return result, nil
+}
+
+func (l *RateLimiter) addHit(ctx context.Context, key string, limit *RateLimit, now time.Time) (*rateLimitResult, error) {
+	start := now.Truncate(limit.Period)
+
+	counter, err := l.Store.AddRateLimitHit(ctx, key, primitive.NewDateTimeFromTime(start), limit.Period)
+	if err != nil {
+		return nil, err
+	}
+
+	//the last window counts for the part of it still inside the period
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	used := float64(counter.PreviousHits)*weight + float64(counter.Hits)
+
+	result := &rateLimitResult{
+		Allowed:   used <= float64(limit.Limit),
+		Remaining: int(math.Max(0, float64(limit.Limit)-math.Ceil(used))),
+		Reset:     limit.Period - elapsed,
+	}
+
+	if !result.Allowed {
+		result.RetryAfter = slidingWindowWait(limit, counter, elapsed)
+	}
+
+	return result, nil
+}
+
+// slidingWindowWait is how long until one more request fits, either the last
+// window fades out far enough or the current one has to do so in the next
+func slidingWindowWait(limit *RateLimit, counter *models.RateLimitWindow, elapsed time.Duration) time.Duration {
+	period := float64(limit.Period)
+	free := float64(limit.Limit) - 1
+	hits := float64(counter.Hits)
+	previous := float64(counter.PreviousHits)
+
+	if hits <= free && previous > 0 {
+		wait := period*(1-(free-hits)/previous) - float64(elapsed)
+		if wait < period-float64(elapsed) {
+			return time.Duration(math.Max(wait, 0))
+		}
+	}
+
+	var next float64
+	if hits > free {
+		next = period * (1 - free/hits)
+	}
+
+	return time.Duration(period - float64(elapsed) + next)
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// rateLimitRequest are the parts of a request limits are keyed by, ClientID
+// is only set for a client that authenticated
+type rateLimitRequest struct {
+	LoginID  string
+	ClientID string
+	Scope    models.UserScope
+}
+
+// rateLimitBody covers the JSON bodies of the limited routes, login ids and
+// tenants are sent flat, in a scope or in a user_auth
+type rateLimitBody struct {
+	LoginID  string           `json:"login_id"`
+	Domain   string           `json:"user_domain"`
+	AppID    string           `json:"user_app_id"`
+	Scope    models.UserScope `json:"scope"`
+	UserAuth *models.UserAuth `json:"user_auth"`
+}
+
+// rateLimitRequest reads the keys of r from a JSON or form body, the body is
+// put back for the handler
+func (app *Application) rateLimitRequest(r *http.Request) *rateLimitRequest {
+	var req rateLimitRequest
+
+	if r.Body != nil && r.Body != http.NoBody {
+		peeked, err := io.ReadAll(io.LimitReader(r.Body, 1024*1024))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(peeked), r.Body))
+
+		var body rateLimitBody
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+		switch {
+		case err != nil:
+		case json.Unmarshal(peeked, &body) == nil:
+			req.LoginID = body.LoginID
+			req.Scope = models.UserScope{Domain: body.Domain, AppID: body.AppID}
+
+			if body.Scope.Domain != "" || body.Scope.AppID != "" {
+				req.Scope = body.Scope
+			}
+
+			if body.UserAuth != nil {
+				req.LoginID = body.UserAuth.LoginID
+				req.Scope = body.UserAuth.Scope
+			}
+		case mediaType == "application/x-www-form-urlencoded":
+			form, _ := url.ParseQuery(string(peeked))
+			req.LoginID = form.Get("login_id")
+		}
+	}
+
+	if req.Scope.Domain == "" && req.Scope.AppID == "" {
+		req.Scope.Domain = app.Domain
+		req.Scope.AppID = app.AppID
+	}
+
+	return &req
+}
+
+// rateLimitKey is the store key of a request, requests without a login id or
+// an authenticated client are counted by address
+func (app *Application) rateLimitKey(route string, limit *RateLimit, req *rateLimitRequest, ip string) string {
+	kind, value := RateLimitByIP, ip
+	tenant := req.Scope.Domain + "/" + req.Scope.AppID
+
+	switch {
+	case limit.Key == RateLimitByLoginID && req.LoginID != "":
+		//login ids are only unique per tenant
+		kind, value = RateLimitByLoginID, tenant+"/"+req.LoginID
+	case limit.Key == RateLimitByClientID && req.ClientID != "":
+		kind, value = RateLimitByClientID, req.ClientID
+	case limit.Key == RateLimitByTenant:
+		kind, value = RateLimitByTenant, tenant
+	}
+
+	return "rate:" + route + ":" + limit.Key + ":" + kind + ":" + hashToken(value)
+}
+
+// rateLimitClient authenticates the client of a form request for limits by
+// client id. Anyone can send a client_id, so only a checked secret counts and
+// the result is kept for authenticateClient to not check it twice
+func (app *Application) rateLimitClient(r *http.Request, req *rateLimitRequest) *http.Request {
+	if err := r.ParseForm(); err != nil {
+		return r
+	}
+
+	client, err := app.authenticateClient(r)
+
+	if err != nil && !errors.Is(err, ErrInvalidClient) {
+		return r
+	}
+
+	if err == nil {
+		req.ClientID = client.ClientID
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), clientAuthKey{}, &clientAuth{client, err}))
+}
+
+// rateLimit limits the requests of route by the RateLimiter, store errors
+// are logged and let the request through
+func (app *Application) rateLimit(route string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.RateLimiter == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			req := app.rateLimitRequest(r)
+			limits := app.RateLimiter.Limits.For(route, &req.Scope)
+
+			if len(limits) == 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			for _, limit := range limits {
+				if limit.Key == RateLimitByClientID {
+					r = app.rateLimitClient(r, req)
+					break
+				}
+			}
+
+			ip := app.clientIP(r)
+
+			//the headers show the limit closest to running out
+			var tightest *RateLimit
+			var tightestResult *rateLimitResult
+
+			for _, limit := range limits {
+				result, err := app.RateLimiter.Take(r.Context(), app.rateLimitKey(route, limit, req, ip), limit)
+
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+
+				if tightest == nil || !result.Allowed || result.Remaining < tightestResult.Remaining {
+					tightest, tightestResult = limit, result
+				}
+
+				if !result.Allowed {
+					break
+				}
+			}
+
+			if tightest == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(tightestResult.Remaining))
+			w.Header().Set("RateLimit-Reset", retryAfter(tightestResult.Reset))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", tightest.Limit, int64(tightest.Period/time.Second)))
+
+			if !tightestResult.Allowed {
+				logSecurityEvent("rate_limited", "route", route, "key", tightest.Key, "ip", ip)
+				w.Header().Set("Retry-After", retryAfter(tightestResult.RetryAfter))
+				app.errorJSON(w, ErrRateLimited, http.StatusTooManyRequests)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
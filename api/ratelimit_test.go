@@ -0,0 +1,117 @@
+package api
+
+import (
+	"auth/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// limitRoutes replaces the limits of the test app with value over the
+// defaults
+func (ta *testApp) limitRoutes(value string) {
+	ta.t.Helper()
+
+	limits, err := parseRateLimits(value)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+
+	ta.RateLimiter.Limits = limits
+}
+
+func expectRateLimitHeaders(t *testing.T, w *httptest.ResponseRecorder, limit string, remaining string, policy string) {
+	t.Helper()
+
+	for name, want := range map[string]string{
+		"RateLimit-Limit":     limit,
+		"RateLimit-Remaining": remaining,
+		"RateLimit-Policy":    policy,
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Fatalf("%s %q, want %q", name, got, want)
+		}
+	}
+
+	if w.Header().Get("RateLimit-Reset") == "" {
+		t.Fatal("no RateLimit-Reset")
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	ta := newTestApp(t)
+	ta.limitRoutes("signin=sliding_window:ip:2/1m")
+
+	w := ta.do(http.MethodPost, "/signin", map[string]interface{}{"user_auth": userAuth("alice", testPassword)})
+	expectStatus(t, w, http.StatusOK)
+	expectRateLimitHeaders(t, w, "2", "1", "2;w=60")
+
+	w = ta.do(http.MethodPost, "/signin", map[string]interface{}{"user_auth": userAuth("bob", testPassword)})
+	expectStatus(t, w, http.StatusOK)
+	expectRateLimitHeaders(t, w, "2", "0", "2;w=60")
+
+	w = ta.do(http.MethodPost, "/signin", map[string]interface{}{"user_auth": userAuth("carol", testPassword)})
+	expectRetryAfter(t, w)
+	expectRateLimitHeaders(t, w, "2", "0", "2;w=60")
+}
+
+func TestRateLimitByLoginID(t *testing.T) {
+	ta := newTestApp(t)
+	ta.signin("alice")
+	ta.signin("bob")
+	ta.limitRoutes("login=token_bucket:login_id:2/1m")
+
+	for i := 0; i < 2; i++ {
+		expectStatus(t, ta.login("alice", testPassword), http.StatusOK)
+	}
+
+	expectRetryAfter(t, ta.login("alice", testPassword))
+
+	//the bucket of one login id does not stop another
+	w := ta.login("bob", testPassword)
+	expectStatus(t, w, http.StatusOK)
+	expectRateLimitHeaders(t, w, "2", "1", "2;w=60")
+}
+
+func TestRateLimitOff(t *testing.T) {
+	ta := newTestApp(t)
+	ta.limitRoutes("signin=off")
+
+	w := ta.do(http.MethodPost, "/signin", map[string]interface{}{"user_auth": userAuth("alice", testPassword)})
+	expectStatus(t, w, http.StatusOK)
+
+	if w.Header().Get("RateLimit-Limit") != "" {
+		t.Fatal("RateLimit headers on a route without limits")
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	for _, value := range []string{
+		"signin",
+		"signin=fixed_window:ip:10/1m",
+		"signin=sliding_window:user:10/1m",
+		"signin=sliding_window:ip:0/1m",
+		"signin=sliding_window:ip:10/1ms",
+		"signin=sliding_window:ip:10/1m,token_bucket:ip:5/1s",
+		"signin@tenant=off",
+	} {
+		if _, err := parseRateLimits(value); err == nil {
+			t.Errorf("%q was accepted", value)
+		}
+	}
+
+	limits, err := parseRateLimits("login@d/a=token_bucket:tenant:5/1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantLimits := limits.For("login", &models.UserScope{Domain: "d", AppID: "a"})
+
+	if len(tenantLimits) != 1 || tenantLimits[0].Key != RateLimitByTenant {
+		t.Fatalf("limits of the tenant %v", tenantLimits)
+	}
+
+	if len(limits.For("login", &models.UserScope{Domain: "d", AppID: "b"})) != 2 {
+		t.Fatal("other tenants lost the default login limits")
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IsRevoked checks the denylist for the token jti and its session
+func (j *JwtAuth) IsRevoked(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	for _, claim := range []string{"jti", "sid"} {
+		id, _ := claims[claim].(string)
+		if id == "" {
+			continue
+		}
+
+		revoked, err := j.Revocations.IsTokenRevoked(ctx, id)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	return false, nil
+}
+
+// RevokeFamily ends a login, its refresh tokens stop working and access tokens
+// already handed out are denied through their sid
+func (j *JwtAuth) RevokeFamily(ctx context.Context, familyID string) error {
+	err := j.RefreshStore.RevokeRefreshFamily(ctx, familyID)
+	if err != nil {
+		return err
+	}
+
+	//no access token of the family outlives this
+	return j.Revocations.RevokeToken(ctx, familyID, time.Now().Add(j.TokenExpiry+j.Leeway))
+}
+
+// RevokeUser ends every login of the user
+func (j *JwtAuth) RevokeUser(ctx context.Context, userID string) error {
+	families, err := j.RefreshStore.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, familyID := range families {
+		err := j.Revocations.RevokeToken(ctx, familyID, time.Now().Add(j.TokenExpiry+j.Leeway))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeAccessToken denies the token jti until it expires
+func (j *JwtAuth) RevokeAccessToken(ctx context.Context, claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return ErrTokenClaims
+	}
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return ErrTokenClaims
+	}
+
+	return j.Revocations.RevokeToken(ctx, jti, exp.Add(j.Leeway))
+}
+
+// RevokeClaims revokes a refresh token with its whole family, or a single
+// access token
+func (j *JwtAuth) RevokeClaims(ctx context.Context, claims jwt.MapClaims) error {
+	entry, isRefresh, err := j.refreshEntry(ctx, claims)
+	if err != nil {
+		return err
+	}
+
+	if isRefresh {
+		return j.RevokeFamily(ctx, entry.FamilyID)
+	}
+
+	return j.RevokeAccessToken(ctx, claims)
+}
+
+// tokenClientID returns the client the token of claims was issued to, empty
+// for first party logins
+func (j *JwtAuth) tokenClientID(ctx context.Context, claims jwt.MapClaims) (string, error) {
+	entry, isRefresh, err := j.refreshEntry(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+
+	if isRefresh {
+		return entry.ClientID, nil
+	}
+
+	clientID, _ := claims["client_id"].(string)
+
+	return clientID, nil
+}
+
+// refreshEntry returns the stored refresh token when claims belong to one
+func (j *JwtAuth) refreshEntry(ctx context.Context, claims jwt.MapClaims) (*models.RefreshToken, bool, error) {
+	if tokenUse(claims) != TokenUseRefresh {
+		return nil, false, nil
+	}
+
+	jti, _ := claims["jti"].(string)
+
+	entry, err := j.RefreshStore.GetRefreshToken(ctx, jti)
+	if err != nil {
+		if errors.Is(err, repositores.ErrNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	return entry, true, nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func (ta *testApp) refresh(refreshToken string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+
+	return ta.do(http.MethodGet, "/admin/refreshJwtauth", nil, "Authorization", bearer(refreshToken))
+}
+
+func TestRefreshRotates(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	first := ta.tokens()
+
+	w := ta.refresh(first.RefreshToken.PlainText)
+	expectStatus(t, w, http.StatusOK)
+
+	second := decodeTokens(t, w)
+
+	if second.RefreshToken.PlainText == first.RefreshToken.PlainText {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	w = ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(second.Token.PlainText))
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	first := ta.tokens()
+	other := ta.tokens()
+
+	w := ta.refresh(first.RefreshToken.PlainText)
+	expectStatus(t, w, http.StatusOK)
+
+	second := decodeTokens(t, w)
+
+	//a stolen copy of the spent token
+	w = ta.refresh(first.RefreshToken.PlainText)
+	expectStatus(t, w, http.StatusExpectationFailed)
+
+	//every token of the login stops working
+	w = ta.refresh(second.RefreshToken.PlainText)
+	expectStatus(t, w, http.StatusExpectationFailed)
+
+	for _, token := range []string{first.Token.PlainText, second.Token.PlainText} {
+		w = ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(token))
+		expectStatus(t, w, http.StatusUnauthorized)
+	}
+
+	//other logins of the user are left alone
+	w = ta.do(http.MethodGet, "/me", nil, "Authorization", bearer(other.Token.PlainText))
+	expectStatus(t, w, http.StatusOK)
+
+	w = ta.refresh(other.RefreshToken.PlainText)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestRefreshLimit(t *testing.T) {
+	ta := newTestApp(t)
+	ta.admin()
+
+	refreshToken := ta.tokens().RefreshToken.PlainText
+
+	for i := 0; i < ta.JwtAuth.MaxRefresh; i++ {
+		w := ta.refresh(refreshToken)
+		expectStatus(t, w, http.StatusOK)
+
+		refreshToken = decodeTokens(t, w).RefreshToken.PlainText
+	}
+
+	w := ta.refresh(refreshToken)
+	expectStatus(t, w, http.StatusExpectationFailed)
+}
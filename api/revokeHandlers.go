@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// Revoke implements RFC 7009, unknown or invalid tokens are not an error.
+// Clients authenticate like at the token endpoint and only revoke the tokens
+// issued to them
+func (app *Application) Revoke(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+
+	if err != nil || r.PostForm.Get("token") == "" {
+		app.oauthErrorJSON(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	client, err := app.tokenClient(r)
+
+	if err != nil {
+		var gerr *grantError
+
+		if errors.As(err, &gerr) {
+			app.invalidClientJSON(w)
+			return
+		}
+
+		log.Println(err.Error())
+		app.oauthErrorJSON(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	switch r.PostForm.Get("token_type_hint") {
+	case "", "access_token", "refresh_token":
+	default:
+		app.oauthErrorJSON(w, http.StatusBadRequest, "unsupported_token_type", "unknown token_type_hint")
+		return
+	}
+
+	claims, err := app.JwtAuth.VerifyToken(r.Context(), r.PostForm.Get("token"), "")
+
+	if err != nil {
+		//nothing left to revoke
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	owner, err := app.JwtAuth.tokenClientID(r.Context(), claims)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.oauthErrorJSON(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	if owner != client.ClientID {
+		logSecurityEvent("revoke_refused", "client", client.ClientID)
+		app.oauthErrorJSON(w, http.StatusBadRequest, "unauthorized_client", "token was not issued to the client")
+		return
+	}
+
+	err = app.JwtAuth.RevokeClaims(r.Context(), claims)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.oauthErrorJSON(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Logout ends the login of the bearer token
+func (app *Application) Logout(w http.ResponseWriter, r *http.Request) {
+	_, claims, err := app.JwtAuth.GetTokenFromHeaderAndVerify(w, r)
+
+	if err != nil {
+		app.unauthorizedJSON(w, err)
+		return
+	}
+
+	err = app.JwtAuth.RevokeAccessToken(r.Context(), claims)
+
+	if sid, _ := claims["sid"].(string); err == nil && sid != "" {
+		err = app.JwtAuth.RevokeFamily(r.Context(), sid)
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "logged out",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// LogoutUser ends every login of a user
+func (app *Application) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	err := app.JwtAuth.RevokeUser(r.Context(), userID)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	logSecurityEvent("user_logged_out_everywhere", "user", userID)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user logged out",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -13,18 +13,82 @@ func (app *Application) routes() http.Handler {
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.enableCORS)
 
-	mux.Post("/signin", app.Signin)
-	mux.Post("/login", app.Login)
-	mux.Post("/jwtauth", app.JwtAuthentication)
-	mux.Post("/registerJwt", app.RegisterJwt)
+	mux.With(app.rateLimit("signin")).Post("/signin", app.Signin)
+	mux.With(app.rateLimit("login")).Post("/login", app.Login)
+	mux.With(app.rateLimit("login")).Post("/jwtauth", app.JwtAuthentication)
+	mux.With(app.rateLimit("login")).Post("/registerJwt", app.RegisterJwt)
+	mux.Get("/authorize", app.Authorize)
+	mux.With(app.rateLimit("login")).Post("/authorize", app.AuthorizeLogin)
+	mux.With(app.rateLimit("token")).Post("/token", app.Token)
+	mux.With(app.rateLimit("token")).Post("/device/code", app.DeviceCode)
+	mux.Post("/device/verify", app.VerifyDevice)
+	mux.With(app.rateLimit("token")).Post("/revoke", app.Revoke)
+	mux.Post("/logout", app.Logout)
+	mux.With(app.rateLimit("token")).Post("/introspect", app.Introspect)
 	mux.Get("/health", app.Health)
+	mux.Get("/.well-known/jwks.json", app.Jwks)
+	mux.Get("/.well-known/openid-configuration", app.OpenIDConfiguration)
+	mux.Get("/userinfo", app.UserInfo)
+	mux.Post("/userinfo", app.UserInfo)
+	mux.Get("/me", app.GetMe)
+	mux.Patch("/me", app.UpdateMe)
+	mux.With(app.rateLimit("login")).Post("/me/password", app.ChangePassword)
+	mux.With(app.rateLimit("email")).Post("/me/email/verify", app.RequestEmailVerification)
+	mux.Post("/me/mfa/totp", app.StartTOTPEnrollment)
+	mux.Post("/me/mfa/totp/confirm", app.ConfirmTOTPEnrollment)
+	mux.Delete("/me/mfa", app.DisableMFA)
+	mux.Post("/me/webauthn/register/begin", app.BeginWebAuthnRegistration)
+	mux.Post("/me/webauthn/register/finish", app.FinishWebAuthnRegistration)
+	mux.Get("/me/webauthn/credentials", app.ListWebAuthnCredentials)
+	mux.Delete("/me/webauthn/credentials/{id}", app.DeleteWebAuthnCredential)
+	mux.With(app.rateLimit("mfa")).Post("/mfa/verify", app.VerifyMFA)
+	mux.Post("/mfa/totp/enroll", app.EnrollMFAChallenge)
+	mux.Post("/webauthn/login/begin", app.BeginWebAuthnLogin)
+	mux.With(app.rateLimit("login")).Post("/webauthn/login/finish", app.FinishWebAuthnLogin)
+	mux.With(app.rateLimit("email")).Post("/password/reset", app.RequestPasswordReset)
+	mux.Post("/password/reset/confirm", app.ConfirmPasswordReset)
+	mux.Get("/email/verify", app.ConfirmEmail)
+	mux.Post("/email/verify", app.ConfirmEmail)
+	mux.With(app.rateLimit("email")).Post("/magic-link", app.RequestMagicLink)
+	mux.With(app.rateLimit("login")).Post("/magic-link/verify", app.MagicLinkLogin)
+
+	//takes the refresh token as bearer, it is checked by the handler
+	mux.Get("/admin/refreshJwtauth", app.RefreshJwtauth)
 
 	mux.Route("/admin", func(adminMux chi.Router) {
 		adminMux.Use(app.authRequired)
 		adminMux.Get("/testJwt", app.TestJwt)
-		adminMux.Get("/refreshJwtauth", app.RefreshJwtauth)
 
 		adminMux.Post("/updateJwtRegister", app.UpdateJwtRegister)
+
+		adminMux.Route("/users", func(userMux chi.Router) {
+			userMux.Use(app.adminRequired)
+			userMux.Get("/", app.ListUsers)
+			userMux.Get("/{id}", app.GetUser)
+			userMux.Delete("/{id}", app.DeleteUser)
+			userMux.Post("/{id}/disable", app.DisableUser)
+			userMux.Post("/{id}/enable", app.EnableUser)
+			userMux.Post("/{id}/unlock", app.UnlockUser)
+			userMux.Post("/{id}/role", app.SetUserRole)
+			userMux.Post("/{id}/logout", app.LogoutUser)
+			userMux.Delete("/{id}/mfa", app.ResetUserMFA)
+		})
+
+		adminMux.Route("/clients", func(clientMux chi.Router) {
+			clientMux.Use(app.adminRequired)
+			clientMux.Get("/", app.ListClients)
+			clientMux.Post("/", app.CreateClient)
+			clientMux.Get("/{id}", app.GetClient)
+			clientMux.Delete("/{id}", app.DeleteClient)
+			clientMux.Post("/{id}/secret", app.RotateClientSecret)
+		})
+
+		adminMux.Route("/keys", func(keyMux chi.Router) {
+			keyMux.Use(app.adminRequired)
+			keyMux.Get("/", app.ListSigningKeys)
+			keyMux.Post("/rotate", app.RotateSigningKeys)
+			keyMux.Post("/{kid}/revoke", app.RevokeSigningKeys)
+		})
 	})
 
 	return mux
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"auth/models"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RFC 8693 token types, only our own JWT access tokens are accepted
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+func invalidTarget(description string) error {
+	return &grantError{http.StatusBadRequest, "invalid_target", description}
+}
+
+// exchangeToken verifies a subject or actor token of the given type
+func (app *Application) exchangeToken(ctx context.Context, token string, tokenType string) (jwt.MapClaims, error) {
+	if tokenType != TokenTypeAccessToken && tokenType != TokenTypeJWT {
+		return nil, invalidRequest("unsupported token type " + tokenType)
+	}
+
+	claims, err := app.JwtAuth.VerifyToken(ctx, token, TokenUseAccess)
+
+	if errors.Is(err, ErrTokenUse) {
+		return nil, invalidGrant("only access tokens can be exchanged")
+	}
+
+	if err != nil {
+		return nil, invalidGrant(err.Error())
+	}
+
+	return claims, nil
+}
+
+// tokenExchangeGrant trades a subject token for one with a narrower audience
+// and scope, the actor is recorded in the act claim
+func (app *Application) tokenExchangeGrant(r *http.Request) (*models.TokenResponse, error) {
+	client, err := app.authenticateClient(r)
+
+	if err != nil {
+		if errors.Is(err, ErrInvalidClient) {
+			return nil, &grantError{http.StatusUnauthorized, "invalid_client", "client authentication failed"}
+		}
+		return nil, err
+	}
+
+	if !client.AllowsGrant(models.GrantTokenExchange) || client.Exchange == nil {
+		return nil, unauthorizedClient()
+	}
+
+	form := r.PostForm
+
+	if form.Get("subject_token") == "" || form.Get("subject_token_type") == "" {
+		return nil, invalidRequest("subject_token and subject_token_type are required")
+	}
+
+	requested := form.Get("requested_token_type")
+	if requested != "" && requested != TokenTypeAccessToken {
+		return nil, invalidRequest("only access tokens can be requested")
+	}
+
+	subject, err := app.exchangeToken(r.Context(), form.Get("subject_token"), form.Get("subject_token_type"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	//the actor is the actor token's subject or else the client itself
+	var act map[string]interface{}
+
+	switch {
+	case form.Get("actor_token") != "":
+		actor, err := app.exchangeToken(r.Context(), form.Get("actor_token"), form.Get("actor_token_type"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		actorSub, _ := actor.GetSubject()
+		act = map[string]interface{}{"sub": actorSub}
+	case form.Get("actor_token_type") != "":
+		return nil, invalidRequest("actor_token_type without actor_token")
+	case !client.Exchange.Impersonation:
+		act = map[string]interface{}{"sub": client.ClientID, "client_id": client.ClientID}
+	}
+
+	//earlier delegations stay visible as nested actors
+	if prior, ok := subject["act"]; ok && act != nil {
+		act["act"] = prior
+	}
+
+	audiences := append(append([]string{}, form["audience"]...), form["resource"]...)
+
+	if len(audiences) == 0 {
+		if len(client.Exchange.Audiences) != 1 {
+			return nil, invalidTarget("audience is required")
+		}
+		audiences = client.Exchange.Audiences
+	}
+
+	for _, aud := range audiences {
+		if !client.Exchange.AllowsAudience(aud) {
+			return nil, invalidTarget("audience " + aud + " is not allowed for the client")
+		}
+	}
+
+	scopes, err := exchangeScope(client, scopeClaim(subject), form.Get("scope"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, expiresIn, err := app.JwtAuth.GenerateExchangedToken(r.Context(), subject, act, client, audiences, scopes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logSecurityEvent("token_exchange", "client", client.ClientID, "sub", subject["sub"], "aud", strings.Join(audiences, " "))
+
+	return &models.TokenResponse{
+		AccessToken:     accessToken,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(expiresIn / time.Second),
+		Scope:           strings.Join(scopes, " "),
+	}, nil
+}
+
+// exchangeScope can only narrow the subject's scope, without a scope
+// parameter the subject scopes the client may use are kept
+func exchangeScope(client *models.Client, subjectScopes []string, scope string) ([]string, error) {
+	if scope == "" {
+		scopes := []string{}
+		for _, s := range subjectScopes {
+			if client.AllowsScope(s) {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes, nil
+	}
+
+	scopes, ok := clientScope(client, scope)
+
+	if ok {
+		for _, s := range scopes {
+			if !hasScope(subjectScopes, s) {
+				ok = false
+			}
+		}
+	}
+
+	if !ok {
+		return nil, &grantError{http.StatusBadRequest, "invalid_scope", "scope exceeds the subject token or the client"}
+	}
+
+	return scopes, nil
+}
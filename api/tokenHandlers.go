@@ -0,0 +1,58 @@
+package api
+
+import (
+	"auth/models"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Token is the oauth token endpoint
+func (app *Application) Token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+
+	if err != nil {
+		app.oauthErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	var resp *models.TokenResponse
+
+	switch r.PostForm.Get("grant_type") {
+	case models.GrantAuthorizationCode:
+		resp, err = app.authorizationCodeGrant(r)
+	case models.GrantRefreshToken:
+		resp, err = app.refreshTokenGrant(r)
+	case models.GrantClientCredentials:
+		resp, err = app.clientCredentialsGrant(r)
+	case models.GrantDeviceCode:
+		resp, err = app.deviceCodeGrant(r)
+	case models.GrantTokenExchange:
+		resp, err = app.tokenExchangeGrant(r)
+	case "":
+		err = invalidRequest("grant_type is required")
+	default:
+		err = &grantError{http.StatusBadRequest, "unsupported_grant_type", ""}
+	}
+
+	if err != nil {
+		var gerr *grantError
+
+		if errors.As(err, &gerr) {
+			if gerr.code == "invalid_client" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+app.JwtAuth.Issuer+`"`)
+			}
+			app.oauthErrorJSON(w, gerr.status, gerr.code, gerr.description)
+			return
+		}
+
+		log.Println(err.Error())
+		app.oauthErrorJSON(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	app.writeJSON(w, http.StatusOK, resp, headers)
+}
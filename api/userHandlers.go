@@ -2,10 +2,10 @@ package api
 
 import (
 	"auth/models"
+	"auth/repositores"
+	"errors"
 	"log"
 	"net/http"
-
-	"github.com/pkg/errors"
 )
 
 func (app *Application) Signin(w http.ResponseWriter, r *http.Request) {
@@ -18,6 +18,9 @@ func (app *Application) Signin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//roles are granted by an admin, never chosen at signup
+	user.UserAuth.Scope.Role = models.UserRole{RoleNmae: app.DefaultRole}
+
 	err = app.Validator.Struct(user)
 
 	if err != nil {
@@ -26,33 +29,65 @@ func (app *Application) Signin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ok, err := app.DB.IsUserLoninIdUnique(&user.UserAuth)
+	isAdmin, err := app.bootstrapAdmin(r.Context(), &user.UserAuth)
 
 	if err != nil {
-		log.Println(err.Error())
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	if isAdmin {
+		user.UserAuth.Scope.Role.RoleNmae = AdminRole
+	}
+
+	ok, err := app.DB.IsUserLoninIdUnique(r.Context(), &user.UserAuth)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
 		return
 	}
 
 	if !ok {
 		err := errors.New("user id is not unique.")
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, err, http.StatusConflict)
 		return
 	}
 
 	user.ThirdPartySecrets = []models.ThirdPartySecret{}
+	user.Disabled = false
+	user.MFA = models.MFA{}
+	user.Profile.EmailVerified = false
+	user.Profile.EmailVerifiedAt = 0
 
-	result, err := app.DB.CreateUser(&user)
+	err = app.DB.CreateUser(r.Context(), &user)
+
+	//a concurrent signin can still win the race for the login id
+	if errors.Is(err, repositores.ErrDuplicate) {
+		app.errorJSON(w, errors.New("user id is not unique."), http.StatusConflict)
+		return
+	}
 
 	if err != nil {
-		log.Println(err.Error())
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	if isAdmin {
+		logSecurityEvent("admin_bootstrapped", "user", user.ID.Hex())
+	}
+
+	if user.Profile.Email != "" {
+		err = app.sendEmailVerification(r.Context(), &user, app.VerifyEmailURI)
+
+		if err != nil {
+			log.Println(err.Error())
+		}
 	}
 
 	resp := JSONResponse{
 		Error:   false,
 		Message: "user created",
-		Data:    result,
+		Data:    map[string]interface{}{"InsertedID": user.ID},
 	}
 
 	app.writeJSON(w, http.StatusOK, resp)
@@ -76,11 +111,14 @@ func (app *Application) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbuser, _, err := app.DB.ValidUserByLonginUser(&userAuth)
+	dbuser, err := app.verifyLogin(r, &userAuth)
 
 	if err != nil {
-		log.Println(err.Error())
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.loginErrorJSON(w, err)
+		return
+	}
+
+	if !app.checkMFAJSON(w, r, dbuser, userAuth.MFACode) {
 		return
 	}
 
@@ -93,6 +131,22 @@ func (app *Application) Login(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, resp)
 }
 
+// login failures do not tell unknown users from wrong passwords, throttled
+// logins get a Retry-After, anything else is a server error
+func (app *Application) loginErrorJSON(w http.ResponseWriter, err error) {
+	var throttled *LoginThrottledError
+
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", retryAfter(throttled.RetryAfter))
+		app.errorJSON(w, err, http.StatusTooManyRequests)
+	case errors.Is(err, ErrInvalidLogin):
+		app.errorJSON(w, err, http.StatusUnauthorized)
+	default:
+		app.dbErrorJSON(w, err, "user not found")
+	}
+}
+
 func (app *Application) Health(w http.ResponseWriter, r *http.Request) {
 	resp := JSONResponse{
 		Error:   false,
@@ -1,14 +1,19 @@
 package api
 
 import (
+	"auth/password"
+	"auth/repositores"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 
@@ -76,6 +81,80 @@ func (app *Application) errorJSON(w http.ResponseWriter, err error, status ...in
 	return app.writeJSON(w, statusCode, payload)
 }
 
+// map repository errors to a status, unexpected errors are logged and hidden
+func (app *Application) dbErrorJSON(w http.ResponseWriter, err error, notFound string) error {
+	switch {
+	case errors.Is(err, repositores.ErrNotFound):
+		return app.errorJSON(w, errors.New(notFound), http.StatusNotFound)
+	case errors.Is(err, repositores.ErrDuplicate), errors.Is(err, repositores.ErrConflict):
+		return app.errorJSON(w, err, http.StatusConflict)
+	case errors.Is(err, repositores.ErrInvalidCredentials):
+		return app.errorJSON(w, err, http.StatusUnauthorized)
+	case errors.Is(err, repositores.ErrUserDisabled):
+		return app.errorJSON(w, err, http.StatusForbidden)
+	case errors.Is(err, password.ErrPasswordTooLong):
+		return app.errorJSON(w, err, http.StatusBadRequest)
+	}
+
+	log.Println(err.Error())
+	return app.errorJSON(w, errors.New("internal server error"), http.StatusInternalServerError)
+}
+
+func (app *Application) oauthErrorJSON(w http.ResponseWriter, status int, code string, description string) error {
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	return app.writeJSON(w, status, OAuthError{Error: code, Description: description}, headers)
+}
+
+// reply 401 with a bearer challenge (RFC 6750) for the token errors, any
+// other error is a store failure and answers 503 without details
+func (app *Application) unauthorizedJSON(w http.ResponseWriter, err error) error {
+	challenge := fmt.Sprintf(`Bearer realm="%s"`, app.JwtAuth.Issuer)
+
+	switch {
+	case err == ErrNoAuth:
+	case err == ErrInvalidAuthHeader:
+		challenge += fmt.Sprintf(`, error="invalid_request", error_description="%s"`, err.Error())
+	case tokenErrors[err]:
+		challenge += fmt.Sprintf(`, error="invalid_token", error_description="%s"`, err.Error())
+	default:
+		log.Println(err.Error())
+		return app.errorJSON(w, errors.New("service unavailable"), http.StatusServiceUnavailable)
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	return app.errorJSON(w, err, http.StatusUnauthorized)
+}
+
+// random url safe id for jti and the like
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// security events are logged as key=value pairs
+func logSecurityEvent(event string, kv ...interface{}) {
+	msg := "security_event=" + event
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		msg += fmt.Sprintf(" %v=%v", kv[i], kv[i+1])
+	}
+
+	log.Println(msg)
+}
+
+// reply 403 when the token lacks scope
+func (app *Application) insufficientScopeJSON(w http.ResponseWriter, scope string) error {
+	challenge := fmt.Sprintf(`Bearer realm="%s", error="insufficient_scope", scope="%s"`, app.JwtAuth.Issuer, scope)
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	return app.errorJSON(w, errors.New("insufficient scope"), http.StatusForbidden)
+}
+
 func deriveKey(passphrase string, salt []byte) ([]byte, []byte) {
 	if salt == nil {
 		salt = make([]byte, 8)
@@ -85,6 +164,14 @@ func deriveKey(passphrase string, salt []byte) ([]byte, []byte) {
 }
 
 func (app *Application) Encrypt(passphrase, plaintext string) string {
+	return encrypt(passphrase, plaintext)
+}
+
+func (app *Application) Decrypt(passphrase, ciphertext string) (string, error) {
+	return decrypt(passphrase, ciphertext)
+}
+
+func encrypt(passphrase, plaintext string) string {
 	key, salt := deriveKey(passphrase, nil)
 	iv := make([]byte, 12)
 
@@ -95,14 +182,41 @@ func (app *Application) Encrypt(passphrase, plaintext string) string {
 	return hex.EncodeToString(salt) + "-" + hex.EncodeToString(iv) + "-" + hex.EncodeToString(data)
 }
 
-func (app *Application) Decrypt(passphrase, ciphertext string) string {
+// ErrCiphertext is returned for stored secrets that can not be decrypted
+var ErrCiphertext = errors.New("malformed ciphertext")
+
+func decrypt(passphrase, ciphertext string) (string, error) {
 	arr := strings.Split(ciphertext, "-")
-	salt, _ := hex.DecodeString(arr[0])
-	iv, _ := hex.DecodeString(arr[1])
-	data, _ := hex.DecodeString(arr[2])
+	if len(arr) != 3 {
+		return "", ErrCiphertext
+	}
+
+	salt, err := hex.DecodeString(arr[0])
+	if err != nil {
+		return "", ErrCiphertext
+	}
+	iv, err := hex.DecodeString(arr[1])
+	if err != nil {
+		return "", ErrCiphertext
+	}
+	data, err := hex.DecodeString(arr[2])
+	if err != nil {
+		return "", ErrCiphertext
+	}
+
 	key, _ := deriveKey(passphrase, salt)
 	b, _ := aes.NewCipher(key)
 	aesgcm, _ := cipher.NewGCM(b)
-	data, _ = aesgcm.Open(nil, iv, data, nil)
-	return string(data)
+
+	//Open panics on a nonce of another size
+	if len(iv) != aesgcm.NonceSize() {
+		return "", ErrCiphertext
+	}
+
+	data, err = aesgcm.Open(nil, iv, data, nil)
+	if err != nil {
+		return "", ErrCiphertext
+	}
+
+	return string(data), nil
 }
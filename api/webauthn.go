@@ -0,0 +1,185 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"auth/webauthn"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const webAuthnTimeout = 5 * time.Minute
+
+var (
+	ErrWebAuthnDisabled   = errors.New("webauthn is not configured")
+	ErrInvalidWebAuthn    = errors.New("invalid or expired webauthn challenge")
+	ErrUnknownCredential  = errors.New("unknown credential")
+	ErrSignCountMismatch  = errors.New("credential sign count did not increase")
+	ErrUserHandleMismatch = errors.New("credential belongs to another user")
+)
+
+// webAuthnRelyingParty reads WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and the comma
+// separated WEBAUTHN_ORIGINS, the defaults come from ISSUER_URL. Without an
+// id passkeys are off
+func webAuthnRelyingParty(issuerURL string, name string) (*webauthn.RelyingParty, error) {
+	rp := &webauthn.RelyingParty{
+		ID:                      os.Getenv("WEBAUTHN_RP_ID"),
+		Name:                    os.Getenv("WEBAUTHN_RP_NAME"),
+		RequireUserVerification: true,
+	}
+
+	var issuer *url.URL
+
+	if issuerURL != "" {
+		u, err := url.Parse(issuerURL)
+		if err != nil {
+			return nil, err
+		}
+		issuer = u
+	}
+
+	if rp.ID == "" && issuer != nil {
+		rp.ID = issuer.Hostname()
+	}
+
+	if rp.ID == "" {
+		return nil, nil
+	}
+
+	if rp.Name == "" {
+		rp.Name = name
+	}
+
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			rp.Origins = append(rp.Origins, origin)
+		}
+	}
+
+	if len(rp.Origins) == 0 && issuer != nil {
+		rp.Origins = []string{issuer.Scheme + "://" + issuer.Host}
+	}
+
+	if len(rp.Origins) == 0 {
+		return nil, errors.New("WEBAUTHN_ORIGINS must be set without ISSUER_URL")
+	}
+
+	return rp, nil
+}
+
+// newWebAuthnChallenge keeps a ceremony for webAuthnTimeout, only the hash of
+// the challenge is stored
+func (app *Application) newWebAuthnChallenge(ctx context.Context, userID string, purpose string) (webauthn.Base64URL, error) {
+	challenge := webauthn.NewChallenge()
+
+	entry := models.WebAuthnChallenge{
+		ID:        hashToken(challenge.String()),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(webAuthnTimeout)),
+	}
+
+	err := app.WebAuthnStore.SaveWebAuthnChallenge(ctx, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// useWebAuthnChallenge ends the ceremony clientDataJSON answers, each
+// challenge is accepted once
+func (app *Application) useWebAuthnChallenge(ctx context.Context, clientDataJSON []byte, purpose string) (*models.WebAuthnChallenge, webauthn.Base64URL, error) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, err := app.WebAuthnStore.UseWebAuthnChallenge(ctx, hashToken(challenge.String()))
+
+	if errors.Is(err, repositores.ErrNotFound) || (err == nil && entry.Purpose != purpose) {
+		return nil, nil, ErrInvalidWebAuthn
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entry, challenge, nil
+}
+
+// the user handle is the object id, names and emails can change
+func webAuthnUser(usr *models.User) webauthn.UserEntity {
+	displayName := strings.TrimSpace(usr.Profile.FisrtName + " " + usr.Profile.LastNmae)
+	if displayName == "" {
+		displayName = usr.UserAuth.LoginID
+	}
+
+	return webauthn.UserEntity{
+		ID:          usr.ID[:],
+		Name:        usr.UserAuth.LoginID,
+		DisplayName: displayName,
+	}
+}
+
+// descriptors of the passkeys of userID for the exclude and allow lists
+func (app *Application) webAuthnDescriptors(ctx context.Context, userID string) ([]webauthn.CredentialDescriptor, error) {
+	credentials, err := app.WebAuthnStore.ListWebAuthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors := []webauthn.CredentialDescriptor{}
+
+	for _, credential := range credentials {
+		id, err := decodeCredentialID(credential.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		descriptors = append(descriptors, webauthn.NewDescriptor(id, credential.Transports))
+	}
+
+	return descriptors, nil
+}
+
+// credential ids are stored as unpadded base64url
+func decodeCredentialID(id string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(id)
+}
+
+// webAuthnErrorJSON answers failed ceremonies with 401
+func (app *Application) webAuthnErrorJSON(w http.ResponseWriter, err error) error {
+	switch {
+	case errors.Is(err, ErrWebAuthnDisabled):
+		return app.errorJSON(w, err, http.StatusNotFound)
+	case errors.Is(err, ErrInvalidWebAuthn), errors.Is(err, ErrUnknownCredential),
+		errors.Is(err, ErrSignCountMismatch), errors.Is(err, ErrUserHandleMismatch), isCeremonyError(err):
+		return app.errorJSON(w, err, http.StatusUnauthorized)
+	}
+
+	return app.dbErrorJSON(w, err, "user not found")
+}
+
+func isCeremonyError(err error) bool {
+	for _, target := range []error{
+		webauthn.ErrInvalidCredential, webauthn.ErrInvalidClientData, webauthn.ErrChallengeMismatch,
+		webauthn.ErrOriginMismatch, webauthn.ErrRPIDMismatch, webauthn.ErrUserNotPresent,
+		webauthn.ErrUserNotVerified, webauthn.ErrInvalidAuthData, webauthn.ErrInvalidAttestation,
+		webauthn.ErrUnsupportedFormat, webauthn.ErrUnsupportedKey, webauthn.ErrInvalidSignature,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,388 @@
+package api
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"auth/webauthn"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BeginWebAuthnRegistration returns the options of navigator.credentials.create
+// for a new passkey of the user of the bearer access token
+func (app *Application) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if app.WebAuthn == nil {
+		app.webAuthnErrorJSON(w, ErrWebAuthnDisabled)
+		return
+	}
+
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	exclude, err := app.webAuthnDescriptors(r.Context(), usr.ID.Hex())
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	challenge, err := app.newWebAuthnChallenge(r.Context(), usr.ID.Hex(), models.WebAuthnRegistration)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "webauthn registration",
+		Data:    app.WebAuthn.CreationOptions(webAuthnUser(usr), challenge, exclude, webAuthnTimeout),
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// FinishWebAuthnRegistration verifies the attestation and stores the passkey
+func (app *Application) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if app.WebAuthn == nil {
+		app.webAuthnErrorJSON(w, ErrWebAuthnDisabled)
+		return
+	}
+
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	var req models.WebAuthnRegistrationRequest
+	err := app.readJSON(w, r, &req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Validator.Struct(req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	entry, challenge, err := app.useWebAuthnChallenge(r.Context(), req.Credential.Response.ClientDataJSON, models.WebAuthnRegistration)
+
+	if err != nil {
+		app.webAuthnErrorJSON(w, err)
+		return
+	}
+
+	//the challenge was issued to this user
+	if entry.UserID != usr.ID.Hex() {
+		app.webAuthnErrorJSON(w, ErrInvalidWebAuthn)
+		return
+	}
+
+	verified, err := app.WebAuthn.VerifyRegistration(challenge, &req.Credential)
+
+	if err != nil {
+		logSecurityEvent("webauthn_registration_failed", "user", usr.ID.Hex(), "reason", err.Error())
+		app.webAuthnErrorJSON(w, err)
+		return
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+
+	credential := models.WebAuthnCredential{
+		ID:                webauthn.Base64URL(verified.ID).String(),
+		UserID:            usr.ID.Hex(),
+		Name:              req.Name,
+		PublicKey:         verified.PublicKey,
+		SignCount:         int64(verified.SignCount),
+		AAGUID:            hex.EncodeToString(verified.AAGUID),
+		AttestationFormat: verified.AttestationFormat,
+		Transports:        verified.Transports,
+		BackupEligible:    verified.BackupEligible,
+		CreatedAt:         now,
+		LastUsedAt:        now,
+	}
+
+	if credential.Name == "" {
+		credential.Name = "passkey"
+	}
+
+	if credential.Transports == nil {
+		credential.Transports = []string{}
+	}
+
+	err = app.WebAuthnStore.AddWebAuthnCredential(r.Context(), &credential)
+
+	if errors.Is(err, repositores.ErrDuplicate) {
+		app.errorJSON(w, errors.New("credential is already registered"), http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	logSecurityEvent("webauthn_registered", "user", usr.ID.Hex(), "credential", credential.ID,
+		"attestation", verified.AttestationType)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "passkey registered",
+		Data:    credential,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// ListWebAuthnCredentials lists the passkeys of the user of the bearer
+// access token
+func (app *Application) ListWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	credentials, err := app.WebAuthnStore.ListWebAuthnCredentials(r.Context(), usr.ID.Hex())
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "passkeys",
+		Data:    credentials,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// DeleteWebAuthnCredential removes a passkey of the user of the bearer
+// access token
+func (app *Application) DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	usr, ok := app.currentUser(w, r)
+
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	err := app.WebAuthnStore.DeleteWebAuthnCredential(r.Context(), usr.ID.Hex(), id)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "passkey not found")
+		return
+	}
+
+	logSecurityEvent("webauthn_removed", "user", usr.ID.Hex(), "credential", id)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "passkey removed",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// BeginWebAuthnLogin returns the options of navigator.credentials.get. The
+// body is optional, without a login_id the authenticator picks one of its
+// discoverable credentials
+func (app *Application) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if app.WebAuthn == nil {
+		app.webAuthnErrorJSON(w, ErrWebAuthnDisabled)
+		return
+	}
+
+	var req models.WebAuthnLoginRequest
+	err := app.readJSON(w, r, &req)
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var userID string
+	allow := []webauthn.CredentialDescriptor{}
+
+	if req.LoginID != "" {
+		userAuth := models.UserAuth{
+			LoginID: req.LoginID,
+			Scope:   models.UserScope{Domain: req.Domain, AppID: req.AppID},
+		}
+
+		if userAuth.Scope.Domain == "" && userAuth.Scope.AppID == "" {
+			userAuth.Scope.Domain = app.Domain
+			userAuth.Scope.AppID = app.AppID
+		}
+
+		usr, err := app.DB.GetUserByLoginID(r.Context(), &userAuth)
+
+		//unknown users get an empty allow list instead of an error
+		switch {
+		case errors.Is(err, repositores.ErrNotFound):
+		case err != nil:
+			app.dbErrorJSON(w, err, "user not found")
+			return
+		default:
+			userID = usr.ID.Hex()
+
+			allow, err = app.webAuthnDescriptors(r.Context(), userID)
+
+			if err != nil {
+				app.dbErrorJSON(w, err, "user not found")
+				return
+			}
+		}
+	}
+
+	challenge, err := app.newWebAuthnChallenge(r.Context(), userID, models.WebAuthnLogin)
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "webauthn login",
+		Data:    app.WebAuthn.RequestOptions(challenge, allow, webAuthnTimeout),
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// FinishWebAuthnLogin verifies the assertion and issues tokens like /jwtauth,
+// scope and nonce are optional query parameters. A user verified passkey
+// counts as both factors, so the MFA policy asks for no code
+func (app *Application) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if app.WebAuthn == nil {
+		app.webAuthnErrorJSON(w, ErrWebAuthnDisabled)
+		return
+	}
+
+	var req webauthn.RequestResponse
+	err := app.readJSON(w, r, &req)
+
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	entry, challenge, err := app.useWebAuthnChallenge(r.Context(), req.Response.ClientDataJSON, models.WebAuthnLogin)
+
+	if err != nil {
+		app.webAuthnErrorJSON(w, err)
+		return
+	}
+
+	credential, err := app.WebAuthnStore.GetWebAuthnCredential(r.Context(), webauthn.Base64URL(req.RawID).String())
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		logSecurityEvent("webauthn_login_failed", "credential", webauthn.Base64URL(req.RawID).String(), "reason", ErrUnknownCredential.Error())
+		app.webAuthnErrorJSON(w, ErrUnknownCredential)
+		return
+	}
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "user not found")
+		return
+	}
+
+	usr, err := app.userByID(r.Context(), credential.UserID)
+
+	if err != nil {
+		app.webAuthnErrorJSON(w, err)
+		return
+	}
+
+	//a challenge for a named user only accepts its passkeys, a usernameless
+	//login relies on the user handle the authenticator stored
+	userHandle := req.Response.UserHandle
+
+	if (entry.UserID != "" && entry.UserID != credential.UserID) ||
+		(entry.UserID == "" && len(userHandle) == 0) ||
+		(len(userHandle) != 0 && !bytes.Equal(userHandle, usr.ID[:])) {
+		logSecurityEvent("webauthn_login_failed", "user", credential.UserID, "reason", ErrUserHandleMismatch.Error())
+		app.webAuthnErrorJSON(w, ErrUserHandleMismatch)
+		return
+	}
+
+	assertion, err := app.WebAuthn.VerifyAssertion(challenge, credential.PublicKey, &req)
+
+	if err != nil {
+		logSecurityEvent("webauthn_login_failed", "user", credential.UserID, "reason", err.Error())
+		app.webAuthnErrorJSON(w, err)
+		return
+	}
+
+	//a counter that does not grow hints at a cloned authenticator
+	err = ErrSignCountMismatch
+
+	if webauthn.SignCountValid(uint32(credential.SignCount), assertion.SignCount) {
+		err = app.WebAuthnStore.UseWebAuthnCredential(r.Context(), credential.ID, int64(assertion.SignCount),
+			primitive.NewDateTimeFromTime(time.Now()))
+	}
+
+	if errors.Is(err, ErrSignCountMismatch) || errors.Is(err, repositores.ErrConflict) {
+		logSecurityEvent("webauthn_sign_count_mismatch", "user", credential.UserID, "credential", credential.ID,
+			"stored", credential.SignCount, "received", assertion.SignCount)
+		app.webAuthnErrorJSON(w, ErrSignCountMismatch)
+		return
+	}
+
+	if err != nil {
+		app.dbErrorJSON(w, err, "passkey not found")
+		return
+	}
+
+	if usr.Disabled {
+		app.dbErrorJSON(w, repositores.ErrUserDisabled, "user not found")
+		return
+	}
+
+	opts := &TokenOptions{
+		Scope:    parseScope(r.URL.Query().Get("scope")),
+		Nonce:    r.URL.Query().Get("nonce"),
+		AuthTime: time.Now(),
+	}
+
+	tokens, err := app.JwtAuth.GenerateTopenPair(r.Context(), usr, "", opts)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	logSecurityEvent("webauthn_login", "user", usr.ID.Hex(), "credential", credential.ID)
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "jwt token",
+		Data:    tokens,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
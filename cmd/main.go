@@ -3,6 +3,7 @@ package main
 import (
 	"auth/api"
 	"log"
+	"os"
 
 	"github.com/joho/godotenv"
 )
@@ -18,5 +19,14 @@ func main() {
 
 	app = api.Application{}
 
+	//"migrate" applies the database migrations and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = app.MigrateDB()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app.StartApp()
 }
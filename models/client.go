@@ -0,0 +1,63 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// grant types a client may be allowed
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// ExchangePolicy limits the tokens a client may get through token exchange.
+// Without Impersonation an actor is always recorded in the act claim
+type ExchangePolicy struct {
+	Audiences     []string `json:"audiences" validate:"required,min=1" bson:"audiences"`
+	Impersonation bool     `json:"impersonation" bson:"impersonation"`
+}
+
+func (p *ExchangePolicy) AllowsAudience(aud string) bool {
+	return contains(p.Audiences, aud)
+}
+
+// Client is a registered oauth client owned by a Domain/AppID. Secret is only
+// set when the secret is handed out, the store keeps SecretHash
+type Client struct {
+	ClientID     string             `json:"client_id" bson:"_id"`
+	Name         string             `json:"name" validate:"required,max=100" bson:"name"`
+	Secret       string             `json:"client_secret,omitempty" bson:"-"`
+	SecretHash   string             `json:"-" bson:"secret_hash"`
+	Public       bool               `json:"public" bson:"public"`
+	GrantTypes   []string           `json:"grant_types" bson:"grant_types"`
+	Scopes       []string           `json:"scopes" bson:"scopes"`
+	RedirectURIs []string           `json:"redirect_uris" validate:"dive,url" bson:"redirect_uris"`
+	Exchange     *ExchangePolicy    `json:"token_exchange,omitempty" bson:"token_exchange,omitempty"`
+	Domain       string             `json:"domain" bson:"domain"`
+	AppID        string             `json:"app_id" bson:"app_id"`
+	CreatedAt    primitive.DateTime `json:"created_at" bson:"created_at"`
+	UpdatedAt    primitive.DateTime `json:"updated_at" bson:"updated_at"`
+}
+
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+func (c *Client) AllowsGrant(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+func (c *Client) AllowsScope(scope string) bool {
+	return contains(c.Scopes, scope)
+}
+
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
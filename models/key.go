@@ -0,0 +1,49 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// JWK is a public json web key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// signing key states
+const (
+	KeyPending  = "pending"
+	KeyActive   = "active"
+	KeyRetiring = "retiring"
+	KeyRevoked  = "revoked"
+)
+
+// SigningKey is the stored form of a service signing key, the private key is
+// an encrypted PKCS8 PEM
+type SigningKey struct {
+	Kid        string             `json:"kid" bson:"_id"`
+	Alg        string             `json:"alg" bson:"alg"`
+	State      string             `json:"state" bson:"state"`
+	PrivateKey string             `json:"-" bson:"private_key"`
+	CreatedAt  primitive.DateTime `json:"created_at" bson:"created_at"`
+	ActivateAt primitive.DateTime `json:"activate_at" bson:"activate_at"`
+	RetireAt   primitive.DateTime `json:"retire_at,omitempty" bson:"retire_at,omitempty"`
+	ExpireAt   primitive.DateTime `json:"expire_at,omitempty" bson:"expire_at,omitempty"`
+}
+
+// Lease lets one replica at a time do a job like key rotation, Holder keeps
+// it until ExpiresAt unless it renews it
+type Lease struct {
+	Name      string             `json:"name" bson:"_id"`
+	Holder    string             `json:"holder" bson:"holder"`
+	ExpiresAt primitive.DateTime `json:"expires_at" bson:"expires_at"`
+}
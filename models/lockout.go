@@ -0,0 +1,15 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// LoginAttempts counts the failed logins of an account or a client address
+// since FirstFailure, ID is the key. PreviousFailure is the last failure
+// before LastFailure in the same count. The count is forgotten at ExpiresAt
+type LoginAttempts struct {
+	ID              string             `json:"-" bson:"_id"`
+	Failures        int                `json:"failures" bson:"failures"`
+	FirstFailure    primitive.DateTime `json:"first_failure" bson:"first_failure"`
+	LastFailure     primitive.DateTime `json:"last_failure" bson:"last_failure"`
+	PreviousFailure primitive.DateTime `json:"-" bson:"previous_failure"`
+	ExpiresAt       primitive.DateTime `json:"-" bson:"expires_at"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+)
+
+// MFA is the second factor of a user. The TOTP secrets are encrypted,
+// PendingSecret waits for the confirmation of an enrollment. LastStep is the
+// last accepted time step so a code works once, recovery codes are hashed
+type MFA struct {
+	Enabled       bool     `json:"enabled" bson:"enabled"`
+	Secret        string   `json:"-" bson:"secret"`
+	PendingSecret string   `json:"-" bson:"pending_secret"`
+	LastStep      int64    `json:"-" bson:"last_step"`
+	RecoveryCodes []string `json:"-" bson:"recovery_codes"`
+}
+
+// MFAChallenge is the state between the password and the second factor,
+// ID is the hash of the mfa_token. KeyName, Scope and Nonce finish a jwtauth
+// login, ClientID binds the challenge to an authorization request
+type MFAChallenge struct {
+	ID        string             `bson:"_id"`
+	UserID    string             `bson:"user_id"`
+	ClientID  string             `bson:"client_id"`
+	KeyName   string             `bson:"key_name"`
+	Scope     []string           `bson:"scope"`
+	Nonce     string             `bson:"nonce"`
+	Enroll    bool               `bson:"enroll"`
+	Attempts  int                `bson:"attempts"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
+
+// MFARequired answers a login that needs a second factor. With
+// EnrollmentRequired the user has to set up TOTP with the token first
+type MFARequired struct {
+	MFAToken           string   `json:"mfa_token"`
+	Methods            []string `json:"methods"`
+	EnrollmentRequired bool     `json:"enrollment_required"`
+	ExpiresIn          int64    `json:"expires_in"`
+}
+
+// MFAVerification finishes a login with a TOTP code or a recovery code
+type MFAVerification struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFACode confirms or removes the TOTP of a logged in user
+type MFACode struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAEnrollmentRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// TOTPEnrollment is shown once, OtpauthURI is the QR code payload
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type MFAEnabled struct {
+	RecoveryCodes []string    `json:"recovery_codes"`
+	Tokens        *TokenPairs `json:"tokens,omitempty"`
+}
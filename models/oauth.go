@@ -0,0 +1,69 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// AuthCode is a pending authorization code, ID is the sha256 of the code
+type AuthCode struct {
+	ID                  string             `bson:"_id"`
+	ClientID            string             `bson:"client_id"`
+	UserID              string             `bson:"user_id"`
+	RedirectURI         string             `bson:"redirect_uri"`
+	Scope               []string           `bson:"scope"`
+	Nonce               string             `bson:"nonce"`
+	CodeChallenge       string             `bson:"code_challenge"`
+	CodeChallengeMethod string             `bson:"code_challenge_method"`
+	AuthTime            primitive.DateTime `bson:"auth_time"`
+	Used                bool               `bson:"used"`
+	FamilyID            string             `bson:"family_id"`
+	ExpiresAt           primitive.DateTime `bson:"expires_at"`
+}
+
+// device code states
+const (
+	DevicePending  = "pending"
+	DeviceApproved = "approved"
+	DeviceDenied   = "denied"
+	DeviceUsed     = "used"
+)
+
+// DeviceCode is a pending device authorization, ID is the sha256 of the
+// device code and UserCode is kept without separators
+type DeviceCode struct {
+	ID           string             `bson:"_id"`
+	UserCode     string             `bson:"user_code"`
+	ClientID     string             `bson:"client_id"`
+	Scope        []string           `bson:"scope"`
+	Status       string             `bson:"status"`
+	UserID       string             `bson:"user_id,omitempty"`
+	Interval     int                `bson:"interval"`
+	LastPolledAt primitive.DateTime `bson:"last_polled_at,omitempty"`
+	AuthTime     primitive.DateTime `bson:"auth_time,omitempty"`
+	ExpiresAt    primitive.DateTime `bson:"expires_at"`
+}
+
+// DeviceAuthorization is the RFC 8628 device authorization response
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceVerification is the body a logged in user sends to approve a device
+type DeviceVerification struct {
+	UserCode string `json:"user_code" validate:"required"`
+	Approve  bool   `json:"approve"`
+}
+
+// TokenResponse is the RFC 6749 token endpoint response
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// RateLimitBucket is a token bucket, Tokens is the level after the last
+// request at UpdatedAt and Allowed tells whether that request got a token.
+// A bucket that has refilled is forgotten at ExpiresAt
+type RateLimitBucket struct {
+	ID        string             `json:"-" bson:"_id"`
+	Tokens    float64            `json:"tokens" bson:"tokens"`
+	Allowed   bool               `json:"allowed" bson:"allowed"`
+	UpdatedAt primitive.DateTime `json:"updated_at" bson:"updated_at"`
+	ExpiresAt primitive.DateTime `json:"-" bson:"expires_at"`
+}
+
+// RateLimitWindow counts the requests of the fixed window that starts at
+// WindowStart and keeps the count of the window before it for a sliding
+// estimate
+type RateLimitWindow struct {
+	ID           string             `json:"-" bson:"_id"`
+	WindowStart  primitive.DateTime `json:"window_start" bson:"window_start"`
+	Hits         int64              `json:"hits" bson:"hits"`
+	PreviousHits int64              `json:"previous_hits" bson:"previous_hits"`
+	ExpiresAt    primitive.DateTime `json:"-" bson:"expires_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// RefreshToken is the server side state of one refresh token, every refresh
+// of a login creates a new one in the same family. Secret is the encrypted
+// third party secret used by legacy HS256 signing
+type RefreshToken struct {
+	ID          string             `json:"jti" bson:"_id"`
+	FamilyID    string             `json:"family_id" bson:"family_id"`
+	UserID      string             `json:"user_id" bson:"user_id"`
+	ClientID    string             `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	Secret      string             `json:"-" bson:"secret"`
+	Count       int                `json:"count" bson:"count"`
+	AccessToken string             `json:"-" bson:"access_token"`
+	Used        bool               `json:"used" bson:"used"`
+	Revoked     bool               `json:"revoked" bson:"revoked"`
+	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
+	ExpiresAt   primitive.DateTime `json:"expires_at" bson:"expires_at"`
+}
+
+// Introspection is the RFC 7662 response, only Active is set for inactive tokens
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+}
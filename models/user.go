@@ -11,6 +11,8 @@ type User struct {
 	UserAuth          UserAuth           `json:"user_auth" validate:"required" bson:"user_auth"`
 	Profile           UserPorfile        `json:"profile" bson:"profile"`
 	ThirdPartySecrets []ThirdPartySecret `json:"third_party_secrets" bson:"third_party_secrets"`
+	Disabled          bool               `json:"disabled" bson:"disabled"`
+	MFA               MFA                `json:"mfa" bson:"mfa"`
 	CreatedAt         primitive.DateTime `bson:"created_at"`
 	UpdatedAt         primitive.DateTime `bson:"updated_at"`
 }
@@ -19,6 +21,7 @@ type UserAuth struct {
 	LoginID    string     `json:"login_id" validate:"required,min=2,max=100" bson:"login_id"`
 	Password   string     `json:"password" validate:"required,min=4" bson:"password"`
 	Scope      UserScope  `json:"scope" validate:"required" bson:"scope"`
+	MFACode    string     `json:"mfa_code,omitempty" bson:"-"`
 	TokenPairs TokenPairs `json:"tokenPairs" bson:"-"`
 }
 
@@ -28,6 +31,9 @@ type UserPorfile struct {
 	Email     string  `json:"email" bson:"email"`
 	Phone     string  `json:"phone" bson:"phone"`
 	Address   Address `json:"address" bson:"address"`
+	//set by a verification link only, a new email is unverified again
+	EmailVerified   bool               `json:"email_verified" bson:"email_verified"`
+	EmailVerifiedAt primitive.DateTime `json:"email_verified_at,omitempty" bson:"email_verified_at,omitempty"`
 }
 
 type Address struct {
@@ -37,6 +43,120 @@ type Address struct {
 	Zipcode string `json:"zip_code" bson:"zip_code"`
 }
 
+// UserFilter selects users for the admin listing, zero fields match all.
+// Query is a case insensitive search in login id, email and names, After
+// the id the previous page ended with
+type UserFilter struct {
+	Domain        string
+	AppID         string
+	Role          string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Query         string
+	After         string
+	Limit         int
+}
+
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ProfilePatch is a partial profile update, nil fields are left alone and
+// empty strings clear them
+type ProfilePatch struct {
+	FisrtName *string       `json:"first_name" validate:"omitempty,max=100"`
+	LastNmae  *string       `json:"last_name" validate:"omitempty,max=100"`
+	Email     *string       `json:"email" validate:"omitempty,max=254,len=0|email"`
+	Phone     *string       `json:"phone" validate:"omitempty,len=0|e164"`
+	Address   *AddressPatch `json:"address"`
+}
+
+type AddressPatch struct {
+	Street  *string `json:"street" validate:"omitempty,max=200"`
+	City    *string `json:"city" validate:"omitempty,max=100"`
+	State   *string `json:"state" validate:"omitempty,max=100"`
+	Zipcode *string `json:"zip_code" validate:"omitempty,max=20,printascii"`
+}
+
+// Apply returns profile with the patched fields replaced
+func (p *ProfilePatch) Apply(profile UserPorfile) UserPorfile {
+	setString(&profile.FisrtName, p.FisrtName)
+	setString(&profile.LastNmae, p.LastNmae)
+
+	if p.Email != nil && *p.Email != profile.Email {
+		profile.EmailVerified = false
+		profile.EmailVerifiedAt = 0
+	}
+
+	setString(&profile.Email, p.Email)
+	setString(&profile.Phone, p.Phone)
+
+	if p.Address != nil {
+		setString(&profile.Address.Street, p.Address.Street)
+		setString(&profile.Address.City, p.Address.City)
+		setString(&profile.Address.State, p.Address.State)
+		setString(&profile.Address.Zipcode, p.Address.Zipcode)
+	}
+
+	return profile
+}
+
+func setString(field *string, value *string) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+	TokenMagicLink         = "magic_link"
+)
+
+// UserToken is a single use token sent to a user, ID is the hash of the token
+type UserToken struct {
+	ID        string             `bson:"_id"`
+	Purpose   string             `bson:"purpose"`
+	UserID    string             `bson:"user_id"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=4,max=128"`
+}
+
+// PasswordResetRequest names the user by login id, the scope defaults to the
+// service Domain/AppID
+type PasswordResetRequest struct {
+	LoginID string `json:"login_id" validate:"required"`
+	Domain  string `json:"user_domain"`
+	AppID   string `json:"user_app_id"`
+}
+
+type PasswordReset struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=4,max=128"`
+}
+
+type EmailVerification struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type MagicLinkRequest struct {
+	LoginID string `json:"login_id" validate:"required"`
+	Domain  string `json:"user_domain"`
+	AppID   string `json:"user_app_id"`
+}
+
+// MagicLinkLogin redeems a magic link, MFACode is the second factor of
+// users who need one
+type MagicLinkLogin struct {
+	Token   string `json:"token" validate:"required"`
+	MFACode string `json:"mfa_code"`
+}
+
 type Token struct {
 	PlainText string        `json:"access_token" bson:"-"`
 	Hash      []byte        `json:"-" bson:"-"`
@@ -44,8 +164,10 @@ type Token struct {
 }
 
 type TokenPairs struct {
-	Token        Token `json:"token" bson:"-"`
-	RefreshToken Token `json:"refresh_token" bson:"-"`
+	Token        Token  `json:"token" bson:"-"`
+	RefreshToken Token  `json:"refresh_token" bson:"-"`
+	IDToken      string `json:"id_token,omitempty" bson:"-"`
+	SessionID    string `json:"-" bson:"-"`
 }
 
 type UserRole struct {
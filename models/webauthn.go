@@ -0,0 +1,50 @@
+package models
+
+import (
+	"auth/webauthn"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WebAuthnRegistration = "webauthn_registration"
+	WebAuthnLogin        = "webauthn_login"
+)
+
+// WebAuthnCredential is a passkey of a user, ID is the base64url credential
+// id and PublicKey the COSE key
+type WebAuthnCredential struct {
+	ID                string             `json:"id" bson:"_id"`
+	UserID            string             `json:"-" bson:"user_id"`
+	Name              string             `json:"name" bson:"name"`
+	PublicKey         []byte             `json:"-" bson:"public_key"`
+	SignCount         int64              `json:"-" bson:"sign_count"`
+	AAGUID            string             `json:"aaguid" bson:"aaguid"`
+	AttestationFormat string             `json:"attestation_format" bson:"attestation_format"`
+	Transports        []string           `json:"transports" bson:"transports"`
+	BackupEligible    bool               `json:"backup_eligible" bson:"backup_eligible"`
+	CreatedAt         primitive.DateTime `json:"created_at" bson:"created_at"`
+	LastUsedAt        primitive.DateTime `json:"last_used_at" bson:"last_used_at"`
+}
+
+// WebAuthnChallenge is a running ceremony by the hash of its challenge,
+// UserID is empty for a login without user name
+type WebAuthnChallenge struct {
+	ID        string             `bson:"_id"`
+	UserID    string             `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
+
+type WebAuthnRegistrationRequest struct {
+	Name       string                    `json:"name" validate:"max=64"`
+	Credential webauthn.CreationResponse `json:"credential"`
+}
+
+// WebAuthnLoginRequest names the user for a login with a non discoverable
+// credential, without LoginID any passkey of the relying party is accepted
+type WebAuthnLoginRequest struct {
+	LoginID string `json:"login_id"`
+	Domain  string `json:"user_domain"`
+	AppID   string `json:"user_app_id"`
+}
@@ -0,0 +1,144 @@
+// Package password hashes user passwords. Hashes carry their algorithm and
+// parameters, argon2id in the PHC string format and bcrypt in its own, so
+// old hashes keep working after the configuration changes
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+var (
+	ErrPasswordTooLong = errors.New("password is longer than 72 bytes")
+	ErrUnknownHash     = errors.New("unknown password hash")
+)
+
+// Hasher creates hashes with Algorithm, Memory is in KiB
+type Hasher struct {
+	Algorithm   string
+	Memory      uint32
+	Time        uint32
+	Parallelism uint8
+	SaltLen     uint32
+	KeyLen      uint32
+	BcryptCost  int
+}
+
+// NewHasher uses argon2id with the second recommended option of RFC 9106
+func NewHasher() *Hasher {
+	return &Hasher{
+		Algorithm:   Argon2id,
+		Memory:      64 * 1024,
+		Time:        3,
+		Parallelism: 4,
+		SaltLen:     16,
+		KeyLen:      32,
+		BcryptCost:  12,
+	}
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case Argon2id:
+		salt := make([]byte, h.SaltLen)
+
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+
+		key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Parallelism, h.KeyLen)
+
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case Bcrypt:
+		//bcrypt ignores everything after 72 bytes
+		if len(password) > 72 {
+			return "", ErrPasswordTooLong
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+
+		return string(hash), err
+	}
+
+	return "", errors.New("unsupported password hash " + h.Algorithm)
+}
+
+// Verify reports whether password matches hash and, for a match, whether the
+// hash should be replaced because it was made with other settings
+func (h *Hasher) Verify(hash string, password string) (bool, bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return h.verifyArgon2id(hash, password)
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+
+		if err != nil {
+			return false, false, err
+		}
+
+		cost, _ := bcrypt.Cost([]byte(hash))
+
+		return true, h.Algorithm != Bcrypt || cost != h.BcryptCost, nil
+	}
+
+	return false, false, ErrUnknownHash
+}
+
+func (h *Hasher) verifyArgon2id(hash string, password string) (bool, bool, error) {
+	//$argon2id$v=19$m=65536,t=3,p=4$salt$key
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 6 {
+		return false, false, ErrUnknownHash
+	}
+
+	var version int
+	var memory, time uint32
+	var parallelism uint8
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrUnknownHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &parallelism); err != nil {
+		return false, false, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrUnknownHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, time, memory, parallelism, uint32(len(key)))
+
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	rehash := h.Algorithm != Argon2id || memory != h.Memory || time != h.Time ||
+		parallelism != h.Parallelism || uint32(len(key)) != h.KeyLen
+
+	return true, rehash, nil
+}
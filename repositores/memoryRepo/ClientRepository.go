@@ -0,0 +1,125 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (m *MemoryDB) CreateClient(ctx context.Context, cl *models.Client) error {
+	if cl.Secret != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(cl.Secret), bcrypt.DefaultCost)
+
+		if err != nil {
+			return err
+		}
+
+		cl.SecretHash = string(hash)
+	}
+
+	cl.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	cl.UpdatedAt = cl.CreatedAt
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.clients[cl.ClientID]; ok {
+		return repositores.ErrDuplicate
+	}
+
+	stored := *cl
+	stored.Secret = ""
+	m.clients[cl.ClientID] = stored
+
+	return nil
+}
+
+func (m *MemoryDB) GetClientByID(ctx context.Context, clientID string) (*models.Client, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	client, ok := m.clients[clientID]
+
+	if !ok {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &client, nil
+}
+
+func (m *MemoryDB) ValidClientSecret(ctx context.Context, clientID string, secret string) (*models.Client, error) {
+	result, err := m.GetClientByID(ctx, clientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result.SecretHash == "" {
+		return nil, repositores.ErrInvalidCredentials
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(result.SecretHash), []byte(secret))
+
+	if err != nil {
+		return nil, repositores.ErrInvalidCredentials
+	}
+
+	return result, nil
+}
+
+func (m *MemoryDB) ListClients(ctx context.Context) ([]models.Client, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	clients := []models.Client{}
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].CreatedAt < clients[j].CreatedAt
+	})
+
+	return clients, nil
+}
+
+func (m *MemoryDB) UpdateClientSecret(ctx context.Context, clientID string, secret string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client, ok := m.clients[clientID]
+
+	if !ok {
+		return repositores.ErrNotFound
+	}
+
+	client.SecretHash = string(hash)
+	client.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+	m.clients[clientID] = client
+
+	return nil
+}
+
+func (m *MemoryDB) DeleteClient(ctx context.Context, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.clients[clientID]; !ok {
+		return repositores.ErrNotFound
+	}
+
+	delete(m.clients, clientID)
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MemoryDB) SaveSigningKey(ctx context.Context, key *models.SigningKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.signingKeys[key.Kid] = *key
+
+	return nil
+}
+
+func (m *MemoryDB) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []models.SigningKey{}
+	for _, key := range m.signingKeys {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (m *MemoryDB) AcquireLease(ctx context.Context, name string, holder string, now primitive.DateTime, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lease, ok := m.leases[name]
+
+	if ok && lease.Holder != holder && lease.ExpiresAt > now {
+		return false, nil
+	}
+
+	m.leases[name] = models.Lease{Name: name, Holder: holder, ExpiresAt: primitive.NewDateTimeFromTime(now.Time().Add(ttl))}
+
+	return true, nil
+}
@@ -0,0 +1,66 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MemoryDB) AddLoginFailure(ctx context.Context, key string, now primitive.DateTime, window time.Duration) (*models.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.loginAttempts[key]
+
+	if !ok || attempts.LastFailure.Time().Add(window).Before(now.Time()) {
+		attempts = models.LoginAttempts{ID: key, FirstFailure: now}
+	}
+
+	attempts.PreviousFailure = attempts.LastFailure
+	attempts.Failures++
+	attempts.LastFailure = now
+	attempts.ExpiresAt = primitive.NewDateTimeFromTime(now.Time().Add(window))
+	m.loginAttempts[key] = attempts
+
+	return &attempts, nil
+}
+
+func (m *MemoryDB) GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	attempts, ok := m.loginAttempts[key]
+
+	if !ok || attempts.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &attempts, nil
+}
+
+func (m *MemoryDB) RemoveLoginFailure(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.loginAttempts[key]
+
+	if ok && attempts.Failures > 0 {
+		attempts.Failures--
+		attempts.LastFailure = attempts.PreviousFailure
+		m.loginAttempts[key] = attempts
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) ResetLoginAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.loginAttempts, key)
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"time"
+)
+
+func (m *MemoryDB) SaveMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := *challenge
+	entry.Scope = append([]string{}, challenge.Scope...)
+	m.mfaChallenges[challenge.ID] = entry
+
+	return nil
+}
+
+func (m *MemoryDB) AttemptMFAChallenge(ctx context.Context, id string, maxAttempts int) (*models.MFAChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	challenge, ok := m.mfaChallenges[id]
+
+	if !ok || challenge.Attempts >= maxAttempts || challenge.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	challenge.Attempts++
+	m.mfaChallenges[id] = challenge
+
+	return &challenge, nil
+}
+
+func (m *MemoryDB) DeleteMFAChallenge(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.mfaChallenges[id]; !ok {
+		return repositores.ErrNotFound
+	}
+
+	delete(m.mfaChallenges, id)
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryDB keeps everything in process, it is not shared between replicas.
+// It is meant for tests and local development
+type MemoryDB struct {
+	Hasher        repositores.PasswordHasher
+	mu            sync.RWMutex
+	users         map[primitive.ObjectID]models.User
+	clients       map[string]models.Client
+	signingKeys   map[string]models.SigningKey
+	leases        map[string]models.Lease
+	refreshTokens map[string]models.RefreshToken
+	revokedTokens map[string]time.Time
+	authCodes     map[string]models.AuthCode
+	deviceCodes   map[string]models.DeviceCode
+	userTokens    map[string]models.UserToken
+	mfaChallenges map[string]models.MFAChallenge
+	webAuthnCreds map[string]models.WebAuthnCredential
+	webAuthnChals map[string]models.WebAuthnChallenge
+	loginAttempts map[string]models.LoginAttempts
+	rateBuckets   map[string]models.RateLimitBucket
+	rateWindows   map[string]models.RateLimitWindow
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:         map[primitive.ObjectID]models.User{},
+		clients:       map[string]models.Client{},
+		signingKeys:   map[string]models.SigningKey{},
+		leases:        map[string]models.Lease{},
+		refreshTokens: map[string]models.RefreshToken{},
+		revokedTokens: map[string]time.Time{},
+		authCodes:     map[string]models.AuthCode{},
+		deviceCodes:   map[string]models.DeviceCode{},
+		userTokens:    map[string]models.UserToken{},
+		mfaChallenges: map[string]models.MFAChallenge{},
+		webAuthnCreds: map[string]models.WebAuthnCredential{},
+		webAuthnChals: map[string]models.WebAuthnChallenge{},
+		loginAttempts: map[string]models.LoginAttempts{},
+		rateBuckets:   map[string]models.RateLimitBucket{},
+		rateWindows:   map[string]models.RateLimitWindow{},
+	}
+}
+
+// drop expired entries
+func (m *MemoryDB) Clean() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for k, token := range m.refreshTokens {
+		if token.ExpiresAt.Time().Before(now) {
+			delete(m.refreshTokens, k)
+		}
+	}
+
+	for k, expiresAt := range m.revokedTokens {
+		if expiresAt.Before(now) {
+			delete(m.revokedTokens, k)
+		}
+	}
+
+	for k, code := range m.authCodes {
+		if code.ExpiresAt.Time().Before(now) {
+			delete(m.authCodes, k)
+		}
+	}
+
+	for k, code := range m.deviceCodes {
+		if code.ExpiresAt.Time().Before(now) {
+			delete(m.deviceCodes, k)
+		}
+	}
+
+	for k, token := range m.userTokens {
+		if token.ExpiresAt.Time().Before(now) {
+			delete(m.userTokens, k)
+		}
+	}
+
+	for k, challenge := range m.mfaChallenges {
+		if challenge.ExpiresAt.Time().Before(now) {
+			delete(m.mfaChallenges, k)
+		}
+	}
+
+	for k, challenge := range m.webAuthnChals {
+		if challenge.ExpiresAt.Time().Before(now) {
+			delete(m.webAuthnChals, k)
+		}
+	}
+
+	for k, attempts := range m.loginAttempts {
+		if attempts.ExpiresAt.Time().Before(now) {
+			delete(m.loginAttempts, k)
+		}
+	}
+
+	for k, bucket := range m.rateBuckets {
+		if bucket.ExpiresAt.Time().Before(now) {
+			delete(m.rateBuckets, k)
+		}
+	}
+
+	for k, counter := range m.rateWindows {
+		if counter.ExpiresAt.Time().Before(now) {
+			delete(m.rateWindows, k)
+		}
+	}
+}
+
+func (m *MemoryDB) CleanWorker(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			m.Clean()
+		}
+	}()
+}
@@ -0,0 +1,117 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MemoryDB) SaveAuthCode(ctx context.Context, code *models.AuthCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.authCodes[code.ID] = *code
+
+	return nil
+}
+
+func (m *MemoryDB) GetAuthCode(ctx context.Context, id string) (*models.AuthCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	code, ok := m.authCodes[id]
+
+	if !ok || code.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &code, nil
+}
+
+func (m *MemoryDB) UseAuthCode(ctx context.Context, id string) (*models.AuthCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	code, ok := m.authCodes[id]
+
+	if !ok || code.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	if code.Used {
+		return &code, repositores.ErrConflict
+	}
+
+	code.Used = true
+	m.authCodes[id] = code
+
+	return &code, nil
+}
+
+func (m *MemoryDB) SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, other := range m.deviceCodes {
+		if other.UserCode == code.UserCode && id != code.ID {
+			return repositores.ErrDuplicate
+		}
+	}
+
+	m.deviceCodes[code.ID] = *code
+
+	return nil
+}
+
+func (m *MemoryDB) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, code := range m.deviceCodes {
+		if code.UserCode == userCode && code.ExpiresAt.Time().After(time.Now()) {
+			return &code, nil
+		}
+	}
+
+	return nil, repositores.ErrNotFound
+}
+
+func (m *MemoryDB) PollDeviceCode(ctx context.Context, id string, polledAt time.Time) (*models.DeviceCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	code, ok := m.deviceCodes[id]
+
+	if !ok {
+		return nil, repositores.ErrNotFound
+	}
+
+	updated := code
+	updated.LastPolledAt = primitive.NewDateTimeFromTime(polledAt)
+	m.deviceCodes[id] = updated
+
+	return &code, nil
+}
+
+func (m *MemoryDB) UseDeviceCode(ctx context.Context, id string) (*models.DeviceCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	code, ok := m.deviceCodes[id]
+
+	if !ok {
+		return nil, repositores.ErrNotFound
+	}
+
+	if code.Status != models.DeviceApproved {
+		return &code, repositores.ErrConflict
+	}
+
+	code.Status = models.DeviceUsed
+	m.deviceCodes[id] = code
+
+	return &code, nil
+}
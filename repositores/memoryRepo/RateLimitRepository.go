@@ -0,0 +1,61 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"context"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MemoryDB) TakeRateLimitToken(ctx context.Context, key string, now primitive.DateTime, rate float64, burst int) (*models.RateLimitBucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.rateBuckets[key]
+
+	if !ok {
+		bucket = models.RateLimitBucket{ID: key, Tokens: float64(burst), UpdatedAt: now}
+	}
+
+	elapsed := now.Time().Sub(bucket.UpdatedAt.Time()).Seconds()
+	bucket.Tokens = math.Min(float64(burst), bucket.Tokens+elapsed*rate)
+	bucket.Allowed = bucket.Tokens >= 1
+
+	if bucket.Allowed {
+		bucket.Tokens--
+	}
+
+	bucket.UpdatedAt = now
+	bucket.ExpiresAt = primitive.NewDateTimeFromTime(now.Time().Add(time.Duration(float64(burst) / rate * float64(time.Second))))
+	m.rateBuckets[key] = bucket
+
+	return &bucket, nil
+}
+
+func (m *MemoryDB) AddRateLimitHit(ctx context.Context, key string, start primitive.DateTime, window time.Duration) (*models.RateLimitWindow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counter := m.rateWindows[key]
+	previous := primitive.NewDateTimeFromTime(start.Time().Add(-window))
+
+	switch counter.WindowStart {
+	case start:
+		counter.Hits++
+	case previous:
+		counter.PreviousHits = counter.Hits
+		counter.Hits = 1
+	default:
+		counter.PreviousHits = 0
+		counter.Hits = 1
+	}
+
+	counter.ID = key
+	counter.WindowStart = start
+	counter.ExpiresAt = primitive.NewDateTimeFromTime(start.Time().Add(2 * window))
+	m.rateWindows[key] = counter
+
+	return &counter, nil
+}
@@ -0,0 +1,104 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"time"
+)
+
+func (m *MemoryDB) SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refreshTokens[token.ID] = *token
+
+	return nil
+}
+
+func (m *MemoryDB) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.refreshTokens[id]
+
+	if !ok || token.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &token, nil
+}
+
+func (m *MemoryDB) UseRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refreshTokens[id]
+
+	if !ok || token.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	if token.Used {
+		return &token, repositores.ErrConflict
+	}
+
+	token.Used = true
+	m.refreshTokens[id] = token
+
+	return &token, nil
+}
+
+func (m *MemoryDB) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, token := range m.refreshTokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			m.refreshTokens[id] = token
+		}
+	}
+
+	return nil
+}
+
+// returns the revoked family ids
+func (m *MemoryDB) RevokeUserRefreshTokens(ctx context.Context, userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	families := map[string]bool{}
+	for id, token := range m.refreshTokens {
+		if token.UserID == userID && !token.Revoked {
+			token.Revoked = true
+			m.refreshTokens[id] = token
+			families[token.FamilyID] = true
+		}
+	}
+
+	ids := []string{}
+	for id := range families {
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (m *MemoryDB) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokedTokens[id] = expiresAt
+
+	return nil
+}
+
+func (m *MemoryDB) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	expiresAt, ok := m.revokedTokens[id]
+
+	return ok && time.Now().Before(expiresAt), nil
+}
@@ -0,0 +1,414 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MemoryDB) CreateUser(ctx context.Context, usr *models.User) error {
+	hashPassword, err := m.Hasher.Hash(usr.UserAuth.Password)
+
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.userByLogin(&usr.UserAuth); found {
+		return repositores.ErrDuplicate
+	}
+
+	usr.ID = primitive.NewObjectID()
+	usr.UserAuth.Password = hashPassword
+	usr.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	usr.UpdatedAt = usr.CreatedAt
+
+	m.users[usr.ID] = copyUser(usr)
+
+	return nil
+}
+
+func (m *MemoryDB) ValidUserByLonginUser(ctx context.Context, userAuth *models.UserAuth) (*models.User, error) {
+	m.mu.RLock()
+	usr, found := m.userByLogin(userAuth)
+	m.mu.RUnlock()
+
+	if !found {
+		return nil, repositores.ErrNotFound
+	}
+
+	rehash, err := repositores.VerifyPassword(m.Hasher, usr.UserAuth.Password, userAuth.Password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if usr.Disabled {
+		return nil, repositores.ErrUserDisabled
+	}
+
+	if rehash {
+		m.rehashPassword(usr.ID.Hex(), usr.UserAuth.Password, userAuth.Password)
+	}
+
+	//same projection as the mongo lookup
+	usr.UserAuth.Password = ""
+	usr.ThirdPartySecrets = nil
+
+	return usr, nil
+}
+
+func (m *MemoryDB) IsUserLoninIdUnique(ctx context.Context, userAuth *models.UserAuth) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, found := m.userByLogin(userAuth)
+
+	return !found, nil
+}
+
+func (m *MemoryDB) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return nil, repositores.ErrNotFound
+	}
+
+	m.mu.RLock()
+	usr, ok := m.users[objID]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, repositores.ErrNotFound
+	}
+
+	usr.UserAuth.Password = ""
+	usr.ThirdPartySecrets = nil
+
+	return &usr, nil
+}
+
+func (m *MemoryDB) ListUsers(ctx context.Context, filter *models.UserFilter) ([]models.User, error) {
+	query := strings.ToLower(filter.Query)
+	users := []models.User{}
+
+	m.mu.RLock()
+	for _, usr := range m.users {
+		scope := usr.UserAuth.Scope
+		created := usr.CreatedAt.Time()
+
+		switch {
+		case filter.Domain != "" && scope.Domain != filter.Domain,
+			filter.AppID != "" && scope.AppID != filter.AppID,
+			filter.Role != "" && scope.Role.RoleNmae != filter.Role,
+			!filter.CreatedAfter.IsZero() && created.Before(filter.CreatedAfter),
+			!filter.CreatedBefore.IsZero() && !created.Before(filter.CreatedBefore),
+			filter.After != "" && usr.ID.Hex() <= filter.After,
+			query != "" && !userMatches(&usr, query):
+			continue
+		}
+
+		usr.UserAuth.Password = ""
+		usr.ThirdPartySecrets = nil
+		users = append(users, usr)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID.Hex() < users[j].ID.Hex()
+	})
+
+	if len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+
+	return users, nil
+}
+
+func (m *MemoryDB) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	return m.updateUser(userID, func(usr *models.User) error {
+		usr.Disabled = disabled
+		return nil
+	})
+}
+
+func (m *MemoryDB) SetUserRole(ctx context.Context, userID string, role models.UserRole) error {
+	return m.updateUser(userID, func(usr *models.User) error {
+		usr.UserAuth.Scope.Role.RoleNmae = role.RoleNmae
+		usr.UserAuth.Scope.Role.Description = role.Description
+		return nil
+	})
+}
+
+func (m *MemoryDB) DeleteUser(ctx context.Context, userID string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[objID]; !ok {
+		return repositores.ErrNotFound
+	}
+
+	delete(m.users, objID)
+
+	for id, credential := range m.webAuthnCreds {
+		if credential.UserID == userID {
+			delete(m.webAuthnCreds, id)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) GetUserByLoginID(ctx context.Context, userAuth *models.UserAuth) (*models.User, error) {
+	m.mu.RLock()
+	usr, found := m.userByLogin(userAuth)
+	m.mu.RUnlock()
+
+	if !found {
+		return nil, repositores.ErrNotFound
+	}
+
+	usr.UserAuth.Password = ""
+	usr.ThirdPartySecrets = nil
+
+	return usr, nil
+}
+
+func (m *MemoryDB) UpdatePassword(ctx context.Context, userID string, password string) error {
+	hashPassword, err := m.Hasher.Hash(password)
+
+	if err != nil {
+		return err
+	}
+
+	return m.updateUser(userID, func(usr *models.User) error {
+		usr.UserAuth.Password = hashPassword
+		return nil
+	})
+}
+
+// rehashPassword upgrades the hash after a login unless the password was
+// changed meanwhile
+func (m *MemoryDB) rehashPassword(userID string, oldHash string, password string) {
+	hashPassword, err := m.Hasher.Hash(password)
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	m.updateUser(userID, func(usr *models.User) error {
+		if usr.UserAuth.Password == oldHash {
+			usr.UserAuth.Password = hashPassword
+		}
+		return nil
+	})
+}
+
+func (m *MemoryDB) SetMFA(ctx context.Context, userID string, mfa *models.MFA) error {
+	return m.updateUser(userID, func(usr *models.User) error {
+		usr.MFA = *mfa
+		usr.MFA.RecoveryCodes = append([]string{}, mfa.RecoveryCodes...)
+		return nil
+	})
+}
+
+func (m *MemoryDB) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return m.updateUser(userID, func(usr *models.User) error {
+		if step <= usr.MFA.LastStep {
+			return repositores.ErrConflict
+		}
+
+		usr.MFA.LastStep = step
+		return nil
+	})
+}
+
+func (m *MemoryDB) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	return m.updateUser(userID, func(usr *models.User) error {
+		for i, code := range usr.MFA.RecoveryCodes {
+			if code == codeHash {
+				usr.MFA.RecoveryCodes = append(usr.MFA.RecoveryCodes[:i], usr.MFA.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+
+		return repositores.ErrNotFound
+	})
+}
+
+func (m *MemoryDB) SetEmailVerified(ctx context.Context, userID string, email string, verifiedAt primitive.DateTime) error {
+	return m.updateUser(userID, func(usr *models.User) error {
+		if usr.Profile.Email != email {
+			return repositores.ErrConflict
+		}
+
+		usr.Profile.EmailVerified = true
+		usr.Profile.EmailVerifiedAt = verifiedAt
+		usr.UpdatedAt = repositores.NextVersion(usr.UpdatedAt)
+		return nil
+	})
+}
+
+func (m *MemoryDB) UpdateUserProfile(ctx context.Context, userID string, profile models.UserPorfile, version primitive.DateTime) (primitive.DateTime, error) {
+	next := repositores.NextVersion(version)
+
+	err := m.updateUser(userID, func(usr *models.User) error {
+		if usr.UpdatedAt != version {
+			return repositores.ErrConflict
+		}
+
+		usr.Profile = profile
+		usr.UpdatedAt = next
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return next, nil
+}
+
+func (m *MemoryDB) AddThirdPartySecret(ctx context.Context, userID string, secret models.ThirdPartySecret) error {
+	return m.updateUser(userID, func(usr *models.User) error {
+		for _, s := range usr.ThirdPartySecrets {
+			if s.KeyName == secret.KeyName {
+				return repositores.ErrDuplicate
+			}
+		}
+
+		usr.ThirdPartySecrets = append(usr.ThirdPartySecrets, secret)
+		return nil
+	})
+}
+
+func (m *MemoryDB) UpdateThirdPartySecret(ctx context.Context, userID string, secret models.ThirdPartySecret) error {
+	return m.updateUser(userID, func(usr *models.User) error {
+		for i, s := range usr.ThirdPartySecrets {
+			if s.KeyName == secret.KeyName {
+				usr.ThirdPartySecrets[i] = secret
+				return nil
+			}
+		}
+
+		return repositores.ErrNotFound
+	})
+}
+
+func (m *MemoryDB) ReplaceJwtSecret(ctx context.Context, userID string, key string, old string, value string) error {
+	err := m.updateUser(userID, func(usr *models.User) error {
+		for i, s := range usr.ThirdPartySecrets {
+			if s.KeyName == key && s.KeyValue == old {
+				usr.ThirdPartySecrets[i].KeyValue = value
+				return nil
+			}
+		}
+
+		//changed meanwhile
+		return repositores.ErrNotFound
+	})
+
+	if errors.Is(err, repositores.ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (m *MemoryDB) GetJwtSecret(ctx context.Context, userID string, key string) (string, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return "", repositores.ErrNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, secret := range m.users[objID].ThirdPartySecrets {
+		if secret.KeyName == key {
+			return secret.KeyValue, nil
+		}
+	}
+
+	return "", repositores.ErrNotFound
+}
+
+// updateUser applies update to a copy of the user and stores it on success
+func (m *MemoryDB) updateUser(userID string, update func(usr *models.User) error) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[objID]
+
+	if !ok {
+		return repositores.ErrNotFound
+	}
+
+	usr := copyUser(&stored)
+
+	if err := update(&usr); err != nil {
+		return err
+	}
+
+	m.users[objID] = usr
+
+	return nil
+}
+
+// userByLogin finds the user of a login id in its Domain/AppID, callers hold
+// the lock
+func (m *MemoryDB) userByLogin(userAuth *models.UserAuth) (*models.User, bool) {
+	for _, usr := range m.users {
+		if usr.UserAuth.LoginID == userAuth.LoginID &&
+			usr.UserAuth.Scope.Domain == userAuth.Scope.Domain &&
+			usr.UserAuth.Scope.AppID == userAuth.Scope.AppID {
+			result := copyUser(&usr)
+			return &result, true
+		}
+	}
+
+	return nil, false
+}
+
+// userMatches searches login id, email and names for a lower case query
+func userMatches(usr *models.User, query string) bool {
+	for _, field := range []string{usr.UserAuth.LoginID, usr.Profile.Email, usr.Profile.FisrtName, usr.Profile.LastNmae} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyUser keeps callers from sharing the stored slices
+func copyUser(usr *models.User) models.User {
+	result := *usr
+	result.ThirdPartySecrets = append([]models.ThirdPartySecret{}, usr.ThirdPartySecrets...)
+	result.MFA.RecoveryCodes = append([]string{}, usr.MFA.RecoveryCodes...)
+
+	return result
+}
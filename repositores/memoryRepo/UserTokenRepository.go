@@ -0,0 +1,49 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"time"
+)
+
+func (m *MemoryDB) SaveUserToken(ctx context.Context, token *models.UserToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.userTokens[token.ID] = *token
+
+	return nil
+}
+
+func (m *MemoryDB) UseUserToken(ctx context.Context, id string, purpose string) (*models.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.userTokens[id]
+
+	if !ok || token.Purpose != purpose {
+		return nil, repositores.ErrNotFound
+	}
+
+	delete(m.userTokens, id)
+
+	if token.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &token, nil
+}
+
+func (m *MemoryDB) DeleteUserTokens(ctx context.Context, userID string, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, token := range m.userTokens {
+		if token.UserID == userID && token.Purpose == purpose {
+			delete(m.userTokens, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package memoryRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MemoryDB) AddWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webAuthnCreds[credential.ID]; ok {
+		return repositores.ErrDuplicate
+	}
+
+	m.webAuthnCreds[credential.ID] = copyWebAuthnCredential(credential)
+
+	return nil
+}
+
+func (m *MemoryDB) GetWebAuthnCredential(ctx context.Context, id string) (*models.WebAuthnCredential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	credential, ok := m.webAuthnCreds[id]
+
+	if !ok {
+		return nil, repositores.ErrNotFound
+	}
+
+	result := copyWebAuthnCredential(&credential)
+
+	return &result, nil
+}
+
+func (m *MemoryDB) ListWebAuthnCredentials(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	credentials := []models.WebAuthnCredential{}
+
+	for _, credential := range m.webAuthnCreds {
+		if credential.UserID == userID {
+			credentials = append(credentials, copyWebAuthnCredential(&credential))
+		}
+	}
+
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].CreatedAt < credentials[j].CreatedAt
+	})
+
+	return credentials, nil
+}
+
+func (m *MemoryDB) UseWebAuthnCredential(ctx context.Context, id string, signCount int64, usedAt primitive.DateTime) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credential, ok := m.webAuthnCreds[id]
+
+	if !ok {
+		return repositores.ErrNotFound
+	}
+
+	if signCount <= credential.SignCount && (signCount != 0 || credential.SignCount != 0) {
+		return repositores.ErrConflict
+	}
+
+	credential.SignCount = signCount
+	credential.LastUsedAt = usedAt
+	m.webAuthnCreds[id] = credential
+
+	return nil
+}
+
+func (m *MemoryDB) DeleteWebAuthnCredential(ctx context.Context, userID string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credential, ok := m.webAuthnCreds[id]
+
+	if !ok || credential.UserID != userID {
+		return repositores.ErrNotFound
+	}
+
+	delete(m.webAuthnCreds, id)
+
+	return nil
+}
+
+func (m *MemoryDB) SaveWebAuthnChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.webAuthnChals[challenge.ID] = *challenge
+
+	return nil
+}
+
+func (m *MemoryDB) UseWebAuthnChallenge(ctx context.Context, id string) (*models.WebAuthnChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	challenge, ok := m.webAuthnChals[id]
+
+	if !ok {
+		return nil, repositores.ErrNotFound
+	}
+
+	delete(m.webAuthnChals, id)
+
+	if challenge.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &challenge, nil
+}
+
+func copyWebAuthnCredential(credential *models.WebAuthnCredential) models.WebAuthnCredential {
+	result := *credential
+	result.PublicKey = append([]byte{}, credential.PublicKey...)
+	result.Transports = append([]string{}, credential.Transports...)
+
+	return result
+}
@@ -0,0 +1,165 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const clientDB = "client"
+
+func (m *MongoDB) CreateClient(ctx context.Context, cl *models.Client) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(clientDB)
+
+	if cl.Secret != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(cl.Secret), bcrypt.DefaultCost)
+
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		cl.SecretHash = string(hash)
+	}
+
+	cl.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	cl.UpdatedAt = cl.CreatedAt
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err := coll.InsertOne(ctx, cl)
+
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return repositores.ErrDuplicate
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) GetClientByID(ctx context.Context, clientID string) (*models.Client, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(clientDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.Client
+	err := coll.FindOne(ctx, bson.M{"_id": clientID}).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) ValidClientSecret(ctx context.Context, clientID string, secret string) (*models.Client, error) {
+	result, err := m.GetClientByID(ctx, clientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result.SecretHash == "" {
+		return nil, repositores.ErrInvalidCredentials
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(result.SecretHash), []byte(secret))
+
+	if err != nil {
+		return nil, repositores.ErrInvalidCredentials
+	}
+
+	return result, nil
+}
+
+func (m *MongoDB) ListClients(ctx context.Context) ([]models.Client, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(clientDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, bson.M{})
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	clients := []models.Client{}
+	err = cursor.All(ctx, &clients)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+func (m *MongoDB) UpdateClientSecret(ctx context.Context, clientID string, secret string) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(clientDB)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"secret_hash": string(hash), "updated_at": primitive.NewDateTimeFromTime(time.Now())}}
+	res, err := coll.UpdateOne(ctx, bson.M{"_id": clientID}, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (m *MongoDB) DeleteClient(ctx context.Context, clientID string) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(clientDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": clientID})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	signingKeyDB = "signing_key"
+	leaseDB      = "lease"
+)
+
+func (m *MongoDB) SaveSigningKey(ctx context.Context, key *models.SigningKey) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(signingKeyDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": key.Kid}, key, opts)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(signingKeyDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, bson.M{})
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	keys := []models.SigningKey{}
+	err = cursor.All(ctx, &keys)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m *MongoDB) AcquireLease(ctx context.Context, name string, holder string, now primitive.DateTime, ttl time.Duration) (bool, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(leaseDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": name, "$or": bson.A{
+		bson.M{"holder": holder},
+		bson.M{"expires_at": bson.M{"$lte": now}},
+	}}
+	update := bson.M{"$set": bson.M{"holder": holder, "expires_at": primitive.NewDateTimeFromTime(now.Time().Add(ttl))}}
+
+	_, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+
+	//the lease exists and is held by someone else, so the upsert collides
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
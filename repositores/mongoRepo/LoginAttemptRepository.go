@@ -0,0 +1,114 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const loginAttemptDB = "login_attempt"
+
+func (m *MongoDB) AddLoginFailure(ctx context.Context, key string, now primitive.DateTime, window time.Duration) (*models.LoginAttempts, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(loginAttemptDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cutoff := primitive.NewDateTimeFromTime(now.Time().Add(-window))
+	recent := bson.M{"$gte": bson.A{"$last_failure", cutoff}}
+
+	//a pipeline update counts on from the stored document or starts over,
+	//a missing document compares as older than the cutoff
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failures":         bson.M{"$cond": bson.A{recent, bson.M{"$add": bson.A{"$failures", 1}}, 1}},
+			"first_failure":    bson.M{"$cond": bson.A{recent, "$first_failure", now}},
+			"previous_failure": bson.M{"$cond": bson.A{recent, "$last_failure", primitive.DateTime(0)}},
+			"last_failure":     now,
+			"expires_at":       primitive.NewDateTimeFromTime(now.Time().Add(window)),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var result models.LoginAttempts
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&result)
+
+	//two first failures can race on the upsert, the second one retries as an update
+	if mongo.IsDuplicateKeyError(err) {
+		err = coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&result)
+	}
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(loginAttemptDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.LoginAttempts
+	filter := bson.M{"_id": key, "expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}}
+	err := coll.FindOne(ctx, filter).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) RemoveLoginFailure(ctx context.Context, key string) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(loginAttemptDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	update := bson.A{bson.M{"$set": bson.M{
+		"failures":     bson.M{"$subtract": bson.A{"$failures", 1}},
+		"last_failure": "$previous_failure",
+	}}}
+
+	_, err := coll.UpdateOne(ctx, bson.M{"_id": key, "failures": bson.M{"$gt": 0}}, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) ResetLoginAttempts(ctx context.Context, key string) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(loginAttemptDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := coll.DeleteOne(ctx, bson.M{"_id": key})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const mfaChallengeDB = "mfa_challenge"
+
+func (m *MongoDB) SaveMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(mfaChallengeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := coll.InsertOne(ctx, challenge)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) AttemptMFAChallenge(ctx context.Context, id string, maxAttempts int) (*models.MFAChallenge, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(mfaChallengeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.MFAChallenge
+	filter := bson.M{
+		"_id":        id,
+		"attempts":   bson.M{"$lt": maxAttempts},
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	update := bson.M{"$inc": bson.M{"attempts": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) DeleteMFAChallenge(ctx context.Context, id string) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(mfaChallengeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": id})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
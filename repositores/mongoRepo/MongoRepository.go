@@ -2,40 +2,33 @@ package mongoRepo
 
 import (
 	"auth/models"
+	"auth/repositores"
 	"context"
 	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const userDB = "user"
 
-type Operations struct {
-	Create string
-	Read   string
-	Update string
-	Delete string
-}
-
 type MongoDB struct {
-	Host       string
-	Port       string
-	DefualtDb  string
-	Admin      string
-	Password   string
-	DBClint    *mongo.Client
-	Operations *Operations
+	Host      string
+	Port      string
+	DefualtDb string
+	Admin     string
+	Password  string
+	DBClint   *mongo.Client
+	Hasher    repositores.PasswordHasher
 }
 
-func (m *MongoDB) ConnectDB() interface{} {
+func (m *MongoDB) ConnectDB() *mongo.Client {
 	// `mongodb://${process.env.MONGO_USER}:${process.env.MONGO_PASSWORD}@${process.env.MONGO_IP}:${process.env.MONGO_PORT}/?authSource=admin`
 
 	// mongoDbUri := fmt.Sprintf("mongodb://%s:%s", m.Host, m.Port)
@@ -62,108 +55,302 @@ func (m *MongoDB) ConnectDB() interface{} {
 	return c
 }
 
-func (m *MongoDB) CreateUser(usr *models.User) (interface{}, error) {
+func (m *MongoDB) CreateUser(ctx context.Context, usr *models.User) error {
 	client := m.DBClint
 	coll := client.Database(m.DefualtDb).Collection(userDB)
 
 	usr.ID = primitive.NewObjectID()
-	hashPassword, err := bcrypt.GenerateFromPassword([]byte(usr.UserAuth.Password), 8)
+	hashPassword, err := m.Hasher.Hash(usr.UserAuth.Password)
 
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return err
 	}
 
-	usr.UserAuth.Password = string(hashPassword)
-	usr.CreatedAt = primitive.NewDateTimeFromTime(time.Now().AddDate(-1, 0, 0))
-	usr.UpdatedAt = primitive.NewDateTimeFromTime(time.Now().AddDate(-1, 0, 0))
+	usr.UserAuth.Password = hashPassword
+	usr.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	usr.UpdatedAt = usr.CreatedAt
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	result, err := coll.InsertOne(ctx, usr)
+	_, err = coll.InsertOne(ctx, usr)
 
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return repositores.ErrDuplicate
+		}
+
 		log.Println(err)
-		return nil, err
+		return err
 	}
 
-	return result, nil
+	return nil
 }
 
-func (m *MongoDB) ValidUserByLonginUser(userAuth *models.UserAuth) (*models.User, string, error) {
+func (m *MongoDB) ValidUserByLonginUser(ctx context.Context, userAuth *models.UserAuth) (*models.User, error) {
 	client := m.DBClint
 	coll := client.Database(m.DefualtDb).Collection(userDB)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var result models.User
-	filter := bson.D{{Key: "user_auth.login_id", Value: userAuth.LoginID}}
-	opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "user_auth", Value: 1}, {Key: "profile", Value: 1}})
-	err := coll.FindOne(ctx, filter, opts).Decode(&result)
+	opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "user_auth", Value: 1}, {Key: "profile", Value: 1}, {Key: "disabled", Value: 1}, {Key: "mfa", Value: 1},
+		{Key: "created_at", Value: 1}, {Key: "updated_at", Value: 1}})
+	err := coll.FindOne(ctx, loginFilter(userAuth), opts).Decode(&result)
 
 	if err != nil {
-		if strings.Contains(err.Error(), "no documents") {
-			return nil, "", errors.New("invalid user id")
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
 		}
 
-		return nil, "", err
+		log.Println(err)
+		return nil, err
+	}
+
+	rehash, err := repositores.VerifyPassword(m.Hasher, result.UserAuth.Password, userAuth.Password)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if ok, err := passwordMatches(result.UserAuth.Password, userAuth.Password); !ok {
-		return nil, "", err
+	if result.Disabled {
+		return nil, repositores.ErrUserDisabled
 	}
 
-	if result.UserAuth.Scope.Domain != userAuth.Scope.Domain || result.UserAuth.Scope.AppID != userAuth.Scope.AppID {
-		return nil, "", errors.New("invalid user id")
+	if rehash {
+		m.rehashPassword(ctx, result.ID, result.UserAuth.Password, userAuth.Password)
 	}
 
 	result.UserAuth.Password = ""
 
-	return &result, result.ID.Hex(), nil
+	return &result, nil
 }
 
-func (m *MongoDB) IsUserLoninIdUnique(userAuth *models.UserAuth) (bool, error) {
+func (m *MongoDB) IsUserLoninIdUnique(ctx context.Context, userAuth *models.UserAuth) (bool, error) {
 	client := m.DBClint
 	coll := client.Database(m.DefualtDb).Collection(userDB)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cnt, err := coll.CountDocuments(ctx, loginFilter(userAuth))
+
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return cnt == 0, nil
+}
+
+func (m *MongoDB) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return nil, repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var result models.User
-	filter := bson.D{{Key: "user_auth.login_id", Value: userAuth.LoginID}}
-	opts := options.FindOne().SetProjection(bson.D{{Key: "user_auth", Value: 1}})
-	err := coll.FindOne(ctx, filter, opts).Decode(&result)
+	opts := options.FindOne().SetProjection(bson.D{{Key: "user_auth.password", Value: 0}, {Key: "third_party_secrets", Value: 0}})
+	err = coll.FindOne(ctx, bson.M{"_id": objID}, opts).Decode(&result)
 
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
 		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) ListUsers(ctx context.Context, filter *models.UserFilter) ([]models.User, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+
+	if filter.Domain != "" {
+		query["user_auth.scope.user_domain"] = filter.Domain
+	}
+
+	if filter.AppID != "" {
+		query["user_auth.scope.user_app_id"] = filter.AppID
+	}
+
+	if filter.Role != "" {
+		query["user_auth.scope.user_role.role_name"] = filter.Role
+	}
+
+	created := bson.M{}
+
+	if !filter.CreatedAfter.IsZero() {
+		created["$gte"] = primitive.NewDateTimeFromTime(filter.CreatedAfter)
+	}
+
+	if !filter.CreatedBefore.IsZero() {
+		created["$lt"] = primitive.NewDateTimeFromTime(filter.CreatedBefore)
+	}
+
+	if len(created) > 0 {
+		query["created_at"] = created
+	}
+
+	if filter.After != "" {
+		after, err := primitive.ObjectIDFromHex(filter.After)
 
-		if strings.Contains(err.Error(), "no documents") {
-			return true, nil
+		if err != nil {
+			return nil, repositores.ErrNotFound
 		}
-		return false, err
+
+		query["_id"] = bson.M{"$gt": after}
+	}
+
+	if filter.Query != "" {
+		search := primitive.Regex{Pattern: regexp.QuoteMeta(filter.Query), Options: "i"}
+		query["$or"] = bson.A{
+			bson.M{"user_auth.login_id": search},
+			bson.M{"profile.email": search},
+			bson.M{"profile.first_name": search},
+			bson.M{"profile.last_name": search},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(filter.Limit)).
+		SetProjection(bson.D{{Key: "user_auth.password", Value: 0}, {Key: "third_party_secrets", Value: 0}})
+	cursor, err := coll.Find(ctx, query, opts)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
 
-	if result.UserAuth.Scope.Domain != userAuth.Scope.Domain || result.UserAuth.Scope.AppID != userAuth.Scope.AppID {
-		return true, nil
+	users := []models.User{}
+	err = cursor.All(ctx, &users)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
 
-	return false, nil
+	return users, nil
 }
 
-func (m *MongoDB) GetUserByID(objID interface{}, params ...interface{}) (interface{}, error) {
-	objID = objID.(*primitive.ObjectID)
-	filterD := params[0].(primitive.D)
-	optsD := params[1].(primitive.D)
+func (m *MongoDB) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
 
 	client := m.DBClint
 	coll := client.Database(m.DefualtDb).Collection(userDB)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := coll.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"disabled": disabled}})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (m *MongoDB) SetUserRole(ctx context.Context, userID string, role models.UserRole) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"user_auth.scope.user_role.role_name":   role.RoleNmae,
+		"user_auth.scope.user_role.description": role.Description,
+	}}
+	res, err := coll.UpdateOne(ctx, bson.M{"_id": objID}, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (m *MongoDB) DeleteUser(ctx context.Context, userID string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": objID})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	//passkeys live in their own collection
+	_, err = client.Database(m.DefualtDb).Collection(webAuthnCredentialDB).DeleteMany(ctx, bson.M{"user_id": userID})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) GetUserByLoginID(ctx context.Context, userAuth *models.UserAuth) (*models.User, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var result models.User
-	opts := options.FindOne().SetProjection(optsD)
-	err := coll.FindOne(ctx, filterD, opts).Decode(&result)
+	opts := options.FindOne().SetProjection(bson.D{{Key: "user_auth.password", Value: 0}, {Key: "third_party_secrets", Value: 0}})
+	err := coll.FindOne(ctx, loginFilter(userAuth), opts).Decode(&result)
 
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
 		log.Println(err)
 		return nil, err
 	}
@@ -171,102 +358,340 @@ func (m *MongoDB) GetUserByID(objID interface{}, params ...interface{}) (interfa
 	return &result, nil
 }
 
-func (m *MongoDB) UpdateThirdPartySecretsByID(objID interface{}, secrets []models.ThirdPartySecret, operation string) (interface{}, error) {
-	var filter bson.M
+func (m *MongoDB) UpdatePassword(ctx context.Context, userID string, password string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
 
-	objID, err := primitive.ObjectIDFromHex(objID.(string))
 	if err != nil {
-		return nil, err
+		return repositores.ErrNotFound
 	}
 
-	newSecrets := models.ThirdPartySecret{
-		KeyName:     secrets[0].KeyName,
-		KeyValue:    secrets[0].KeyValue,
-		Description: secrets[0].Description}
+	hashPassword, err := m.Hasher.Hash(password)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 
 	client := m.DBClint
 	coll := client.Database(m.DefualtDb).Collection(userDB)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	var res interface{}
-	if operation == m.Operations.Create {
-		filter = bson.M{
-			"$and": []bson.M{
-				{"_id": objID},
-				{"third_party_secrets": bson.M{"$elemMatch": bson.M{"key_anme:": bson.M{"$ne": secrets[0].KeyName}}}}},
-		}
+	update := bson.M{"$set": bson.M{"user_auth.password": hashPassword}}
+	res, err := coll.UpdateOne(ctx, bson.M{"_id": objID}, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 
-		filter = bson.M{"_id": objID}
+	if res.MatchedCount == 0 {
+		return repositores.ErrNotFound
+	}
 
-		update := bson.M{"$push": bson.M{"third_party_secrets": newSecrets}}
-		res, err := coll.UpdateOne(ctx, filter, update)
+	return nil
+}
 
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
+func (m *MongoDB) SetMFA(ctx context.Context, userID string, mfa *models.MFA) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
 
-		return res, nil
+	if err != nil {
+		return repositores.ErrNotFound
 	}
 
-	if operation == m.Operations.Update {
-		filter = bson.M{
-			"$and": []bson.M{
-				{"_id": objID},
-				{"third_party_secrets.key_name": newSecrets.KeyName},
-			},
-		}
-		update := bson.M{"$set": bson.M{"third_party_secrets.$": newSecrets}}
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 
-		res, err = coll.UpdateOne(ctx, filter, update)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
+	res, err := coll.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"mfa": mfa}})
+
+	if err != nil {
+		log.Println(err)
+		return err
 	}
 
-	return res, nil
+	if res.MatchedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
 }
 
-func (m *MongoDB) GetJwtSecret(id string, key string) (string, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (m *MongoDB) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": objID, "mfa.last_step": bson.M{"$lt": step}}
+	res, err := coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa.last_step": step}})
 
 	if err != nil {
 		log.Println(err)
-		return "", err
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return repositores.ErrConflict
+	}
+
+	return nil
+}
+
+func (m *MongoDB) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
 	}
 
 	client := m.DBClint
 	coll := client.Database(m.DefualtDb).Collection(userDB)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	var result models.User
-	filter := bson.M{
-		"$and": []bson.M{
-			{"_id": objID},
-			{"third_party_secrets.key_name": key}},
+	filter := bson.M{"_id": objID, "mfa.recovery_codes": codeHash}
+	res, err := coll.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"mfa.recovery_codes": codeHash}})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (m *MongoDB) SetEmailVerified(ctx context.Context, userID string, email string, verifiedAt primitive.DateTime) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	//the version moves like NextVersion does on a profile update, at least
+	//one millisecond past the last one, so If-Match sees the change
+	now := primitive.NewDateTimeFromTime(time.Now())
+	filter := bson.M{"_id": objID, "profile.email": email}
+	update := bson.A{bson.M{"$set": bson.M{
+		"profile.email_verified":    true,
+		"profile.email_verified_at": verifiedAt,
+		"updated_at":                bson.M{"$max": bson.A{now, bson.M{"$add": bson.A{"$updated_at", 1}}}},
+	}}}
+	res, err := coll.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	cnt, err := coll.CountDocuments(ctx, bson.M{"_id": objID})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if cnt == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return repositores.ErrConflict
+}
+
+func (m *MongoDB) UpdateUserProfile(ctx context.Context, userID string, profile models.UserPorfile, version primitive.DateTime) (primitive.DateTime, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return 0, repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	next := repositores.NextVersion(version)
+	filter := bson.M{"_id": objID, "updated_at": version}
+	update := bson.M{"$set": bson.M{"profile": profile, "updated_at": next}}
+	res, err := coll.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	if res.MatchedCount > 0 {
+		return next, nil
+	}
+
+	cnt, err := coll.CountDocuments(ctx, bson.M{"_id": objID})
+
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	if cnt == 0 {
+		return 0, repositores.ErrNotFound
+	}
+
+	return 0, repositores.ErrConflict
+}
+
+// AddThirdPartySecret returns ErrDuplicate when the key name is taken
+func (m *MongoDB) AddThirdPartySecret(ctx context.Context, userID string, secret models.ThirdPartySecret) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": objID, "third_party_secrets.key_name": bson.M{"$ne": secret.KeyName}}
+	update := bson.M{"$push": bson.M{"third_party_secrets": secret}}
+	res, err := coll.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	cnt, err := coll.CountDocuments(ctx, bson.M{"_id": objID})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if cnt == 0 {
+		return repositores.ErrNotFound
 	}
 
+	return repositores.ErrDuplicate
+}
+
+func (m *MongoDB) UpdateThirdPartySecret(ctx context.Context, userID string, secret models.ThirdPartySecret) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": objID, "third_party_secrets.key_name": secret.KeyName}
+	update := bson.M{"$set": bson.M{"third_party_secrets.$": secret}}
+	res, err := coll.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (m *MongoDB) GetJwtSecret(ctx context.Context, userID string, key string) (string, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return "", repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.User
+	filter := bson.M{"_id": objID, "third_party_secrets.key_name": key}
 	opts := options.FindOne().SetProjection(bson.D{{Key: "third_party_secrets.$", Value: 1}})
 	err = coll.FindOne(ctx, filter, opts).Decode(&result)
 
 	if err != nil {
-
-		if strings.Contains(err.Error(), "no documents") {
-			return "", err
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", repositores.ErrNotFound
 		}
+
+		log.Println(err)
 		return "", err
 	}
 
 	return result.ThirdPartySecrets[0].KeyValue, nil
 }
 
+func (m *MongoDB) ReplaceJwtSecret(ctx context.Context, userID string, key string, old string, value string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return repositores.ErrNotFound
+	}
+
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": objID, "third_party_secrets": bson.M{"$elemMatch": bson.M{"key_name": key, "key_value": old}}}
+	update := bson.M{"$set": bson.M{"third_party_secrets.$.key_value": value}}
+	_, err = coll.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// a login id is unique per Domain/AppID
+func loginFilter(userAuth *models.UserAuth) bson.M {
+	return bson.M{
+		"user_auth.login_id":          userAuth.LoginID,
+		"user_auth.scope.user_domain": userAuth.Scope.Domain,
+		"user_auth.scope.user_app_id": userAuth.Scope.AppID,
+	}
+}
+
 // func updateUserByID(objID *primitive.ObjectID, filter primitive.M, update primitive.D, m *MongoDB) (*mongo.UpdateResult, error) {
 // 	client := m.DBClint
 // 	coll := client.Database(m.DefualtDb).Collection(userDB)
-// 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 // 	defer cancel()
 
 // 	res, err := coll.UpdateOne(ctx, filter, update)
@@ -279,20 +704,24 @@ func (m *MongoDB) GetJwtSecret(id string, key string) (string, error) {
 // 	return res, nil
 // }
 
-func passwordMatches(password string, plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(password), []byte(plainText))
-
-	// bcrypt.
+// rehashPassword upgrades the hash after a login unless the password was
+// changed meanwhile, a failure only means the next login tries again
+func (m *MongoDB) rehashPassword(ctx context.Context, id primitive.ObjectID, oldHash string, password string) {
+	hashPassword, err := m.Hasher.Hash(password)
 
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			err = errors.New("invalid user")
-			return false, err
-		default:
-			return false, err
-		}
+		log.Println(err)
+		return
 	}
 
-	return true, nil
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userDB)
+
+	filter := bson.M{"_id": id, "user_auth.password": oldHash}
+	update := bson.M{"$set": bson.M{"user_auth.password": hashPassword}}
+	_, err = coll.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		log.Println(err)
+	}
 }
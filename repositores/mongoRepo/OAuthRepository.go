@@ -0,0 +1,206 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	authCodeDB   = "auth_code"
+	deviceCodeDB = "device_code"
+)
+
+func (m *MongoDB) SaveAuthCode(ctx context.Context, code *models.AuthCode) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(authCodeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": code.ID}, code, opts)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) GetAuthCode(ctx context.Context, id string) (*models.AuthCode, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(authCodeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.AuthCode
+	err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	if result.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) UseAuthCode(ctx context.Context, id string) (*models.AuthCode, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(authCodeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.AuthCode
+	filter := bson.M{"_id": id, "used": false}
+	update := bson.M{"$set": bson.M{"used": true}}
+	err := coll.FindOneAndUpdate(ctx, filter, update).Decode(&result)
+
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Println(err)
+		return nil, err
+	}
+
+	used := err != nil
+
+	if used {
+		err = coll.FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, repositores.ErrNotFound
+			}
+
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	if result.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	if used {
+		return &result, repositores.ErrConflict
+	}
+
+	result.Used = true
+
+	return &result, nil
+}
+
+func (m *MongoDB) SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(deviceCodeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": code.ID}, code, opts)
+
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return repositores.ErrDuplicate
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(deviceCodeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.DeviceCode
+	filter := bson.M{"user_code": userCode, "expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}}
+	err := coll.FindOne(ctx, filter).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) PollDeviceCode(ctx context.Context, id string, polledAt time.Time) (*models.DeviceCode, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(deviceCodeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.DeviceCode
+	update := bson.M{"$set": bson.M{"last_polled_at": primitive.NewDateTimeFromTime(polledAt)}}
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": id}, update).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) UseDeviceCode(ctx context.Context, id string) (*models.DeviceCode, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(deviceCodeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.DeviceCode
+	filter := bson.M{"_id": id, "status": models.DeviceApproved}
+	update := bson.M{"$set": bson.M{"status": models.DeviceUsed}}
+	err := coll.FindOneAndUpdate(ctx, filter, update).Decode(&result)
+
+	if err == nil {
+		result.Status = models.DeviceUsed
+		return &result, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Println(err)
+		return nil, err
+	}
+
+	err = coll.FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, repositores.ErrConflict
+}
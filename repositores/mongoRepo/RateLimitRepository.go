@@ -0,0 +1,100 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	rateLimitBucketDB = "rate_limit_bucket"
+	rateLimitWindowDB = "rate_limit_window"
+)
+
+func (m *MongoDB) TakeRateLimitToken(ctx context.Context, key string, now primitive.DateTime, rate float64, burst int) (*models.RateLimitBucket, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(rateLimitBucketDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	//refill first, a missing document is a full bucket, then take a token
+	//from the refilled level
+	elapsed := bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}}
+	refilled := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$tokens", burst}}, bson.M{"$multiply": bson.A{elapsed, rate / 1000}}}}
+	allowed := bson.M{"$gte": bson.A{"$tokens", 1}}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$min": bson.A{float64(burst), refilled}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed":    allowed,
+			"tokens":     bson.M{"$cond": bson.A{allowed, bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+			"updated_at": now,
+			"expires_at": primitive.NewDateTimeFromTime(now.Time().Add(time.Duration(float64(burst) / rate * float64(time.Second)))),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var result models.RateLimitBucket
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&result)
+
+	//two first requests can race on the upsert, the second one retries as an update
+	if mongo.IsDuplicateKeyError(err) {
+		err = coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&result)
+	}
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) AddRateLimitHit(ctx context.Context, key string, start primitive.DateTime, window time.Duration) (*models.RateLimitWindow, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(rateLimitWindowDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	previous := primitive.NewDateTimeFromTime(start.Time().Add(-window))
+	current := bson.M{"$eq": bson.A{"$window_start", start}}
+
+	//a missing document matches neither window and starts over
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"previous_hits": bson.M{"$switch": bson.M{
+				"branches": bson.A{
+					bson.M{"case": current, "then": "$previous_hits"},
+					bson.M{"case": bson.M{"$eq": bson.A{"$window_start", previous}}, "then": "$hits"},
+				},
+				"default": 0,
+			}},
+			"hits":         bson.M{"$cond": bson.A{current, bson.M{"$add": bson.A{"$hits", 1}}, 1}},
+			"window_start": start,
+			"expires_at":   primitive.NewDateTimeFromTime(start.Time().Add(2 * window)),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var result models.RateLimitWindow
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&result)
+
+	if mongo.IsDuplicateKeyError(err) {
+		err = coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&result)
+	}
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
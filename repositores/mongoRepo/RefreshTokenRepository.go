@@ -0,0 +1,183 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	refreshTokenDB = "refresh_token"
+	revokedTokenDB = "revoked_token"
+)
+
+func (m *MongoDB) SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(refreshTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": token.ID}, token, opts)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(refreshTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.RefreshToken
+	err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	//the ttl monitor only runs every minute
+	if result.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) UseRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(refreshTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.RefreshToken
+	filter := bson.M{"_id": id, "used": false}
+	update := bson.M{"$set": bson.M{"used": true}}
+	err := coll.FindOneAndUpdate(ctx, filter, update).Decode(&result)
+
+	if err == nil {
+		if result.ExpiresAt.Time().Before(time.Now()) {
+			return nil, repositores.ErrNotFound
+		}
+
+		result.Used = true
+		return &result, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Println(err)
+		return nil, err
+	}
+
+	//either unknown or used before
+	used, err := m.GetRefreshToken(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return used, repositores.ErrConflict
+}
+
+func (m *MongoDB) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(refreshTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"family_id": familyID}
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	_, err := coll.UpdateMany(ctx, filter, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// returns the revoked family ids
+func (m *MongoDB) RevokeUserRefreshTokens(ctx context.Context, userID string) ([]string, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(refreshTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "revoked": false}
+	families, err := coll.Distinct(ctx, "family_id", filter)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	_, err = coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, family := range families {
+		if id, ok := family.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (m *MongoDB) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(revokedTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	doc := bson.M{"_id": id, "expires_at": primitive.NewDateTimeFromTime(expiresAt)}
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": id}, doc, opts)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(revokedTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}}
+	cnt, err := coll.CountDocuments(ctx, filter)
+
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return cnt > 0, nil
+}
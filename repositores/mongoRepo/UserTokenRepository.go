@@ -0,0 +1,73 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const userTokenDB = "user_token"
+
+func (m *MongoDB) SaveUserToken(ctx context.Context, token *models.UserToken) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := coll.InsertOne(ctx, token)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) UseUserToken(ctx context.Context, id string, purpose string) (*models.UserToken, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.UserToken
+	err := coll.FindOneAndDelete(ctx, bson.M{"_id": id, "purpose": purpose}).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	//the TTL monitor only runs every minute
+	if result.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) DeleteUserTokens(ctx context.Context, userID string, purpose string) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(userTokenDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := coll.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
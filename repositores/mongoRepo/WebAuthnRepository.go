@@ -0,0 +1,176 @@
+package mongoRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	webAuthnCredentialDB = "webauthn_credential"
+	webAuthnChallengeDB  = "webauthn_challenge"
+)
+
+func (m *MongoDB) AddWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(webAuthnCredentialDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := coll.InsertOne(ctx, credential)
+
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return repositores.ErrDuplicate
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) GetWebAuthnCredential(ctx context.Context, id string) (*models.WebAuthnCredential, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(webAuthnCredentialDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.WebAuthnCredential
+	err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *MongoDB) ListWebAuthnCredentials(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(webAuthnCredentialDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := coll.Find(ctx, bson.M{"user_id": userID}, opts)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	credentials := []models.WebAuthnCredential{}
+	err = cursor.All(ctx, &credentials)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func (m *MongoDB) UseWebAuthnCredential(ctx context.Context, id string, signCount int64, usedAt primitive.DateTime) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(webAuthnCredentialDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	//authenticators without a counter stay at zero
+	count := bson.M{"$lt": signCount}
+	if signCount == 0 {
+		count = bson.M{"$eq": 0}
+	}
+
+	filter := bson.M{"_id": id, "sign_count": count}
+	update := bson.M{"$set": bson.M{"sign_count": signCount, "last_used_at": usedAt}}
+	res, err := coll.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return repositores.ErrConflict
+	}
+
+	return nil
+}
+
+func (m *MongoDB) DeleteWebAuthnCredential(ctx context.Context, userID string, id string) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(webAuthnCredentialDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (m *MongoDB) SaveWebAuthnChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(webAuthnChallengeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := coll.InsertOne(ctx, challenge)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) UseWebAuthnChallenge(ctx context.Context, id string) (*models.WebAuthnChallenge, error) {
+	client := m.DBClint
+	coll := client.Database(m.DefualtDb).Collection(webAuthnChallengeDB)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var result models.WebAuthnChallenge
+	err := coll.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&result)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	//the TTL monitor only runs every minute
+	if result.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &result, nil
+}
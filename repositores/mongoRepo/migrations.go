@@ -0,0 +1,303 @@
+package mongoRepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const migrationDB = "schema_migrations"
+
+type migration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// a version is claimed as running and marked applied once it is done,
+// records written before the status existed are applied. A runner gives up
+// after migrateTimeout, so a claim older than migrationStale belongs to one
+// that was killed and is taken over
+const (
+	migrationRunning = "running"
+	migrationApplied = "applied"
+
+	migrateTimeout = time.Minute
+	migrationStale = 2 * migrateTimeout
+)
+
+type migrationRecord struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	Status    string    `bson:"status"`
+	StartedAt time.Time `bson:"started_at"`
+	AppliedAt time.Time `bson:"applied_at,omitempty"`
+}
+
+// migrations are applied in order and never edited once released, add a new
+// version instead. Creating an index that exists is a no-op, so a failed
+// migration can simply run again
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "user_login_id",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			//fails while duplicate login ids exist, they have to be merged by hand
+			loginID := mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "user_auth.login_id", Value: 1},
+					{Key: "user_auth.scope.user_domain", Value: 1},
+					{Key: "user_auth.scope.user_app_id", Value: 1},
+				},
+				Options: options.Index().SetUnique(true).SetName("login_id_scope"),
+			}
+
+			_, err := db.Collection(userDB).Indexes().CreateOne(ctx, loginID)
+			return err
+		},
+	},
+	{
+		version: 2,
+		name:    "token_ttl",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			ttl := mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}
+
+			indexes := []mongo.IndexModel{
+				ttl,
+				{Keys: bson.D{{Key: "family_id", Value: 1}}},
+				{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			}
+
+			_, err := db.Collection(refreshTokenDB).Indexes().CreateMany(ctx, indexes)
+
+			if err != nil {
+				return err
+			}
+
+			for _, coll := range []string{revokedTokenDB, authCodeDB, deviceCodeDB} {
+				_, err = db.Collection(coll).Indexes().CreateOne(ctx, ttl)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			userCode := mongo.IndexModel{
+				Keys:    bson.D{{Key: "user_code", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			}
+
+			_, err = db.Collection(deviceCodeDB).Indexes().CreateOne(ctx, userCode)
+			return err
+		},
+	},
+	{
+		version: 3,
+		name:    "user_token",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			indexes := []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				},
+				{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			}
+
+			_, err := db.Collection(userTokenDB).Indexes().CreateMany(ctx, indexes)
+			return err
+		},
+	},
+	{
+		version: 4,
+		name:    "mfa_challenge_ttl",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			ttl := mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}
+
+			_, err := db.Collection(mfaChallengeDB).Indexes().CreateOne(ctx, ttl)
+			return err
+		},
+	},
+	{
+		version: 5,
+		name:    "webauthn",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			userID := mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}}}
+
+			_, err := db.Collection(webAuthnCredentialDB).Indexes().CreateOne(ctx, userID)
+
+			if err != nil {
+				return err
+			}
+
+			ttl := mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}
+
+			_, err = db.Collection(webAuthnChallengeDB).Indexes().CreateOne(ctx, ttl)
+			return err
+		},
+	},
+	{
+		version: 6,
+		name:    "login_attempt_ttl",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			ttl := mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}
+
+			_, err := db.Collection(loginAttemptDB).Indexes().CreateOne(ctx, ttl)
+			return err
+		},
+	},
+	{
+		version: 7,
+		name:    "rate_limit_ttl",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			ttl := mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}
+
+			for _, coll := range []string{rateLimitBucketDB, rateLimitWindowDB} {
+				_, err := db.Collection(coll).Indexes().CreateOne(ctx, ttl)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+}
+
+// Migrate applies the migrations that are not recorded in schema_migrations.
+// A version another runner is applying is waited for, Migrate fails when it
+// is still running at the deadline
+func (m *MongoDB) Migrate() error {
+	client := m.DBClint
+	db := client.Database(m.DefualtDb)
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	for _, mig := range migrations {
+		err := applyMigration(ctx, db, mig)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *mongo.Database, mig migration) error {
+	coll := db.Collection(migrationDB)
+	var startedAt time.Time
+
+	for {
+		//claim the version first so a concurrent runner waits for it
+		startedAt = time.Now().UTC().Truncate(time.Millisecond)
+		record := migrationRecord{Version: mig.version, Name: mig.name, Status: migrationRunning, StartedAt: startedAt}
+		_, err := coll.InsertOne(ctx, record)
+
+		if err == nil {
+			break
+		}
+
+		if !mongo.IsDuplicateKeyError(err) {
+			log.Println(err)
+			return err
+		}
+
+		var claimed migrationRecord
+		err = coll.FindOne(ctx, bson.M{"_id": mig.version}).Decode(&claimed)
+
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			//the other runner failed and released it, claim it again
+			continue
+		case err != nil:
+			log.Println(err)
+			return err
+		case claimed.Status != migrationRunning:
+			return nil
+		case time.Since(claimed.StartedAt) > migrationStale:
+			//only one runner matches the old started_at
+			filter := bson.M{"_id": mig.version, "status": migrationRunning, "started_at": claimed.StartedAt}
+			res, err := coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"started_at": startedAt}})
+
+			if err != nil {
+				log.Println(err)
+				return err
+			}
+
+			if res.ModifiedCount == 1 {
+				log.Println("taking over stale migration", mig.version, mig.name)
+				return runMigration(ctx, db, mig, startedAt)
+			}
+
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("migration %d %s is still running: %w", mig.version, mig.name, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+
+	return runMigration(ctx, db, mig, startedAt)
+}
+
+// runMigration applies mig under the claim made at startedAt, the claim is
+// only released or completed while it is still this runner's
+func runMigration(ctx context.Context, db *mongo.Database, mig migration, startedAt time.Time) error {
+	coll := db.Collection(migrationDB)
+	claim := bson.M{"_id": mig.version, "status": migrationRunning, "started_at": startedAt}
+
+	err := mig.up(ctx, db)
+
+	if err != nil {
+		log.Println(err)
+
+		//release the claim so the next start retries
+		if _, delErr := coll.DeleteOne(ctx, claim); delErr != nil {
+			log.Println(delErr)
+		}
+
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"status": migrationApplied, "applied_at": time.Now()}}
+	res, err := coll.UpdateOne(ctx, claim, update)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	//another runner took the claim over, it records the version
+	if res.MatchedCount == 0 {
+		log.Println("migration", mig.version, mig.name, "was taken over")
+		return nil
+	}
+
+	log.Println("applied migration", mig.version, mig.name)
+
+	return nil
+}
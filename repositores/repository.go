@@ -2,14 +2,197 @@ package repositores
 
 import (
 	"auth/models"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// sentinel errors of every implementation, handlers map them to status codes
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("duplicate")
+	ErrConflict  = errors.New("conflict")
+
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserDisabled       = errors.New("user is disabled")
+)
+
+// NextVersion is the UpdatedAt of a write, always after the previous one even
+// within the same millisecond
+func NextVersion(prev primitive.DateTime) primitive.DateTime {
+	next := primitive.NewDateTimeFromTime(time.Now())
+
+	if next <= prev {
+		next = prev + 1
+	}
+
+	return next
+}
+
+// PasswordHasher hashes user passwords, Verify also reports whether a
+// matching hash should be upgraded to the current settings
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash string, password string) (bool, bool, error)
+}
+
+// VerifyPassword returns ErrInvalidCredentials for a wrong password and
+// whether the hash needs an upgrade
+func VerifyPassword(h PasswordHasher, hash string, password string) (bool, error) {
+	match, rehash, err := h.Verify(hash, password)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !match {
+		return false, ErrInvalidCredentials
+	}
+
+	return rehash, nil
+}
+
+// Operations names the secret operations of the jwt register handlers
+type Operations struct {
+	Create string
+	Read   string
+	Update string
+	Delete string
+}
+
+// DatabaseRepo stores users and clients. Lookups return ErrNotFound, unique
+// keys ErrDuplicate and ValidUserByLonginUser ErrInvalidCredentials for a
+// wrong password or ErrUserDisabled. Users are returned without password
+// hash and secrets, ListUsers orders them by id. UpdatePassword takes the
+// plain text and stores the hash. UpdateUserProfile only writes when
+// UpdatedAt still equals version and returns the new UpdatedAt, ErrConflict
+// otherwise. UseTOTPStep moves the last used TOTP step forward or returns
+// ErrConflict for a replay, UseRecoveryCode removes the code or returns
+// ErrNotFound. SetEmailVerified marks email verified and returns ErrConflict
+// when the user has changed it since. ReplaceJwtSecret sets the value of key
+// only while it still is old
 type DatabaseRepo interface {
-	ConnectDB() interface{}
-	CreateUser(usr *models.User) (interface{}, error)
-	ValidUserByLonginUser(userAuth *models.UserAuth) (*models.User, string, error)
-	IsUserLoninIdUnique(userAuth *models.UserAuth) (bool, error)
-	GetUserByID(id interface{}, params ...interface{}) (interface{}, error)
-	UpdateThirdPartySecretsByID(objID interface{}, secrets []models.ThirdPartySecret, operation string) (interface{}, error)
-	GetJwtSecret(objID string, key string) (string, error)
+	CreateUser(ctx context.Context, usr *models.User) error
+	ValidUserByLonginUser(ctx context.Context, userAuth *models.UserAuth) (*models.User, error)
+	IsUserLoninIdUnique(ctx context.Context, userAuth *models.UserAuth) (bool, error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	UpdateUserProfile(ctx context.Context, userID string, profile models.UserPorfile, version primitive.DateTime) (primitive.DateTime, error)
+	ListUsers(ctx context.Context, filter *models.UserFilter) ([]models.User, error)
+	SetUserDisabled(ctx context.Context, userID string, disabled bool) error
+	SetUserRole(ctx context.Context, userID string, role models.UserRole) error
+	DeleteUser(ctx context.Context, userID string) error
+	GetUserByLoginID(ctx context.Context, userAuth *models.UserAuth) (*models.User, error)
+	UpdatePassword(ctx context.Context, userID string, password string) error
+	SetMFA(ctx context.Context, userID string, mfa *models.MFA) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID string, codeHash string) error
+	SetEmailVerified(ctx context.Context, userID string, email string, verifiedAt primitive.DateTime) error
+	AddThirdPartySecret(ctx context.Context, userID string, secret models.ThirdPartySecret) error
+	UpdateThirdPartySecret(ctx context.Context, userID string, secret models.ThirdPartySecret) error
+	GetJwtSecret(ctx context.Context, userID string, key string) (string, error)
+	ReplaceJwtSecret(ctx context.Context, userID string, key string, old string, value string) error
+	CreateClient(ctx context.Context, client *models.Client) error
+	GetClientByID(ctx context.Context, clientID string) (*models.Client, error)
+	ValidClientSecret(ctx context.Context, clientID string, secret string) (*models.Client, error)
+	ListClients(ctx context.Context) ([]models.Client, error)
+	UpdateClientSecret(ctx context.Context, clientID string, secret string) error
+	DeleteClient(ctx context.Context, clientID string) error
+}
+
+// AcquireLease takes the lease name for holder until now+ttl when it is free,
+// expired or already held by holder, false means another holder has it
+type KeyRepo interface {
+	SaveSigningKey(ctx context.Context, key *models.SigningKey) error
+	ListSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	AcquireLease(ctx context.Context, name string, holder string, now primitive.DateTime, ttl time.Duration) (bool, error)
+}
+
+// UseRefreshToken marks a token used exactly once, a second call returns the
+// token with ErrConflict
+type RefreshTokenStore interface {
+	SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error)
+	UseRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error)
+	RevokeRefreshFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string) ([]string, error)
+}
+
+// RevocationStore is the denylist of access token jti and session ids
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, id string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// OAuthStore keeps short lived grants, GetAuthCode reads a code without using
+// it, UseAuthCode works once and returns the code with ErrConflict
+// afterwards. PollDeviceCode records a poll and returns the code as it was
+// before, UseDeviceCode moves an approved code to used. SaveDeviceCode
+// returns ErrDuplicate for a user code in use
+type OAuthStore interface {
+	SaveAuthCode(ctx context.Context, code *models.AuthCode) error
+	GetAuthCode(ctx context.Context, id string) (*models.AuthCode, error)
+	UseAuthCode(ctx context.Context, id string) (*models.AuthCode, error)
+	SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error
+	GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*models.DeviceCode, error)
+	PollDeviceCode(ctx context.Context, id string, polledAt time.Time) (*models.DeviceCode, error)
+	UseDeviceCode(ctx context.Context, id string) (*models.DeviceCode, error)
+}
+
+// UserTokenStore keeps the single use tokens sent to users by their hash.
+// UseUserToken deletes the token, unknown, expired and tokens of another
+// purpose are ErrNotFound
+type UserTokenStore interface {
+	SaveUserToken(ctx context.Context, token *models.UserToken) error
+	UseUserToken(ctx context.Context, id string, purpose string) (*models.UserToken, error)
+	DeleteUserTokens(ctx context.Context, userID string, purpose string) error
+}
+
+// MFAChallengeStore keeps logins waiting for the second factor.
+// AttemptMFAChallenge counts a try and returns ErrNotFound once the challenge
+// is expired or out of attempts, DeleteMFAChallenge returns ErrNotFound when
+// another request finished it first
+type MFAChallengeStore interface {
+	SaveMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) error
+	AttemptMFAChallenge(ctx context.Context, id string, maxAttempts int) (*models.MFAChallenge, error)
+	DeleteMFAChallenge(ctx context.Context, id string) error
+}
+
+// WebAuthnStore keeps the passkeys of users and the challenges of running
+// ceremonies. AddWebAuthnCredential returns ErrDuplicate for a known
+// credential id. UseWebAuthnCredential stores a sign count only when it is
+// above the stored one, or both are zero, and returns ErrConflict otherwise.
+// UseWebAuthnChallenge deletes the challenge, unknown and expired ones are
+// ErrNotFound
+type WebAuthnStore interface {
+	AddWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	GetWebAuthnCredential(ctx context.Context, id string) (*models.WebAuthnCredential, error)
+	ListWebAuthnCredentials(ctx context.Context, userID string) ([]models.WebAuthnCredential, error)
+	UseWebAuthnCredential(ctx context.Context, id string, signCount int64, usedAt primitive.DateTime) error
+	DeleteWebAuthnCredential(ctx context.Context, userID string, id string) error
+	SaveWebAuthnChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error
+	UseWebAuthnChallenge(ctx context.Context, id string) (*models.WebAuthnChallenge, error)
+}
+
+// LoginAttemptStore counts failed logins by key for every replica.
+// AddLoginFailure starts a new count when the last failure is older than
+// window and returns the updated record, GetLoginAttempts returns ErrNotFound
+// for keys without recent failures. RemoveLoginFailure takes the last failure
+// back, LastFailure goes back to PreviousFailure
+type LoginAttemptStore interface {
+	AddLoginFailure(ctx context.Context, key string, now primitive.DateTime, window time.Duration) (*models.LoginAttempts, error)
+	RemoveLoginFailure(ctx context.Context, key string) error
+	GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// RateLimitStore keeps request rates by key for every replica.
+// TakeRateLimitToken refills the bucket of key at rate tokens per second up
+// to burst, a new bucket starts full, and takes a token when one is left.
+// AddRateLimitHit counts a request in the window of key that starts at start
+// and moves the count to PreviousHits when the window before has ended
+type RateLimitStore interface {
+	TakeRateLimitToken(ctx context.Context, key string, now primitive.DateTime, rate float64, burst int) (*models.RateLimitBucket, error)
+	AddRateLimitHit(ctx context.Context, key string, start primitive.DateTime, window time.Duration) (*models.RateLimitWindow, error)
 }
@@ -0,0 +1,681 @@
+package repositores_test
+
+import (
+	"auth/models"
+	"auth/password"
+	"auth/repositores"
+	"auth/repositores/memoryRepo"
+	"auth/repositores/sqlRepo"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// store is every repository one backend implements
+type store interface {
+	repositores.DatabaseRepo
+	repositores.KeyRepo
+	repositores.RefreshTokenStore
+	repositores.RevocationStore
+	repositores.OAuthStore
+	repositores.UserTokenStore
+	repositores.MFAChallengeStore
+	repositores.WebAuthnStore
+	repositores.LoginAttemptStore
+	repositores.RateLimitStore
+}
+
+func testHasher() *password.Hasher {
+	hasher := password.NewHasher()
+	hasher.Algorithm = password.Bcrypt
+	hasher.BcryptCost = bcrypt.MinCost
+
+	return hasher
+}
+
+// backends are the stores the contract runs against, mongo needs a server
+// and is left out
+var backends = map[string]func(t *testing.T) store{
+	"memory": func(t *testing.T) store {
+		db := memoryRepo.NewMemoryDB()
+		db.Hasher = testHasher()
+
+		return db
+	},
+	"sqlite": func(t *testing.T) store {
+		db := &sqlRepo.SQLDB{
+			Driver: sqlRepo.SQLite,
+			DSN:    filepath.Join(t.TempDir(), "auth.db"),
+			Hasher: testHasher(),
+		}
+		db.DBClint = db.ConnectDB()
+		t.Cleanup(func() { db.DBClint.Close() })
+
+		err := db.Migrate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return db
+	},
+}
+
+// contract runs test against a new store of every backend
+func contract(t *testing.T, test func(t *testing.T, s store)) {
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStore(t))
+		})
+	}
+}
+
+func expectErr(t *testing.T, err error, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Fatalf("err %v, want %v", err, want)
+	}
+}
+
+func newUser(loginID string) *models.User {
+	return &models.User{
+		UserAuth: models.UserAuth{
+			LoginID:  loginID,
+			Password: "secret-password",
+			Scope: models.UserScope{
+				Domain: "d",
+				AppID:  "a",
+				Role:   models.UserRole{RoleNmae: "user"},
+			},
+		},
+		ThirdPartySecrets: []models.ThirdPartySecret{},
+	}
+}
+
+func createUser(t *testing.T, s store, loginID string) *models.User {
+	t.Helper()
+
+	usr := newUser(loginID)
+
+	err := s.CreateUser(context.Background(), usr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return usr
+}
+
+func TestUsers(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		created := createUser(t, s, "alice")
+
+		expectErr(t, s.CreateUser(ctx, newUser("alice")), repositores.ErrDuplicate)
+
+		login := newUser("alice").UserAuth
+
+		usr, err := s.ValidUserByLonginUser(ctx, &login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if usr.ID != created.ID || usr.UserAuth.Password != "" {
+			t.Fatalf("login returned %+v", usr.UserAuth)
+		}
+
+		if usr.CreatedAt == 0 || usr.UpdatedAt != created.UpdatedAt {
+			t.Fatalf("login returned created_at %d updated_at %d, want %d", usr.CreatedAt, usr.UpdatedAt, created.UpdatedAt)
+		}
+
+		login.Password = "wrong"
+		_, err = s.ValidUserByLonginUser(ctx, &login)
+		expectErr(t, err, repositores.ErrInvalidCredentials)
+
+		login.LoginID = "nobody"
+		_, err = s.ValidUserByLonginUser(ctx, &login)
+		expectErr(t, err, repositores.ErrNotFound)
+
+		//login ids are unique per tenant only
+		other := newUser("alice")
+		other.UserAuth.Scope.AppID = "b"
+
+		if err := s.CreateUser(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.GetUserByID(ctx, primitive.NewObjectID().Hex())
+		expectErr(t, err, repositores.ErrNotFound)
+
+		err = s.SetUserDisabled(ctx, created.ID.Hex(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		login = newUser("alice").UserAuth
+		_, err = s.ValidUserByLonginUser(ctx, &login)
+		expectErr(t, err, repositores.ErrUserDisabled)
+	})
+}
+
+func TestUpdateUserProfileVersion(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		usr := createUser(t, s, "alice")
+		id := usr.ID.Hex()
+
+		profile := usr.Profile
+		profile.FisrtName = "Ada"
+
+		version, err := s.UpdateUserProfile(ctx, id, profile, usr.UpdatedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if version <= usr.UpdatedAt {
+			t.Fatalf("version %d, want after %d", version, usr.UpdatedAt)
+		}
+
+		//a writer that read the old version loses
+		profile.FisrtName = "Grace"
+		_, err = s.UpdateUserProfile(ctx, id, profile, usr.UpdatedAt)
+		expectErr(t, err, repositores.ErrConflict)
+
+		stored, err := s.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stored.Profile.FisrtName != "Ada" || stored.UpdatedAt != version {
+			t.Fatalf("stored %q at %d, want Ada at %d", stored.Profile.FisrtName, stored.UpdatedAt, version)
+		}
+
+		//versions move forward within the same millisecond
+		next, err := s.UpdateUserProfile(ctx, id, profile, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if next <= version {
+			t.Fatalf("version %d, want after %d", next, version)
+		}
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		usr := createUser(t, s, "alice")
+		id := usr.ID.Hex()
+
+		profile := usr.Profile
+		profile.Email = "alice@example.com"
+
+		version, err := s.UpdateUserProfile(ctx, id, profile, usr.UpdatedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		now := primitive.NewDateTimeFromTime(time.Now())
+
+		err = s.SetEmailVerified(ctx, id, "old@example.com", now)
+		expectErr(t, err, repositores.ErrConflict)
+
+		err = s.SetEmailVerified(ctx, id, "alice@example.com", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := s.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !stored.Profile.EmailVerified || stored.UpdatedAt <= version {
+			t.Fatalf("verified %v at version %d, want a version after %d", stored.Profile.EmailVerified, stored.UpdatedAt, version)
+		}
+	})
+}
+
+func TestMFA(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		id := createUser(t, s, "alice").ID.Hex()
+
+		err := s.SetMFA(ctx, id, &models.MFA{Enabled: true, Secret: "secret", LastStep: 10, RecoveryCodes: []string{"one", "two"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectErr(t, s.UseTOTPStep(ctx, id, 10), repositores.ErrConflict)
+		expectErr(t, s.UseTOTPStep(ctx, id, 9), repositores.ErrConflict)
+
+		if err := s.UseTOTPStep(ctx, id, 11); err != nil {
+			t.Fatal(err)
+		}
+
+		expectErr(t, s.UseTOTPStep(ctx, id, 11), repositores.ErrConflict)
+
+		if err := s.UseRecoveryCode(ctx, id, "one"); err != nil {
+			t.Fatal(err)
+		}
+
+		expectErr(t, s.UseRecoveryCode(ctx, id, "one"), repositores.ErrNotFound)
+		expectErr(t, s.UseRecoveryCode(ctx, id, "three"), repositores.ErrNotFound)
+
+		if err := s.UseRecoveryCode(ctx, id, "two"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestJwtSecrets(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		id := createUser(t, s, "alice").ID.Hex()
+
+		err := s.AddThirdPartySecret(ctx, id, models.ThirdPartySecret{KeyName: "app", KeyValue: "v1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//a value someone else replaced first is left alone
+		if err := s.ReplaceJwtSecret(ctx, id, "app", "v0", "v2"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.ReplaceJwtSecret(ctx, id, "app", "v1", "v3"); err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := s.GetJwtSecret(ctx, id, "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if secret != "v3" {
+			t.Fatalf("secret %q, want v3", secret)
+		}
+
+		_, err = s.GetJwtSecret(ctx, id, "other")
+		expectErr(t, err, repositores.ErrNotFound)
+	})
+}
+
+func TestRefreshTokens(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		now := time.Now()
+
+		for _, id := range []string{"t1", "t2"} {
+			err := s.SaveRefreshToken(ctx, &models.RefreshToken{
+				ID:        id,
+				FamilyID:  "family",
+				UserID:    "user",
+				CreatedAt: primitive.NewDateTimeFromTime(now),
+				ExpiresAt: primitive.NewDateTimeFromTime(now.Add(time.Hour)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		used, err := s.UseRefreshToken(ctx, "t1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if used.FamilyID != "family" {
+			t.Fatalf("used %+v", used)
+		}
+
+		//the second use is a stolen copy, the caller needs the family
+		used, err = s.UseRefreshToken(ctx, "t1")
+		expectErr(t, err, repositores.ErrConflict)
+
+		if used == nil || used.FamilyID != "family" {
+			t.Fatalf("reuse returned %+v, want the token", used)
+		}
+
+		_, err = s.UseRefreshToken(ctx, "unknown")
+		expectErr(t, err, repositores.ErrNotFound)
+
+		if err := s.RevokeRefreshFamily(ctx, "family"); err != nil {
+			t.Fatal(err)
+		}
+
+		entry, err := s.GetRefreshToken(ctx, "t2")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !entry.Revoked {
+			t.Fatal("token of a revoked family is not revoked")
+		}
+
+		//only families with live tokens are returned
+		err = s.SaveRefreshToken(ctx, &models.RefreshToken{
+			ID:        "t3",
+			FamilyID:  "other",
+			UserID:    "user",
+			CreatedAt: primitive.NewDateTimeFromTime(now),
+			ExpiresAt: primitive.NewDateTimeFromTime(now.Add(time.Hour)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		families, err := s.RevokeUserRefreshTokens(ctx, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(families) != 1 || families[0] != "other" {
+			t.Fatalf("families %v, want other", families)
+		}
+	})
+}
+
+func TestRevocations(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+
+		if err := s.RevokeToken(ctx, "jti", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		for id, want := range map[string]bool{"jti": true, "other": false} {
+			revoked, err := s.IsTokenRevoked(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if revoked != want {
+				t.Fatalf("%s revoked %v, want %v", id, revoked, want)
+			}
+		}
+	})
+}
+
+func TestAuthCodes(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+
+		err := s.SaveAuthCode(ctx, &models.AuthCode{
+			ID:            "code",
+			ClientID:      "client",
+			UserID:        "user",
+			RedirectURI:   "https://app.test/callback",
+			Scope:         []string{"openid"},
+			CodeChallenge: "challenge",
+			ExpiresAt:     primitive.NewDateTimeFromTime(time.Now().Add(time.Minute)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//reading does not spend it
+		for i := 0; i < 2; i++ {
+			code, err := s.GetAuthCode(ctx, "code")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if code.CodeChallenge != "challenge" || code.Used {
+				t.Fatalf("code %+v", code)
+			}
+		}
+
+		if _, err := s.UseAuthCode(ctx, "code"); err != nil {
+			t.Fatal(err)
+		}
+
+		code, err := s.UseAuthCode(ctx, "code")
+		expectErr(t, err, repositores.ErrConflict)
+
+		if code == nil || code.ClientID != "client" {
+			t.Fatalf("replay returned %+v, want the code", code)
+		}
+
+		_, err = s.UseAuthCode(ctx, "unknown")
+		expectErr(t, err, repositores.ErrNotFound)
+
+		err = s.SaveAuthCode(ctx, &models.AuthCode{ID: "expired", ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(-time.Minute))})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.GetAuthCode(ctx, "expired")
+		expectErr(t, err, repositores.ErrNotFound)
+	})
+}
+
+func TestUserTokens(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		id := createUser(t, s, "alice").ID.Hex()
+
+		err := s.SaveUserToken(ctx, &models.UserToken{
+			ID:        "token",
+			Purpose:   models.TokenPasswordReset,
+			UserID:    id,
+			ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(time.Hour)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.UseUserToken(ctx, "token", models.TokenMagicLink)
+		expectErr(t, err, repositores.ErrNotFound)
+
+		token, err := s.UseUserToken(ctx, "token", models.TokenPasswordReset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token.UserID != id {
+			t.Fatalf("token %+v", token)
+		}
+
+		_, err = s.UseUserToken(ctx, "token", models.TokenPasswordReset)
+		expectErr(t, err, repositores.ErrNotFound)
+	})
+}
+
+func TestMFAChallenges(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		id := createUser(t, s, "alice").ID.Hex()
+
+		err := s.SaveMFAChallenge(ctx, &models.MFAChallenge{
+			ID:        "challenge",
+			UserID:    id,
+			Scope:     []string{},
+			ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(time.Minute)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 1; i <= 2; i++ {
+			challenge, err := s.AttemptMFAChallenge(ctx, "challenge", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if challenge.Attempts != i {
+				t.Fatalf("attempts %d, want %d", challenge.Attempts, i)
+			}
+		}
+
+		_, err = s.AttemptMFAChallenge(ctx, "challenge", 2)
+		expectErr(t, err, repositores.ErrNotFound)
+
+		if err := s.DeleteMFAChallenge(ctx, "challenge"); err != nil {
+			t.Fatal(err)
+		}
+
+		expectErr(t, s.DeleteMFAChallenge(ctx, "challenge"), repositores.ErrNotFound)
+	})
+}
+
+func TestSigningKeysAndLeases(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		now := time.Now()
+
+		key := &models.SigningKey{
+			Kid:        "kid",
+			Alg:        "ES256",
+			State:      models.KeyActive,
+			PrivateKey: "encrypted",
+			CreatedAt:  primitive.NewDateTimeFromTime(now),
+			ActivateAt: primitive.NewDateTimeFromTime(now),
+		}
+
+		if err := s.SaveSigningKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+
+		key.State = models.KeyRetiring
+
+		if err := s.SaveSigningKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := s.ListSigningKeys(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != 1 || keys[0].State != models.KeyRetiring || keys[0].PrivateKey != "encrypted" {
+			t.Fatalf("keys %+v", keys)
+		}
+
+		at := primitive.NewDateTimeFromTime(now)
+
+		for _, step := range []struct {
+			holder string
+			at     primitive.DateTime
+			want   bool
+		}{
+			{"a", at, true},
+			{"b", at, false},
+			{"a", at, true},
+			{"b", primitive.NewDateTimeFromTime(now.Add(2 * time.Minute)), true},
+			{"a", primitive.NewDateTimeFromTime(now.Add(2 * time.Minute)), false},
+		} {
+			ok, err := s.AcquireLease(ctx, "keys", step.holder, step.at, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != step.want {
+				t.Fatalf("%s acquired %v at %s, want %v", step.holder, ok, step.at.Time(), step.want)
+			}
+		}
+	})
+}
+
+func TestLoginAttempts(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		now := time.Now()
+
+		for i := 1; i <= 3; i++ {
+			attempts, err := s.AddLoginFailure(ctx, "account", primitive.NewDateTimeFromTime(now.Add(time.Duration(i)*time.Second)), time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if attempts.Failures != i {
+				t.Fatalf("failures %d, want %d", attempts.Failures, i)
+			}
+		}
+
+		if err := s.RemoveLoginFailure(ctx, "account"); err != nil {
+			t.Fatal(err)
+		}
+
+		attempts, err := s.GetLoginAttempts(ctx, "account")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if attempts.Failures != 2 || attempts.LastFailure != primitive.NewDateTimeFromTime(now.Add(2*time.Second)) {
+			t.Fatalf("attempts %+v after taking one back", attempts)
+		}
+
+		//a failure after the window starts over
+		attempts, err = s.AddLoginFailure(ctx, "account", primitive.NewDateTimeFromTime(now.Add(time.Hour)), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if attempts.Failures != 1 {
+			t.Fatalf("failures %d after the window, want 1", attempts.Failures)
+		}
+
+		if err := s.ResetLoginAttempts(ctx, "account"); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.GetLoginAttempts(ctx, "account")
+		expectErr(t, err, repositores.ErrNotFound)
+	})
+}
+
+func TestRateLimits(t *testing.T) {
+	contract(t, func(t *testing.T, s store) {
+		ctx := context.Background()
+		now := time.Now()
+		at := primitive.NewDateTimeFromTime(now)
+
+		//a full bucket of 2 refilled at 1 token a second
+		for _, want := range []bool{true, true, false} {
+			bucket, err := s.TakeRateLimitToken(ctx, "bucket", at, 1, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bucket.Allowed != want {
+				t.Fatalf("allowed %v, want %v", bucket.Allowed, want)
+			}
+		}
+
+		bucket, err := s.TakeRateLimitToken(ctx, "bucket", primitive.NewDateTimeFromTime(now.Add(time.Second)), 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bucket.Allowed {
+			t.Fatal("bucket did not refill")
+		}
+
+		start := primitive.NewDateTimeFromTime(now.Truncate(time.Minute))
+
+		for i := int64(1); i <= 3; i++ {
+			counter, err := s.AddRateLimitHit(ctx, "window", start, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if counter.Hits != i {
+				t.Fatalf("hits %d, want %d", counter.Hits, i)
+			}
+		}
+
+		counter, err := s.AddRateLimitHit(ctx, "window", primitive.NewDateTimeFromTime(start.Time().Add(time.Minute)), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if counter.Hits != 1 || counter.PreviousHits != 3 {
+			t.Fatalf("next window %+v, want 1 hit after 3", counter)
+		}
+	})
+}
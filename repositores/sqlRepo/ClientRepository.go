@@ -0,0 +1,214 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const clientColumns = `id, name, secret_hash, public, grant_types, scopes, redirect_uris, token_exchange,
+	domain, app_id, created_at, updated_at FROM clients`
+
+func (s *SQLDB) CreateClient(ctx context.Context, cl *models.Client) error {
+	if cl.Secret != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(cl.Secret), bcrypt.DefaultCost)
+
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		cl.SecretHash = string(hash)
+	}
+
+	cl.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	cl.UpdatedAt = cl.CreatedAt
+
+	//lists are small and only read with the client, keep them as json
+	grants, _ := json.Marshal(cl.GrantTypes)
+	scopes, _ := json.Marshal(cl.Scopes)
+	uris, _ := json.Marshal(cl.RedirectURIs)
+	exchange := ""
+
+	if cl.Exchange != nil {
+		policy, _ := json.Marshal(cl.Exchange)
+		exchange = string(policy)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `INSERT INTO clients (id, name, secret_hash, public, grant_types, scopes, redirect_uris, token_exchange,
+		domain, app_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cl.ClientID, cl.Name, cl.SecretHash, cl.Public, string(grants), string(scopes), string(uris), exchange,
+		cl.Domain, cl.AppID, int64(cl.CreatedAt), int64(cl.UpdatedAt))
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repositores.ErrDuplicate
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) GetClientByID(ctx context.Context, clientID string) (*models.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := scanClient(s.queryRow(ctx, "SELECT "+clientColumns+" WHERE id = ?", clientID))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *SQLDB) ValidClientSecret(ctx context.Context, clientID string, secret string) (*models.Client, error) {
+	result, err := s.GetClientByID(ctx, clientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result.SecretHash == "" {
+		return nil, repositores.ErrInvalidCredentials
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(result.SecretHash), []byte(secret))
+
+	if err != nil {
+		return nil, repositores.ErrInvalidCredentials
+	}
+
+	return result, nil
+}
+
+func (s *SQLDB) ListClients(ctx context.Context) ([]models.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, "SELECT "+clientColumns+" ORDER BY created_at")
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []models.Client{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		clients = append(clients, *client)
+	}
+
+	return clients, rows.Err()
+}
+
+func (s *SQLDB) UpdateClientSecret(ctx context.Context, clientID string, secret string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "UPDATE clients SET secret_hash = ?, updated_at = ? WHERE id = ?",
+		string(hash), time.Now().UnixMilli(), clientID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLDB) DeleteClient(ctx context.Context, clientID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "DELETE FROM clients WHERE id = ?", clientID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClient(row scanner) (*models.Client, error) {
+	var cl models.Client
+	var grants, scopes, uris, exchange string
+	var createdAt, updatedAt int64
+
+	err := row.Scan(&cl.ClientID, &cl.Name, &cl.SecretHash, &cl.Public, &grants, &scopes, &uris, &exchange,
+		&cl.Domain, &cl.AppID, &createdAt, &updatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lists := []struct {
+		col  string
+		dest *[]string
+	}{{grants, &cl.GrantTypes}, {scopes, &cl.Scopes}, {uris, &cl.RedirectURIs}}
+
+	for _, list := range lists {
+		if err := json.Unmarshal([]byte(list.col), list.dest); err != nil {
+			return nil, err
+		}
+	}
+
+	if exchange != "" {
+		cl.Exchange = &models.ExchangePolicy{}
+		if err := json.Unmarshal([]byte(exchange), cl.Exchange); err != nil {
+			return nil, err
+		}
+	}
+
+	cl.CreatedAt = primitive.DateTime(createdAt)
+	cl.UpdatedAt = primitive.DateTime(updatedAt)
+
+	return &cl, nil
+}
@@ -0,0 +1,87 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *SQLDB) SaveSigningKey(ctx context.Context, key *models.SigningKey) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `INSERT INTO signing_keys (kid, alg, state, private_key, created_at, activate_at, retire_at, expire_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kid) DO UPDATE SET state = excluded.state, activate_at = excluded.activate_at, retire_at = excluded.retire_at, expire_at = excluded.expire_at`,
+		key.Kid, key.Alg, key.State, key.PrivateKey, int64(key.CreatedAt), int64(key.ActivateAt), int64(key.RetireAt), int64(key.ExpireAt))
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, "SELECT kid, alg, state, private_key, created_at, activate_at, retire_at, expire_at FROM signing_keys")
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.SigningKey{}
+	for rows.Next() {
+		var key models.SigningKey
+		var createdAt, activateAt, retireAt, expireAt int64
+
+		err = rows.Scan(&key.Kid, &key.Alg, &key.State, &key.PrivateKey, &createdAt, &activateAt, &retireAt, &expireAt)
+
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		key.CreatedAt = primitive.DateTime(createdAt)
+		key.ActivateAt = primitive.DateTime(activateAt)
+		key.RetireAt = primitive.DateTime(retireAt)
+		key.ExpireAt = primitive.DateTime(expireAt)
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *SQLDB) AcquireLease(ctx context.Context, name string, holder string, now primitive.DateTime, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	expiresAt := now.Time().Add(ttl).UnixMilli()
+
+	//the update only happens for the holder or after expiry, else no row changes
+	res, err := s.exec(ctx, nil, `INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at <= ?`,
+		name, holder, expiresAt, int64(now))
+
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,102 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *SQLDB) AddLoginFailure(ctx context.Context, key string, now primitive.DateTime, window time.Duration) (*models.LoginAttempts, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cutoff := int64(now) - window.Milliseconds()
+	expiresAt := int64(now) + window.Milliseconds()
+
+	//one statement so concurrent failures on other replicas are all counted
+	attempts := models.LoginAttempts{ID: key}
+	var firstFailure, lastFailure, previousFailure, expires int64
+	err := s.queryRow(ctx, `INSERT INTO login_attempts (id, failures, first_failure, last_failure, previous_failure, expires_at)
+		VALUES (?, 1, ?, ?, 0, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			first_failure = CASE WHEN login_attempts.last_failure < ? THEN excluded.first_failure ELSE login_attempts.first_failure END,
+			previous_failure = CASE WHEN login_attempts.last_failure < ? THEN 0 ELSE login_attempts.last_failure END,
+			last_failure = excluded.last_failure,
+			expires_at = excluded.expires_at
+		RETURNING failures, first_failure, last_failure, previous_failure, expires_at`,
+		key, int64(now), int64(now), expiresAt, cutoff, cutoff, cutoff).
+		Scan(&attempts.Failures, &firstFailure, &lastFailure, &previousFailure, &expires)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	attempts.FirstFailure = primitive.DateTime(firstFailure)
+	attempts.LastFailure = primitive.DateTime(lastFailure)
+	attempts.PreviousFailure = primitive.DateTime(previousFailure)
+	attempts.ExpiresAt = primitive.DateTime(expires)
+
+	return &attempts, nil
+}
+
+func (s *SQLDB) GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	attempts := models.LoginAttempts{ID: key}
+	var firstFailure, lastFailure, expires int64
+	err := s.queryRow(ctx, "SELECT failures, first_failure, last_failure, expires_at FROM login_attempts WHERE id = ? AND expires_at > ?",
+		key, time.Now().UnixMilli()).
+		Scan(&attempts.Failures, &firstFailure, &lastFailure, &expires)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	attempts.FirstFailure = primitive.DateTime(firstFailure)
+	attempts.LastFailure = primitive.DateTime(lastFailure)
+	attempts.ExpiresAt = primitive.DateTime(expires)
+
+	return &attempts, nil
+}
+
+func (s *SQLDB) RemoveLoginFailure(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, "UPDATE login_attempts SET failures = failures - 1, last_failure = previous_failure WHERE id = ? AND failures > 0", key)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) ResetLoginAttempts(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, "DELETE FROM login_attempts WHERE id = ?", key)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
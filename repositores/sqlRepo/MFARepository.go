@@ -0,0 +1,75 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *SQLDB) SaveMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `INSERT INTO mfa_challenges (id, user_id, client_id, key_name, scope, nonce, enroll, attempts, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		challenge.ID, challenge.UserID, challenge.ClientID, challenge.KeyName, strings.Join(challenge.Scope, " "),
+		challenge.Nonce, challenge.Enroll, challenge.Attempts, int64(challenge.ExpiresAt))
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) AttemptMFAChallenge(ctx context.Context, id string, maxAttempts int) (*models.MFAChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	challenge := models.MFAChallenge{ID: id}
+	var scope string
+	var expiresAt int64
+	err := s.queryRow(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = ? AND attempts < ? AND expires_at > ?
+		RETURNING user_id, client_id, key_name, scope, nonce, enroll, attempts, expires_at`, id, maxAttempts, time.Now().UnixMilli()).
+		Scan(&challenge.UserID, &challenge.ClientID, &challenge.KeyName, &scope, &challenge.Nonce, &challenge.Enroll, &challenge.Attempts, &expiresAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	challenge.Scope = strings.Fields(scope)
+	challenge.ExpiresAt = primitive.DateTime(expiresAt)
+
+	return &challenge, nil
+}
+
+func (s *SQLDB) DeleteMFAChallenge(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "DELETE FROM mfa_challenges WHERE id = ?", id)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
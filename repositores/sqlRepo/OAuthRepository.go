@@ -0,0 +1,241 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	authCodeColumns = `id, client_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method,
+	auth_time, used, family_id, expires_at FROM auth_codes`
+	deviceCodeColumns = `id, user_code, client_id, scope, status, user_id, poll_interval, last_polled_at,
+	auth_time, expires_at FROM device_codes`
+)
+
+func (s *SQLDB) SaveAuthCode(ctx context.Context, code *models.AuthCode) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `INSERT INTO auth_codes (id, client_id, user_id, redirect_uri, scope, nonce, code_challenge,
+		code_challenge_method, auth_time, used, family_id, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET used = excluded.used, family_id = excluded.family_id`,
+		code.ID, code.ClientID, code.UserID, code.RedirectURI, strings.Join(code.Scope, " "), code.Nonce, code.CodeChallenge,
+		code.CodeChallengeMethod, int64(code.AuthTime), code.Used, code.FamilyID, int64(code.ExpiresAt))
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) GetAuthCode(ctx context.Context, id string) (*models.AuthCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	code, err := scanAuthCode(s.queryRow(ctx, "SELECT "+authCodeColumns+" WHERE id = ?", id))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	if code.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return code, nil
+}
+
+func (s *SQLDB) UseAuthCode(ctx context.Context, id string) (*models.AuthCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "UPDATE auth_codes SET used = TRUE WHERE id = ? AND used = FALSE", id)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	code, err := scanAuthCode(s.queryRow(ctx, "SELECT "+authCodeColumns+" WHERE id = ?", id))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	if code.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	if rowsAffected(res) == 0 {
+		return code, repositores.ErrConflict
+	}
+
+	return code, nil
+}
+
+func (s *SQLDB) SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `INSERT INTO device_codes (id, user_code, client_id, scope, status, user_id, poll_interval,
+		last_polled_at, auth_time, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, user_id = excluded.user_id,
+			poll_interval = excluded.poll_interval, last_polled_at = excluded.last_polled_at, auth_time = excluded.auth_time`,
+		code.ID, code.UserCode, code.ClientID, strings.Join(code.Scope, " "), code.Status, code.UserID, code.Interval,
+		int64(code.LastPolledAt), int64(code.AuthTime), int64(code.ExpiresAt))
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repositores.ErrDuplicate
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	code, err := scanDeviceCode(s.queryRow(ctx, "SELECT "+deviceCodeColumns+" WHERE user_code = ? AND expires_at > ?",
+		userCode, time.Now().UnixMilli()))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return code, nil
+}
+
+func (s *SQLDB) PollDeviceCode(ctx context.Context, id string, polledAt time.Time) (*models.DeviceCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT " + deviceCodeColumns + " WHERE id = ?"
+	if s.Driver == Postgres {
+		query += " FOR UPDATE"
+	}
+
+	code, err := scanDeviceCode(tx.QueryRowContext(ctx, s.rebind(query), id))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	_, err = s.exec(ctx, tx, "UPDATE device_codes SET last_polled_at = ? WHERE id = ?", polledAt.UnixMilli(), id)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return code, tx.Commit()
+}
+
+func (s *SQLDB) UseDeviceCode(ctx context.Context, id string) (*models.DeviceCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "UPDATE device_codes SET status = ? WHERE id = ? AND status = ?", models.DeviceUsed, id, models.DeviceApproved)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	code, err := scanDeviceCode(s.queryRow(ctx, "SELECT "+deviceCodeColumns+" WHERE id = ?", id))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	if rowsAffected(res) == 0 {
+		return code, repositores.ErrConflict
+	}
+
+	return code, nil
+}
+
+func scanAuthCode(row scanner) (*models.AuthCode, error) {
+	var code models.AuthCode
+	var scope string
+	var authTime, expiresAt int64
+
+	err := row.Scan(&code.ID, &code.ClientID, &code.UserID, &code.RedirectURI, &scope, &code.Nonce, &code.CodeChallenge,
+		&code.CodeChallengeMethod, &authTime, &code.Used, &code.FamilyID, &expiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	code.Scope = strings.Fields(scope)
+	code.AuthTime = primitive.DateTime(authTime)
+	code.ExpiresAt = primitive.DateTime(expiresAt)
+
+	return &code, nil
+}
+
+func scanDeviceCode(row scanner) (*models.DeviceCode, error) {
+	var code models.DeviceCode
+	var scope string
+	var lastPolledAt, authTime, expiresAt int64
+
+	err := row.Scan(&code.ID, &code.UserCode, &code.ClientID, &scope, &code.Status, &code.UserID, &code.Interval,
+		&lastPolledAt, &authTime, &expiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	code.Scope = strings.Fields(scope)
+	code.LastPolledAt = primitive.DateTime(lastPolledAt)
+	code.AuthTime = primitive.DateTime(authTime)
+	code.ExpiresAt = primitive.DateTime(expiresAt)
+
+	return &code, nil
+}
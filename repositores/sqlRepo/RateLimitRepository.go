@@ -0,0 +1,89 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *SQLDB) TakeRateLimitToken(ctx context.Context, key string, now primitive.DateTime, rate float64, burst int) (*models.RateLimitBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	expiresAt := now.Time().Add(time.Duration(float64(burst) / rate * float64(time.Second))).UnixMilli()
+
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	//the upsert refills the bucket and holds its row until the token is taken,
+	//a new bucket is inserted full
+	bucket := models.RateLimitBucket{ID: key, UpdatedAt: now, ExpiresAt: primitive.DateTime(expiresAt)}
+	err = tx.QueryRowContext(ctx, s.rebind(`INSERT INTO rate_limit_buckets (id, tokens, updated_at, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			tokens = CASE
+				WHEN rate_limit_buckets.tokens + (excluded.updated_at - rate_limit_buckets.updated_at) * CAST(? AS DOUBLE PRECISION) > excluded.tokens THEN excluded.tokens
+				ELSE rate_limit_buckets.tokens + (excluded.updated_at - rate_limit_buckets.updated_at) * CAST(? AS DOUBLE PRECISION)
+			END,
+			updated_at = excluded.updated_at,
+			expires_at = excluded.expires_at
+		RETURNING tokens`),
+		key, float64(burst), int64(now), expiresAt, rate/1000, rate/1000).
+		Scan(&bucket.Tokens)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	bucket.Allowed = bucket.Tokens >= 1
+
+	if bucket.Allowed {
+		bucket.Tokens--
+
+		_, err = s.exec(ctx, tx, "UPDATE rate_limit_buckets SET tokens = ? WHERE id = ?", bucket.Tokens, key)
+
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	return &bucket, tx.Commit()
+}
+
+func (s *SQLDB) AddRateLimitHit(ctx context.Context, key string, start primitive.DateTime, window time.Duration) (*models.RateLimitWindow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	previous := int64(start) - window.Milliseconds()
+	expiresAt := int64(start) + 2*window.Milliseconds()
+
+	counter := models.RateLimitWindow{ID: key, WindowStart: start, ExpiresAt: primitive.DateTime(expiresAt)}
+	err := s.queryRow(ctx, `INSERT INTO rate_limit_windows (id, window_start, hits, previous_hits, expires_at) VALUES (?, ?, 1, 0, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			previous_hits = CASE
+				WHEN rate_limit_windows.window_start = excluded.window_start THEN rate_limit_windows.previous_hits
+				WHEN rate_limit_windows.window_start = ? THEN rate_limit_windows.hits
+				ELSE 0
+			END,
+			hits = CASE WHEN rate_limit_windows.window_start = excluded.window_start THEN rate_limit_windows.hits + 1 ELSE 1 END,
+			window_start = excluded.window_start,
+			expires_at = excluded.expires_at
+		RETURNING hits, previous_hits`,
+		key, int64(start), expiresAt, previous).
+		Scan(&counter.Hits, &counter.PreviousHits)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &counter, nil
+}
@@ -0,0 +1,184 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const refreshTokenColumns = `id, family_id, user_id, client_id, secret, count, access_token, used, revoked,
+	created_at, expires_at FROM refresh_tokens`
+
+func (s *SQLDB) SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `INSERT INTO refresh_tokens (id, family_id, user_id, client_id, secret, count, access_token, used, revoked, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET access_token = excluded.access_token, used = excluded.used,
+			revoked = excluded.revoked, expires_at = excluded.expires_at`,
+		token.ID, token.FamilyID, token.UserID, token.ClientID, token.Secret, token.Count, token.AccessToken,
+		token.Used, token.Revoked, int64(token.CreatedAt), int64(token.ExpiresAt))
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := scanRefreshToken(s.queryRow(ctx, "SELECT "+refreshTokenColumns+" WHERE id = ?", id))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	//expired rows wait for the cleaner
+	if result.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return result, nil
+}
+
+func (s *SQLDB) UseRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "UPDATE refresh_tokens SET used = TRUE WHERE id = ? AND used = FALSE", id)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	token, err := s.GetRefreshToken(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//either unknown or used before
+	if rowsAffected(res) == 0 {
+		return token, repositores.ErrConflict
+	}
+
+	return token, nil
+}
+
+func (s *SQLDB) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, "UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = ?", familyID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) RevokeUserRefreshTokens(ctx context.Context, userID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.rebind("SELECT DISTINCT family_id FROM refresh_tokens WHERE user_id = ? AND revoked = FALSE"), userID)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Println(err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	_, err = s.exec(ctx, tx, "UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = ?", userID)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return ids, tx.Commit()
+}
+
+func (s *SQLDB) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `INSERT INTO revoked_tokens (id, expires_at) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET expires_at = excluded.expires_at`, id, expiresAt.UnixMilli())
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var cnt int
+	err := s.queryRow(ctx, "SELECT COUNT(*) FROM revoked_tokens WHERE id = ? AND expires_at > ?", id, time.Now().UnixMilli()).Scan(&cnt)
+
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return cnt > 0, nil
+}
+
+func scanRefreshToken(row scanner) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	var createdAt, expiresAt int64
+
+	err := row.Scan(&token.ID, &token.FamilyID, &token.UserID, &token.ClientID, &token.Secret, &token.Count,
+		&token.AccessToken, &token.Used, &token.Revoked, &createdAt, &expiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token.CreatedAt = primitive.DateTime(createdAt)
+	token.ExpiresAt = primitive.DateTime(expiresAt)
+
+	return &token, nil
+}
@@ -0,0 +1,167 @@
+package sqlRepo
+
+import (
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// supported drivers, SQLite is pure go and meant for a single node
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// SQLDB implements the repositories on SQLite or PostgreSQL, queries are
+// written with ? placeholders and rebound for postgres
+type SQLDB struct {
+	Driver  string
+	DSN     string
+	DBClint *sql.DB
+	Hasher  repositores.PasswordHasher
+}
+
+func (s *SQLDB) ConnectDB() *sql.DB {
+	driverName := "sqlite"
+	if s.Driver == Postgres {
+		driverName = "pgx"
+	}
+
+	db, err := sql.Open(driverName, s.DSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//sqlite only allows one writer
+	if s.Driver == SQLite {
+		db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if s.Driver == SQLite {
+		_, err = db.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Println("connected to", s.Driver)
+	return db
+}
+
+// rebind turns ? placeholders into $1, $2... for postgres
+func (s *SQLDB) rebind(query string) string {
+	if s.Driver != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func (s *SQLDB) exec(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	if tx != nil {
+		return tx.ExecContext(ctx, s.rebind(query), args...)
+	}
+
+	return s.DBClint.ExecContext(ctx, s.rebind(query), args...)
+}
+
+func (s *SQLDB) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.DBClint.QueryRowContext(ctx, s.rebind(query), args...)
+}
+
+func (s *SQLDB) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.DBClint.QueryContext(ctx, s.rebind(query), args...)
+}
+
+// isUniqueViolation reports a duplicate key on both drivers
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		//SQLITE_CONSTRAINT_PRIMARYKEY and SQLITE_CONSTRAINT_UNIQUE
+		return liteErr.Code() == 1555 || liteErr.Code() == 2067
+	}
+
+	return false
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23503"
+	}
+
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		//SQLITE_CONSTRAINT_FOREIGNKEY
+		return liteErr.Code() == 787
+	}
+
+	return false
+}
+
+func rowsAffected(res sql.Result) int64 {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// drop expired tokens and codes, the sql version of the mongo TTL indexes
+func (s *SQLDB) Clean() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UnixMilli()
+	for _, table := range []string{"refresh_tokens", "revoked_tokens", "auth_codes", "device_codes", "user_tokens", "mfa_challenges", "webauthn_challenges", "login_attempts", "rate_limit_buckets", "rate_limit_windows"} {
+		_, err := s.exec(ctx, nil, "DELETE FROM "+table+" WHERE expires_at < ?", now)
+
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (s *SQLDB) CleanWorker(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			s.Clean()
+		}
+	}()
+}
@@ -0,0 +1,717 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const userColumns = `u.id, u.login_id, u.password_hash, u.domain, u.app_id, u.disabled, u.created_at, u.updated_at,
+	u.mfa_enabled, u.mfa_secret, u.mfa_pending_secret, u.mfa_last_step,
+	r.id, r.name, r.description,
+	COALESCE(p.first_name, ''), COALESCE(p.last_name, ''), COALESCE(p.email, ''), COALESCE(p.phone, ''),
+	COALESCE(p.street, ''), COALESCE(p.city, ''), COALESCE(p.state, ''), COALESCE(p.zip_code, ''),
+	COALESCE(p.email_verified, FALSE), COALESCE(p.email_verified_at, 0)
+	FROM users u
+	JOIN roles r ON r.id = u.role_id
+	LEFT JOIN profiles p ON p.user_id = u.id`
+
+func (s *SQLDB) CreateUser(ctx context.Context, usr *models.User) error {
+	usr.ID = primitive.NewObjectID()
+	hashPassword, err := s.Hasher.Hash(usr.UserAuth.Password)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	usr.UserAuth.Password = hashPassword
+	usr.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	usr.UpdatedAt = usr.CreatedAt
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	scope := &usr.UserAuth.Scope
+	roleID, err := s.roleID(ctx, tx, scope)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	scope.Role.RoleID = roleID
+
+	_, err = s.exec(ctx, tx, `INSERT INTO users (id, login_id, password_hash, domain, app_id, role_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		usr.ID.Hex(), usr.UserAuth.LoginID, usr.UserAuth.Password, scope.Domain, scope.AppID, roleID.Hex(), int64(usr.CreatedAt), int64(usr.UpdatedAt))
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repositores.ErrDuplicate
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	p := usr.Profile
+	_, err = s.exec(ctx, tx, `INSERT INTO profiles (user_id, first_name, last_name, email, phone, street, city, state, zip_code,
+		email_verified, email_verified_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		usr.ID.Hex(), p.FisrtName, p.LastNmae, p.Email, p.Phone, p.Address.Street, p.Address.City, p.Address.State, p.Address.Zipcode,
+		p.EmailVerified, int64(p.EmailVerifiedAt))
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, secret := range usr.ThirdPartySecrets {
+		_, err = s.exec(ctx, tx, "INSERT INTO third_party_secrets (user_id, key_name, key_value, description) VALUES (?, ?, ?, ?)",
+			usr.ID.Hex(), secret.KeyName, secret.KeyValue, secret.Description)
+
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// roleID returns the role of the tenant by name and creates it on first use
+func (s *SQLDB) roleID(ctx context.Context, tx *sql.Tx, scope *models.UserScope) (primitive.ObjectID, error) {
+	var id string
+	err := tx.QueryRowContext(ctx, s.rebind("SELECT id FROM roles WHERE domain = ? AND app_id = ? AND name = ?"),
+		scope.Domain, scope.AppID, scope.Role.RoleNmae).Scan(&id)
+
+	if err == nil {
+		return primitive.ObjectIDFromHex(id)
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return primitive.NilObjectID, err
+	}
+
+	roleID := primitive.NewObjectID()
+	_, err = s.exec(ctx, tx, "INSERT INTO roles (id, domain, app_id, name, description) VALUES (?, ?, ?, ?, ?)",
+		roleID.Hex(), scope.Domain, scope.AppID, scope.Role.RoleNmae, scope.Role.Description)
+
+	return roleID, err
+}
+
+func (s *SQLDB) ValidUserByLonginUser(ctx context.Context, userAuth *models.UserAuth) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	row := s.queryRow(ctx, "SELECT "+userColumns+" WHERE u.login_id = ? AND u.domain = ? AND u.app_id = ?",
+		userAuth.LoginID, userAuth.Scope.Domain, userAuth.Scope.AppID)
+	result, err := scanUser(row)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	rehash, err := repositores.VerifyPassword(s.Hasher, result.UserAuth.Password, userAuth.Password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Disabled {
+		return nil, repositores.ErrUserDisabled
+	}
+
+	if rehash {
+		s.rehashPassword(ctx, result.ID.Hex(), result.UserAuth.Password, userAuth.Password)
+	}
+
+	//same projection as the mongo lookup
+	result.UserAuth.Password = ""
+
+	return result, nil
+}
+
+func (s *SQLDB) IsUserLoninIdUnique(ctx context.Context, userAuth *models.UserAuth) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var cnt int
+	err := s.queryRow(ctx, "SELECT COUNT(*) FROM users WHERE login_id = ? AND domain = ? AND app_id = ?",
+		userAuth.LoginID, userAuth.Scope.Domain, userAuth.Scope.AppID).Scan(&cnt)
+
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return cnt == 0, nil
+}
+
+func (s *SQLDB) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := scanUser(s.queryRow(ctx, "SELECT "+userColumns+" WHERE u.id = ?", id))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	result.UserAuth.Password = ""
+
+	return result, nil
+}
+
+func (s *SQLDB) ListUsers(ctx context.Context, filter *models.UserFilter) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conds := []string{"1 = 1"}
+	args := []interface{}{}
+
+	where := func(cond string, values ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, values...)
+	}
+
+	if filter.Domain != "" {
+		where("u.domain = ?", filter.Domain)
+	}
+
+	if filter.AppID != "" {
+		where("u.app_id = ?", filter.AppID)
+	}
+
+	if filter.Role != "" {
+		where("r.name = ?", filter.Role)
+	}
+
+	if !filter.CreatedAfter.IsZero() {
+		where("u.created_at >= ?", filter.CreatedAfter.UnixMilli())
+	}
+
+	if !filter.CreatedBefore.IsZero() {
+		where("u.created_at < ?", filter.CreatedBefore.UnixMilli())
+	}
+
+	if filter.After != "" {
+		where("u.id > ?", filter.After)
+	}
+
+	if filter.Query != "" {
+		pattern := "%" + escapeLike(strings.ToLower(filter.Query)) + "%"
+		where(`(LOWER(u.login_id) LIKE ? ESCAPE '\' OR LOWER(p.email) LIKE ? ESCAPE '\'
+			OR LOWER(p.first_name) LIKE ? ESCAPE '\' OR LOWER(p.last_name) LIKE ? ESCAPE '\')`,
+			pattern, pattern, pattern, pattern)
+	}
+
+	args = append(args, filter.Limit)
+	rows, err := s.query(ctx, "SELECT "+userColumns+" WHERE "+strings.Join(conds, " AND ")+" ORDER BY u.id LIMIT ?", args...)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+
+	for rows.Next() {
+		usr, err := scanUser(rows)
+
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		usr.UserAuth.Password = ""
+		users = append(users, *usr)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (s *SQLDB) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "UPDATE users SET disabled = ? WHERE id = ?", disabled, userID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+// SetUserRole moves the user to a role of its tenant, the role is created on
+// first use like at signup
+func (s *SQLDB) SetUserRole(ctx context.Context, userID string, role models.UserRole) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	scope := models.UserScope{Role: role}
+	err = tx.QueryRowContext(ctx, s.rebind("SELECT domain, app_id FROM users WHERE id = ?"), userID).
+		Scan(&scope.Domain, &scope.AppID)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	roleID, err := s.roleID(ctx, tx, &scope)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = s.exec(ctx, tx, "UPDATE users SET role_id = ? WHERE id = ?", roleID.Hex(), userID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUser removes the profile and secrets with the user
+func (s *SQLDB) DeleteUser(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "DELETE FROM users WHERE id = ?", userID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLDB) GetUserByLoginID(ctx context.Context, userAuth *models.UserAuth) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	row := s.queryRow(ctx, "SELECT "+userColumns+" WHERE u.login_id = ? AND u.domain = ? AND u.app_id = ?",
+		userAuth.LoginID, userAuth.Scope.Domain, userAuth.Scope.AppID)
+	result, err := scanUser(row)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	result.UserAuth.Password = ""
+
+	return result, nil
+}
+
+func (s *SQLDB) UpdatePassword(ctx context.Context, userID string, password string) error {
+	hashPassword, err := s.Hasher.Hash(password)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "UPDATE users SET password_hash = ? WHERE id = ?", hashPassword, userID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+// SetMFA replaces the second factor, recovery codes live in their own table
+func (s *SQLDB) SetMFA(ctx context.Context, userID string, mfa *models.MFA) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := s.exec(ctx, tx, "UPDATE users SET mfa_enabled = ?, mfa_secret = ?, mfa_pending_secret = ?, mfa_last_step = ? WHERE id = ?",
+		mfa.Enabled, mfa.Secret, mfa.PendingSecret, mfa.LastStep, userID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	_, err = s.exec(ctx, tx, "DELETE FROM mfa_recovery_codes WHERE user_id = ?", userID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, code := range mfa.RecoveryCodes {
+		_, err = s.exec(ctx, tx, "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, code)
+
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "UPDATE users SET mfa_last_step = ? WHERE id = ? AND mfa_last_step < ?", step, userID, step)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrConflict
+	}
+
+	return nil
+}
+
+func (s *SQLDB) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "DELETE FROM mfa_recovery_codes WHERE user_id = ? AND code_hash = ?", userID, codeHash)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLDB) SetEmailVerified(ctx context.Context, userID string, email string, verifiedAt primitive.DateTime) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := s.exec(ctx, tx, "UPDATE profiles SET email_verified = ?, email_verified_at = ? WHERE user_id = ? AND email = ?",
+		true, int64(verifiedAt), userID, email)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		var id string
+		err = tx.QueryRowContext(ctx, s.rebind("SELECT id FROM users WHERE id = ?"), userID).Scan(&id)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositores.ErrNotFound
+		}
+
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		return repositores.ErrConflict
+	}
+
+	//the version moves like on a profile update so If-Match sees the change
+	now := time.Now().UnixMilli()
+	_, err = s.exec(ctx, tx, "UPDATE users SET updated_at = CASE WHEN updated_at < ? THEN ? ELSE updated_at + 1 END WHERE id = ?",
+		now, now, userID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) UpdateUserProfile(ctx context.Context, userID string, profile models.UserPorfile, version primitive.DateTime) (primitive.DateTime, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	next := repositores.NextVersion(version)
+	res, err := s.exec(ctx, tx, "UPDATE users SET updated_at = ? WHERE id = ? AND updated_at = ?", int64(next), userID, int64(version))
+
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	if rowsAffected(res) == 0 {
+		var id string
+		err = tx.QueryRowContext(ctx, s.rebind("SELECT id FROM users WHERE id = ?"), userID).Scan(&id)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, repositores.ErrNotFound
+		}
+
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+
+		return 0, repositores.ErrConflict
+	}
+
+	p := profile
+	_, err = s.exec(ctx, tx, `UPDATE profiles SET first_name = ?, last_name = ?, email = ?, phone = ?, street = ?, city = ?, state = ?, zip_code = ?,
+		email_verified = ?, email_verified_at = ? WHERE user_id = ?`,
+		p.FisrtName, p.LastNmae, p.Email, p.Phone, p.Address.Street, p.Address.City, p.Address.State, p.Address.Zipcode,
+		p.EmailVerified, int64(p.EmailVerifiedAt), userID)
+
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	return next, nil
+}
+
+func (s *SQLDB) AddThirdPartySecret(ctx context.Context, userID string, secret models.ThirdPartySecret) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, "INSERT INTO third_party_secrets (user_id, key_name, key_value, description) VALUES (?, ?, ?, ?)",
+		userID, secret.KeyName, secret.KeyValue, secret.Description)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repositores.ErrDuplicate
+		}
+
+		if isForeignKeyViolation(err) {
+			return repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) UpdateThirdPartySecret(ctx context.Context, userID string, secret models.ThirdPartySecret) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "UPDATE third_party_secrets SET key_value = ?, description = ? WHERE user_id = ? AND key_name = ?",
+		secret.KeyValue, secret.Description, userID, secret.KeyName)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLDB) GetJwtSecret(ctx context.Context, userID string, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var value string
+	err := s.queryRow(ctx, "SELECT key_value FROM third_party_secrets WHERE user_id = ? AND key_name = ?", userID, key).Scan(&value)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return "", err
+	}
+
+	return value, nil
+}
+
+func (s *SQLDB) ReplaceJwtSecret(ctx context.Context, userID string, key string, old string, value string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, "UPDATE third_party_secrets SET key_value = ? WHERE user_id = ? AND key_name = ? AND key_value = ?",
+		value, userID, key, old)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// rehashPassword upgrades the hash after a login unless the password was
+// changed meanwhile, a failure only means the next login tries again
+func (s *SQLDB) rehashPassword(ctx context.Context, userID string, oldHash string, password string) {
+	hashPassword, err := s.Hasher.Hash(password)
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	_, err = s.exec(ctx, nil, "UPDATE users SET password_hash = ? WHERE id = ? AND password_hash = ?", hashPassword, userID, oldHash)
+
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// escapeLike makes % and _ in a search literal
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func scanUser(row scanner) (*models.User, error) {
+	var usr models.User
+	var id, roleID string
+	var createdAt, updatedAt, emailVerifiedAt int64
+	p := &usr.Profile
+	scope := &usr.UserAuth.Scope
+
+	err := row.Scan(&id, &usr.UserAuth.LoginID, &usr.UserAuth.Password, &scope.Domain, &scope.AppID, &usr.Disabled, &createdAt, &updatedAt,
+		&usr.MFA.Enabled, &usr.MFA.Secret, &usr.MFA.PendingSecret, &usr.MFA.LastStep,
+		&roleID, &scope.Role.RoleNmae, &scope.Role.Description,
+		&p.FisrtName, &p.LastNmae, &p.Email, &p.Phone,
+		&p.Address.Street, &p.Address.City, &p.Address.State, &p.Address.Zipcode,
+		&p.EmailVerified, &emailVerifiedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	usr.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	scope.Role.RoleID, _ = primitive.ObjectIDFromHex(roleID)
+	usr.CreatedAt = primitive.DateTime(createdAt)
+	usr.UpdatedAt = primitive.DateTime(updatedAt)
+	p.EmailVerifiedAt = primitive.DateTime(emailVerifiedAt)
+
+	return &usr, nil
+}
@@ -0,0 +1,69 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *SQLDB) SaveUserToken(ctx context.Context, token *models.UserToken) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, "INSERT INTO user_tokens (id, purpose, user_id, expires_at) VALUES (?, ?, ?, ?)",
+		token.ID, token.Purpose, token.UserID, int64(token.ExpiresAt))
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) UseUserToken(ctx context.Context, id string, purpose string) (*models.UserToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	token := models.UserToken{ID: id, Purpose: purpose}
+	var expiresAt int64
+	err := s.queryRow(ctx, "DELETE FROM user_tokens WHERE id = ? AND purpose = ? RETURNING user_id, expires_at", id, purpose).
+		Scan(&token.UserID, &expiresAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	token.ExpiresAt = primitive.DateTime(expiresAt)
+
+	if token.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &token, nil
+}
+
+func (s *SQLDB) DeleteUserTokens(ctx context.Context, userID string, purpose string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, "DELETE FROM user_tokens WHERE user_id = ? AND purpose = ?", userID, purpose)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
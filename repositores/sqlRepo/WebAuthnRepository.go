@@ -0,0 +1,194 @@
+package sqlRepo
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const webAuthnColumns = `id, user_id, name, public_key, sign_count, aaguid, attestation_format, transports,
+	backup_eligible, created_at, last_used_at FROM webauthn_credentials`
+
+func scanWebAuthnCredential(row scanner) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	var publicKey, transports string
+	var createdAt, lastUsedAt int64
+
+	err := row.Scan(&credential.ID, &credential.UserID, &credential.Name, &publicKey, &credential.SignCount,
+		&credential.AAGUID, &credential.AttestationFormat, &transports, &credential.BackupEligible, &createdAt, &lastUsedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	credential.PublicKey, err = base64.RawURLEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	credential.Transports = strings.Fields(transports)
+	credential.CreatedAt = primitive.DateTime(createdAt)
+	credential.LastUsedAt = primitive.DateTime(lastUsedAt)
+
+	return &credential, nil
+}
+
+func (s *SQLDB) AddWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `INSERT INTO webauthn_credentials (id, user_id, name, public_key, sign_count, aaguid,
+		attestation_format, transports, backup_eligible, created_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		credential.ID, credential.UserID, credential.Name, base64.RawURLEncoding.EncodeToString(credential.PublicKey),
+		credential.SignCount, credential.AAGUID, credential.AttestationFormat, strings.Join(credential.Transports, " "),
+		credential.BackupEligible, int64(credential.CreatedAt), int64(credential.LastUsedAt))
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repositores.ErrDuplicate
+		}
+
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) GetWebAuthnCredential(ctx context.Context, id string) (*models.WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	credential, err := scanWebAuthnCredential(s.queryRow(ctx, "SELECT "+webAuthnColumns+" WHERE id = ?", id))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+func (s *SQLDB) ListWebAuthnCredentials(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, "SELECT "+webAuthnColumns+" WHERE user_id = ? ORDER BY created_at", userID)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []models.WebAuthnCredential{}
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		credentials = append(credentials, *credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+func (s *SQLDB) UseWebAuthnCredential(ctx context.Context, id string, signCount int64, usedAt primitive.DateTime) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	//the counter only moves forward, authenticators without one stay at zero
+	res, err := s.exec(ctx, nil, `UPDATE webauthn_credentials SET sign_count = ?, last_used_at = ?
+		WHERE id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))`,
+		signCount, int64(usedAt), id, signCount, signCount)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		if _, err := s.GetWebAuthnCredential(ctx, id); err != nil {
+			return err
+		}
+
+		return repositores.ErrConflict
+	}
+
+	return nil
+}
+
+func (s *SQLDB) DeleteWebAuthnCredential(ctx context.Context, userID string, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.exec(ctx, nil, "DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, userID)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rowsAffected(res) == 0 {
+		return repositores.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLDB) SaveWebAuthnChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, "INSERT INTO webauthn_challenges (id, user_id, purpose, expires_at) VALUES (?, ?, ?, ?)",
+		challenge.ID, challenge.UserID, challenge.Purpose, int64(challenge.ExpiresAt))
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLDB) UseWebAuthnChallenge(ctx context.Context, id string) (*models.WebAuthnChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	challenge := models.WebAuthnChallenge{ID: id}
+	var expiresAt int64
+	err := s.queryRow(ctx, "DELETE FROM webauthn_challenges WHERE id = ? RETURNING user_id, purpose, expires_at", id).
+		Scan(&challenge.UserID, &challenge.Purpose, &expiresAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositores.ErrNotFound
+		}
+
+		log.Println(err)
+		return nil, err
+	}
+
+	challenge.ExpiresAt = primitive.DateTime(expiresAt)
+
+	if challenge.ExpiresAt.Time().Before(time.Now()) {
+		return nil, repositores.ErrNotFound
+	}
+
+	return &challenge, nil
+}
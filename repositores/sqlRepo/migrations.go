@@ -0,0 +1,363 @@
+package sqlRepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// migrations are applied in order and never edited once released, add a new
+// version instead. The DDL is the common subset of SQLite and PostgreSQL,
+// times are unix milliseconds like primitive.DateTime
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "users",
+		statements: []string{
+			`CREATE TABLE roles (
+				id TEXT PRIMARY KEY,
+				domain TEXT NOT NULL,
+				app_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				UNIQUE (domain, app_id, name)
+			)`,
+			`CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				login_id TEXT NOT NULL,
+				password_hash TEXT NOT NULL,
+				domain TEXT NOT NULL,
+				app_id TEXT NOT NULL,
+				role_id TEXT NOT NULL REFERENCES roles (id),
+				created_at BIGINT NOT NULL,
+				updated_at BIGINT NOT NULL,
+				UNIQUE (login_id, domain, app_id)
+			)`,
+			`CREATE TABLE profiles (
+				user_id TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				first_name TEXT NOT NULL DEFAULT '',
+				last_name TEXT NOT NULL DEFAULT '',
+				email TEXT NOT NULL DEFAULT '',
+				phone TEXT NOT NULL DEFAULT '',
+				street TEXT NOT NULL DEFAULT '',
+				city TEXT NOT NULL DEFAULT '',
+				state TEXT NOT NULL DEFAULT '',
+				zip_code TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE third_party_secrets (
+				user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				key_name TEXT NOT NULL,
+				key_value TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (user_id, key_name)
+			)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "clients",
+		statements: []string{
+			`CREATE TABLE clients (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				secret_hash TEXT NOT NULL DEFAULT '',
+				public BOOLEAN NOT NULL,
+				grant_types TEXT NOT NULL,
+				scopes TEXT NOT NULL,
+				redirect_uris TEXT NOT NULL,
+				token_exchange TEXT NOT NULL DEFAULT '',
+				domain TEXT NOT NULL,
+				app_id TEXT NOT NULL,
+				created_at BIGINT NOT NULL,
+				updated_at BIGINT NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "signing_keys_and_tokens",
+		statements: []string{
+			`CREATE TABLE signing_keys (
+				kid TEXT PRIMARY KEY,
+				alg TEXT NOT NULL,
+				state TEXT NOT NULL,
+				private_key TEXT NOT NULL,
+				created_at BIGINT NOT NULL,
+				activate_at BIGINT NOT NULL,
+				retire_at BIGINT NOT NULL DEFAULT 0,
+				expire_at BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE refresh_tokens (
+				id TEXT PRIMARY KEY,
+				family_id TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				client_id TEXT NOT NULL DEFAULT '',
+				secret TEXT NOT NULL DEFAULT '',
+				count INTEGER NOT NULL DEFAULT 0,
+				access_token TEXT NOT NULL DEFAULT '',
+				used BOOLEAN NOT NULL DEFAULT FALSE,
+				revoked BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at BIGINT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id)`,
+			`CREATE INDEX refresh_tokens_user_id ON refresh_tokens (user_id)`,
+			`CREATE INDEX refresh_tokens_expires_at ON refresh_tokens (expires_at)`,
+			`CREATE TABLE revoked_tokens (
+				id TEXT PRIMARY KEY,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX revoked_tokens_expires_at ON revoked_tokens (expires_at)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "oauth_grants",
+		statements: []string{
+			`CREATE TABLE auth_codes (
+				id TEXT PRIMARY KEY,
+				client_id TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				redirect_uri TEXT NOT NULL,
+				scope TEXT NOT NULL DEFAULT '',
+				nonce TEXT NOT NULL DEFAULT '',
+				code_challenge TEXT NOT NULL DEFAULT '',
+				code_challenge_method TEXT NOT NULL DEFAULT '',
+				auth_time BIGINT NOT NULL DEFAULT 0,
+				used BOOLEAN NOT NULL DEFAULT FALSE,
+				family_id TEXT NOT NULL DEFAULT '',
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX auth_codes_expires_at ON auth_codes (expires_at)`,
+			`CREATE TABLE device_codes (
+				id TEXT PRIMARY KEY,
+				user_code TEXT NOT NULL UNIQUE,
+				client_id TEXT NOT NULL,
+				scope TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL,
+				user_id TEXT NOT NULL DEFAULT '',
+				poll_interval INTEGER NOT NULL,
+				last_polled_at BIGINT NOT NULL DEFAULT 0,
+				auth_time BIGINT NOT NULL DEFAULT 0,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX device_codes_expires_at ON device_codes (expires_at)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "user_admin",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`,
+			`CREATE INDEX users_created_at ON users (created_at)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "user_tokens",
+		statements: []string{
+			`CREATE TABLE user_tokens (
+				id TEXT PRIMARY KEY,
+				purpose TEXT NOT NULL,
+				user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX user_tokens_user_id ON user_tokens (user_id)`,
+			`CREATE INDEX user_tokens_expires_at ON user_tokens (expires_at)`,
+		},
+	},
+	{
+		version: 7,
+		name:    "mfa",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE users ADD COLUMN mfa_secret TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN mfa_pending_secret TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN mfa_last_step BIGINT NOT NULL DEFAULT 0`,
+			`CREATE TABLE mfa_recovery_codes (
+				user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				code_hash TEXT NOT NULL,
+				PRIMARY KEY (user_id, code_hash)
+			)`,
+			`CREATE TABLE mfa_challenges (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				client_id TEXT NOT NULL,
+				key_name TEXT NOT NULL,
+				scope TEXT NOT NULL,
+				nonce TEXT NOT NULL,
+				enroll BOOLEAN NOT NULL,
+				attempts INTEGER NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX mfa_challenges_expires_at ON mfa_challenges (expires_at)`,
+		},
+	},
+	{
+		version: 8,
+		name:    "webauthn",
+		statements: []string{
+			`CREATE TABLE webauthn_credentials (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				public_key TEXT NOT NULL,
+				sign_count BIGINT NOT NULL,
+				aaguid TEXT NOT NULL,
+				attestation_format TEXT NOT NULL,
+				transports TEXT NOT NULL,
+				backup_eligible BOOLEAN NOT NULL,
+				created_at BIGINT NOT NULL,
+				last_used_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX webauthn_credentials_user_id ON webauthn_credentials (user_id)`,
+			`CREATE TABLE webauthn_challenges (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				purpose TEXT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX webauthn_challenges_expires_at ON webauthn_challenges (expires_at)`,
+		},
+	},
+	{
+		version: 9,
+		name:    "email_verification",
+		statements: []string{
+			`ALTER TABLE profiles ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE profiles ADD COLUMN email_verified_at BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 10,
+		name:    "login_attempts",
+		statements: []string{
+			`CREATE TABLE login_attempts (
+				id TEXT PRIMARY KEY,
+				failures INTEGER NOT NULL,
+				first_failure BIGINT NOT NULL,
+				last_failure BIGINT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX login_attempts_expires_at ON login_attempts (expires_at)`,
+		},
+	},
+	{
+		version: 11,
+		name:    "rate_limits",
+		statements: []string{
+			`CREATE TABLE rate_limit_buckets (
+				id TEXT PRIMARY KEY,
+				tokens DOUBLE PRECISION NOT NULL,
+				updated_at BIGINT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX rate_limit_buckets_expires_at ON rate_limit_buckets (expires_at)`,
+			`CREATE TABLE rate_limit_windows (
+				id TEXT PRIMARY KEY,
+				window_start BIGINT NOT NULL,
+				hits BIGINT NOT NULL,
+				previous_hits BIGINT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX rate_limit_windows_expires_at ON rate_limit_windows (expires_at)`,
+		},
+	},
+	{
+		version: 12,
+		name:    "login_attempts_previous_failure",
+		statements: []string{
+			`ALTER TABLE login_attempts ADD COLUMN previous_failure BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 13,
+		name:    "leases",
+		statements: []string{
+			`CREATE TABLE leases (
+				name TEXT PRIMARY KEY,
+				holder TEXT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+		},
+	},
+}
+
+// Migrate applies the migrations that are not recorded in schema_migrations,
+// each one in its own transaction
+func (s *SQLDB) Migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := s.exec(ctx, nil, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, m := range migrations {
+		var version int
+		err = s.queryRow(ctx, "SELECT version FROM schema_migrations WHERE version = ?", m.version).Scan(&version)
+
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+			return err
+		}
+
+		err = s.applyMigration(ctx, m)
+
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		log.Println("applied migration", m.version, m.name)
+	}
+
+	return nil
+}
+
+func (s *SQLDB) applyMigration(ctx context.Context, m migration) error {
+	tx, err := s.DBClint.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//claim the version first so a concurrent runner fails on the key
+	_, err = s.exec(ctx, tx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.version, m.name, time.Now().UnixMilli())
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, stmt := range m.statements {
+		_, err = tx.ExecContext(ctx, stmt)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,126 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// attestation types
+const (
+	AttestationNone  = "none"
+	AttestationSelf  = "self"
+	AttestationBasic = "basic"
+)
+
+// id-fido-gen-ce-aaguid, the AAGUID in packed attestation certificates
+var oidFIDOAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+type packedStatement struct {
+	Alg int      `cbor:"alg"`
+	Sig []byte   `cbor:"sig"`
+	X5c [][]byte `cbor:"x5c"`
+}
+
+func parseAttestationObject(data []byte) (*attestationObject, error) {
+	var obj attestationObject
+
+	if err := decMode.Unmarshal(data, &obj); err != nil || obj.Fmt == "" || len(obj.AuthData) == 0 {
+		return nil, ErrInvalidAttestation
+	}
+
+	return &obj, nil
+}
+
+// verifyAttestation checks the statement of the "none" and "packed" formats
+// and returns the attestation type, alg is the credential key algorithm
+func verifyAttestation(obj *attestationObject, ad *authenticatorData, alg int, clientDataHash []byte) (string, error) {
+	switch obj.Fmt {
+	case "none":
+		var stmt map[string]interface{}
+
+		if err := decMode.Unmarshal(obj.AttStmt, &stmt); err != nil || len(stmt) != 0 {
+			return "", ErrInvalidAttestation
+		}
+
+		return AttestationNone, nil
+	case "packed":
+		var stmt packedStatement
+
+		if err := decMode.Unmarshal(obj.AttStmt, &stmt); err != nil || len(stmt.Sig) == 0 {
+			return "", ErrInvalidAttestation
+		}
+
+		signed := append(append([]byte{}, obj.AuthData...), clientDataHash...)
+
+		//self attestation is signed with the credential key itself
+		if len(stmt.X5c) == 0 {
+			if stmt.Alg != alg {
+				return "", ErrInvalidAttestation
+			}
+
+			if err := verifySignature(ad.PublicKey, signed, stmt.Sig); err != nil {
+				return "", err
+			}
+
+			return AttestationSelf, nil
+		}
+
+		cert, err := x509.ParseCertificate(stmt.X5c[0])
+		if err != nil {
+			return "", ErrInvalidAttestation
+		}
+
+		if err := verifyWith(cert.PublicKey, stmt.Alg, signed, stmt.Sig); err != nil {
+			return "", err
+		}
+
+		if err := verifyPackedCertificate(cert, ad.AAGUID); err != nil {
+			return "", err
+		}
+
+		return AttestationBasic, nil
+	}
+
+	return "", ErrUnsupportedFormat
+}
+
+// verifyPackedCertificate checks the requirements of the specification on
+// the attestation certificate (8.2.1)
+func verifyPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	subject := cert.Subject
+
+	if cert.Version != 3 || len(subject.Country) != 1 || len(subject.Organization) == 0 || subject.CommonName == "" {
+		return ErrInvalidAttestation
+	}
+
+	if len(subject.OrganizationalUnit) != 1 || subject.OrganizationalUnit[0] != "Authenticator Attestation" {
+		return ErrInvalidAttestation
+	}
+
+	if !cert.BasicConstraintsValid || cert.IsCA {
+		return ErrInvalidAttestation
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidFIDOAAGUID) {
+			continue
+		}
+
+		var value []byte
+
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil || ext.Critical || !bytes.Equal(value, aaguid) {
+			return ErrInvalidAttestation
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package webauthn
+
+import (
+	"encoding/binary"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// authenticator data flags
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackupState    = 0x10
+	flagAttestedData   = 0x40
+	flagExtensions     = 0x80
+)
+
+// maximum credential id length of the specification
+const maxCredentialIDLen = 1023
+
+// authenticator output is rejected with duplicate map keys
+var decMode, _ = cbor.DecOptions{DupMapKey: cbor.DupMapKeyEnforcedAPF}.DecMode()
+
+type authenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	//attested credential data, only sent on registration
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// parseAuthenticatorData reads rpIdHash(32) flags(1) signCount(4), then the
+// attested credential data and extensions when their flags are set
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidAuthData
+	}
+
+	ad := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	rest := data[37:]
+
+	if ad.Flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, ErrInvalidAuthData
+		}
+
+		ad.AAGUID = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if idLen == 0 || idLen > maxCredentialIDLen || len(rest) < idLen {
+			return nil, ErrInvalidAuthData
+		}
+
+		ad.CredentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		//the key is the first cbor item, its length is only known after decoding
+		var key cbor.RawMessage
+		remaining, err := decMode.UnmarshalFirst(rest, &key)
+
+		if err != nil {
+			return nil, ErrInvalidAuthData
+		}
+
+		ad.PublicKey = rest[:len(rest)-len(remaining)]
+		rest = remaining
+	}
+
+	if ad.Flags&flagExtensions != 0 {
+		var extensions map[string]interface{}
+		remaining, err := decMode.UnmarshalFirst(rest, &extensions)
+
+		if err != nil {
+			return nil, ErrInvalidAuthData
+		}
+
+		rest = remaining
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrInvalidAuthData
+	}
+
+	return ad, nil
+}
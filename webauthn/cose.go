@@ -0,0 +1,128 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+// COSE key parameters (RFC 9053)
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// parsePublicKey reads a COSE_Key, the parameters below 0 depend on kty:
+// crv -1, x -2 and y -3 for curves, n -1 and e -2 for RSA
+func parsePublicKey(data []byte) (crypto.PublicKey, int, error) {
+	var key map[int]interface{}
+
+	if err := decMode.Unmarshal(data, &key); err != nil {
+		return nil, 0, ErrUnsupportedKey
+	}
+
+	kty, _ := coseInt(key[coseKty])
+	alg, _ := coseInt(key[coseAlg])
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := coseInt(key[-1])
+		x, _ := key[-2].([]byte)
+		y, _ := key[-3].([]byte)
+
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		//rejects points that are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, alg, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := coseInt(key[-1])
+		x, _ := key[-2].([]byte)
+
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		return ed25519.PublicKey(x), alg, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := key[-1].([]byte)
+		e, _ := key[-2].([]byte)
+
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, alg, nil
+	}
+
+	return nil, 0, ErrUnsupportedKey
+}
+
+// cbor decodes integers as uint64 or int64
+func coseInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return int(n), true
+	case int64:
+		return int(n), true
+	}
+
+	return 0, false
+}
+
+// verifySignature checks sig over data with a COSE key
+func verifySignature(coseKey []byte, data []byte, sig []byte) error {
+	pub, alg, err := parsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	return verifyWith(pub, alg, data, sig)
+}
+
+func verifyWith(pub crypto.PublicKey, alg int, data []byte, sig []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch alg {
+	case AlgES256:
+		key, ok := pub.(*ecdsa.PublicKey)
+
+		if ok && ecdsa.VerifyASN1(key, digest[:], sig) {
+			return nil
+		}
+	case AlgEdDSA:
+		key, ok := pub.(ed25519.PublicKey)
+
+		if ok && ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	case AlgRS256:
+		key, ok := pub.(*rsa.PublicKey)
+
+		if ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
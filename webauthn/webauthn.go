@@ -0,0 +1,443 @@
+// Package webauthn verifies the registration and authentication ceremonies of
+// the Web Authentication API. It keeps no state, the caller stores the
+// challenges and credentials, so every check runs as well against a software
+// authenticator as against a browser
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// COSE algorithms of the supported credential keys
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+const publicKeyType = "public-key"
+
+var (
+	ErrInvalidCredential  = errors.New("invalid credential")
+	ErrInvalidClientData  = errors.New("invalid client data")
+	ErrChallengeMismatch  = errors.New("challenge mismatch")
+	ErrOriginMismatch     = errors.New("origin not allowed")
+	ErrRPIDMismatch       = errors.New("credential is for another relying party")
+	ErrUserNotPresent     = errors.New("user not present")
+	ErrUserNotVerified    = errors.New("user not verified")
+	ErrInvalidAuthData    = errors.New("invalid authenticator data")
+	ErrInvalidAttestation = errors.New("invalid attestation")
+	ErrUnsupportedFormat  = errors.New("unsupported attestation format")
+	ErrUnsupportedKey     = errors.New("unsupported public key")
+	ErrInvalidSignature   = errors.New("invalid signature")
+)
+
+// RelyingParty is the site credentials are scoped to. ID is its domain and
+// Origins the exact origins browsers may report for it
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	//a PIN or biometric check on the authenticator, passkeys replace the
+	//password and the second factor only with it
+	RequireUserVerification bool
+}
+
+// Base64URL is binary data sent as unpadded base64url in JSON
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+func (b Base64URL) String() string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewChallenge returns 32 random bytes
+func NewChallenge() Base64URL {
+	b := make([]byte, 32)
+	rand.Read(b)
+
+	return b
+}
+
+// options for navigator.credentials.create and get
+
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// NewDescriptor names an existing credential in the options
+func NewDescriptor(id []byte, transports []string) CredentialDescriptor {
+	return CredentialDescriptor{Type: publicKeyType, ID: id, Transports: transports}
+}
+
+// CreationOptions asks for a discoverable credential so the login works
+// without a user name, the credentials in exclude are not created twice
+func (rp *RelyingParty) CreationOptions(user UserEntity, challenge Base64URL, exclude []CredentialDescriptor, timeout time.Duration) *CreationOptions {
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	return &CreationOptions{
+		RP:        RPEntity{ID: rp.ID, Name: rp.Name},
+		User:      user,
+		Challenge: challenge,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: publicKeyType, Alg: AlgES256},
+			{Type: publicKeyType, Alg: AlgEdDSA},
+			{Type: publicKeyType, Alg: AlgRS256},
+		},
+		Timeout:            timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   rp.userVerification(),
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions starts a login, an empty allow list lets the authenticator
+// offer its discoverable credentials
+func (rp *RelyingParty) RequestOptions(challenge Base64URL, allow []CredentialDescriptor, timeout time.Duration) *RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: rp.userVerification(),
+	}
+}
+
+func (rp *RelyingParty) userVerification() string {
+	if rp.RequireUserVerification {
+		return "required"
+	}
+
+	return "preferred"
+}
+
+// responses of navigator.credentials.create and get as PublicKeyCredential
+// toJSON sends them
+
+type AttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+	Transports        []string  `json:"transports"`
+	//copies of the attestation object for clients, not read
+	AuthenticatorData  Base64URL `json:"authenticatorData,omitempty"`
+	PublicKey          Base64URL `json:"publicKey,omitempty"`
+	PublicKeyAlgorithm int       `json:"publicKeyAlgorithm,omitempty"`
+}
+
+type CreationResponse struct {
+	ID                      string                 `json:"id"`
+	RawID                   Base64URL              `json:"rawId"`
+	Type                    string                 `json:"type"`
+	Response                AttestationResponse    `json:"response"`
+	AuthenticatorAttachment string                 `json:"authenticatorAttachment,omitempty"`
+	ClientExtensionResults  map[string]interface{} `json:"clientExtensionResults,omitempty"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle"`
+}
+
+type RequestResponse struct {
+	ID                      string                 `json:"id"`
+	RawID                   Base64URL              `json:"rawId"`
+	Type                    string                 `json:"type"`
+	Response                AssertionResponse      `json:"response"`
+	AuthenticatorAttachment string                 `json:"authenticatorAttachment,omitempty"`
+	ClientExtensionResults  map[string]interface{} `json:"clientExtensionResults,omitempty"`
+}
+
+// Credential is a verified registration, PublicKey is the COSE key
+type Credential struct {
+	ID                []byte
+	PublicKey         []byte
+	Algorithm         int
+	SignCount         uint32
+	AAGUID            []byte
+	AttestationFormat string
+	//none, self or basic, the chain of basic attestation is not checked
+	//against a metadata service
+	AttestationType string
+	Transports      []string
+	UserVerified    bool
+	BackupEligible  bool
+	BackupState     bool
+}
+
+// Assertion is a verified login
+type Assertion struct {
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+	UserVerified bool
+	BackupState  bool
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Challenge reads the challenge of clientDataJSON, the caller looks up the
+// ceremony with it before verifying
+func Challenge(clientDataJSON []byte) (Base64URL, error) {
+	var data clientData
+
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, ErrInvalidClientData
+	}
+
+	challenge, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+
+	if err != nil || len(challenge) == 0 {
+		return nil, ErrInvalidClientData
+	}
+
+	return challenge, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var data clientData
+
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return ErrInvalidClientData
+	}
+
+	if data.Type != typ || data.CrossOrigin {
+		return ErrInvalidClientData
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+
+	return ErrOriginMismatch
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(ad *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return ErrRPIDMismatch
+	}
+
+	if ad.Flags&flagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+
+	if rp.RequireUserVerification && ad.Flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+
+	//a backed up credential has to be eligible for backup
+	if ad.Flags&flagBackupState != 0 && ad.Flags&flagBackupEligible == 0 {
+		return ErrInvalidAuthData
+	}
+
+	return nil
+}
+
+// VerifyRegistration checks the response to CreationOptions with challenge
+// and returns the new credential
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp *CreationResponse) (*Credential, error) {
+	if resp.Type != publicKeyType || len(resp.RawID) == 0 {
+		return nil, ErrInvalidCredential
+	}
+
+	err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := parseAttestationObject(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+
+	ad, err := parseAuthenticatorData(obj.AuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if ad.Flags&flagAttestedData == 0 {
+		return nil, ErrInvalidAuthData
+	}
+
+	err = rp.verifyAuthenticatorData(ad)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(ad.CredentialID, resp.RawID) {
+		return nil, ErrInvalidCredential
+	}
+
+	_, alg, err := parsePublicKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+
+	attestationType, err := verifyAttestation(obj, ad, alg, clientDataHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:                ad.CredentialID,
+		PublicKey:         ad.PublicKey,
+		Algorithm:         alg,
+		SignCount:         ad.SignCount,
+		AAGUID:            ad.AAGUID,
+		AttestationFormat: obj.Fmt,
+		AttestationType:   attestationType,
+		Transports:        resp.Response.Transports,
+		UserVerified:      ad.Flags&flagUserVerified != 0,
+		BackupEligible:    ad.Flags&flagBackupEligible != 0,
+		BackupState:       ad.Flags&flagBackupState != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the response to RequestOptions with challenge
+// against the stored COSE publicKey. The caller compares the sign count
+// with SignCountValid
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, publicKey []byte, resp *RequestResponse) (*Assertion, error) {
+	if resp.Type != publicKeyType || len(resp.RawID) == 0 {
+		return nil, ErrInvalidCredential
+	}
+
+	err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	ad, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rp.verifyAuthenticatorData(ad)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+
+	err = verifySignature(publicKey, signed, resp.Response.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Assertion{
+		CredentialID: resp.RawID,
+		UserHandle:   resp.Response.UserHandle,
+		SignCount:    ad.SignCount,
+		UserVerified: ad.Flags&flagUserVerified != 0,
+		BackupState:  ad.Flags&flagBackupState != 0,
+	}, nil
+}
+
+// SignCountValid reports whether received may follow stored, a counter that
+// does not grow hints at a cloned authenticator. Authenticators without a
+// counter always send zero
+func SignCountValid(stored uint32, received uint32) bool {
+	if stored == 0 && received == 0 {
+		return true
+	}
+
+	return received > stored
+}
@@ -0,0 +1,482 @@
+package webauthn_test
+
+import (
+	"auth/models"
+	"auth/repositores"
+	"auth/repositores/memoryRepo"
+	"auth/webauthn"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	rpID   = "auth.example.com"
+	origin = "https://auth.example.com"
+)
+
+// authenticator data flags
+const (
+	flagUP = 0x01
+	flagUV = 0x04
+	flagAT = 0x40
+)
+
+func relyingParty() *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{ID: rpID, Name: "auth", Origins: []string{origin}}
+}
+
+// softAuthenticator is a passkey in memory, it answers the ceremonies like a
+// browser would and lets tests break one part of the answer at a time
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	signCount  uint32
+	aaguid     []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{
+		key:        key,
+		credID:     randomBytes(16),
+		userHandle: randomBytes(12),
+		aaguid:     randomBytes(16),
+	}
+}
+
+// ceremony is what a test changes in an answer
+type ceremony struct {
+	typ       string
+	origin    string
+	rpID      string
+	challenge []byte
+	flags     byte
+	format    string
+	//signs the packed statement with a certificate instead of the credential
+	attestationCert bool
+	breakSignature  bool
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return b
+}
+
+func mustCBOR(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	data, err := cbor.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func (a *softAuthenticator) coseKey(t *testing.T) []byte {
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+
+	return mustCBOR(t, map[int]interface{}{1: 2, 3: webauthn.AlgES256, -1: 1, -2: x, -3: y})
+}
+
+func clientDataJSON(t *testing.T, c *ceremony) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]interface{}{
+		"type":      c.typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(c.challenge),
+		"origin":    c.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// authenticatorData is rpIdHash, flags, signCount and on registration the
+// attested credential data
+func (a *softAuthenticator) authenticatorData(t *testing.T, c *ceremony, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	flags := c.flags
+
+	data := append([]byte{}, rpIDHash[:]...)
+
+	if attested {
+		flags |= flagAT
+	}
+
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attested {
+		data = append(data, a.aaguid...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credID)))
+		data = append(data, a.credID...)
+		data = append(data, a.coseKey(t)...)
+	}
+
+	return data
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, authData []byte, clientData []byte, broken bool) []byte {
+	t.Helper()
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if broken {
+		sig[len(sig)-1] ^= 0xff
+	}
+
+	return sig
+}
+
+// attestationCertificate is a packed attestation certificate with the
+// subject the specification asks for
+func (a *softAuthenticator) attestationCertificate(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Soft Authenticators"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "soft authenticator",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, der
+}
+
+// register answers navigator.credentials.create
+func (a *softAuthenticator) register(t *testing.T, c *ceremony) *webauthn.CreationResponse {
+	t.Helper()
+
+	clientData := clientDataJSON(t, c)
+	authData := a.authenticatorData(t, c, true)
+
+	var stmt map[string]interface{}
+
+	switch c.format {
+	case "none":
+		stmt = map[string]interface{}{}
+	case "packed":
+		key := a.key
+		stmt = map[string]interface{}{"alg": webauthn.AlgES256}
+
+		if c.attestationCert {
+			var der []byte
+			key, der = a.attestationCertificate(t)
+			stmt["x5c"] = [][]byte{der}
+		}
+
+		stmt["sig"] = sign(t, key, authData, clientData, c.breakSignature)
+	}
+
+	attestation := mustCBOR(t, map[string]interface{}{"fmt": c.format, "attStmt": stmt, "authData": authData})
+
+	return &webauthn.CreationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credID),
+		RawID: a.credID,
+		Type:  "public-key",
+		Response: webauthn.AttestationResponse{
+			ClientDataJSON:    clientData,
+			AttestationObject: attestation,
+			Transports:        []string{"internal"},
+		},
+	}
+}
+
+// login answers navigator.credentials.get, a discoverable credential always
+// returns its user handle
+func (a *softAuthenticator) login(t *testing.T, c *ceremony) *webauthn.RequestResponse {
+	t.Helper()
+
+	a.signCount++
+
+	clientData := clientDataJSON(t, c)
+	authData := a.authenticatorData(t, c, false)
+
+	return &webauthn.RequestResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credID),
+		RawID: a.credID,
+		Type:  "public-key",
+		Response: webauthn.AssertionResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: authData,
+			Signature:         sign(t, a.key, authData, clientData, c.breakSignature),
+			UserHandle:        a.userHandle,
+		},
+	}
+}
+
+func validCeremony(typ string, challenge []byte) ceremony {
+	return ceremony{typ: typ, origin: origin, rpID: rpID, challenge: challenge, flags: flagUP | flagUV, format: "none"}
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	tests := []struct {
+		name            string
+		change          func(c *ceremony)
+		rp              func(rp *webauthn.RelyingParty)
+		wantErr         error
+		wantAttestation string
+	}{
+		{"none attestation", nil, nil, nil, webauthn.AttestationNone},
+		{"packed self attestation", func(c *ceremony) { c.format = "packed" }, nil, nil, webauthn.AttestationSelf},
+		{"packed basic attestation", func(c *ceremony) { c.format, c.attestationCert = "packed", true }, nil, nil, webauthn.AttestationBasic},
+		{"packed bad signature", func(c *ceremony) { c.format, c.breakSignature = "packed", true }, nil, webauthn.ErrInvalidSignature, ""},
+		{"unknown format", func(c *ceremony) { c.format = "fido-u2f" }, nil, webauthn.ErrUnsupportedFormat, ""},
+		{"wrong origin", func(c *ceremony) { c.origin = "https://evil.example.com" }, nil, webauthn.ErrOriginMismatch, ""},
+		{"wrong rpIdHash", func(c *ceremony) { c.rpID = "evil.example.com" }, nil, webauthn.ErrRPIDMismatch, ""},
+		{"missing UP flag", func(c *ceremony) { c.flags = flagUV }, nil, webauthn.ErrUserNotPresent, ""},
+		{"missing UV flag", func(c *ceremony) { c.flags = flagUP },
+			func(rp *webauthn.RelyingParty) { rp.RequireUserVerification = true }, webauthn.ErrUserNotVerified, ""},
+		{"challenge mismatch", func(c *ceremony) { c.challenge = webauthn.NewChallenge() }, nil, webauthn.ErrChallengeMismatch, ""},
+		{"assertion type", func(c *ceremony) { c.typ = "webauthn.get" }, nil, webauthn.ErrInvalidClientData, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := relyingParty()
+			if tt.rp != nil {
+				tt.rp(rp)
+			}
+
+			authenticator := newSoftAuthenticator(t)
+			challenge := webauthn.NewChallenge()
+
+			c := validCeremony("webauthn.create", challenge)
+			if tt.change != nil {
+				tt.change(&c)
+			}
+
+			credential, err := rp.VerifyRegistration(challenge, authenticator.register(t, &c))
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !bytes.Equal(credential.ID, authenticator.credID) || !bytes.Equal(credential.AAGUID, authenticator.aaguid) {
+				t.Error("credential id or aaguid differ from the authenticator")
+			}
+
+			if credential.Algorithm != webauthn.AlgES256 || credential.AttestationType != tt.wantAttestation {
+				t.Errorf("got alg %d attestation %q", credential.Algorithm, credential.AttestationType)
+			}
+
+			if !credential.UserVerified {
+				t.Error("UV flag was not read")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *ceremony)
+		wantErr error
+	}{
+		{"valid", nil, nil},
+		{"wrong origin", func(c *ceremony) { c.origin = "https://evil.example.com" }, webauthn.ErrOriginMismatch},
+		{"wrong rpIdHash", func(c *ceremony) { c.rpID = "evil.example.com" }, webauthn.ErrRPIDMismatch},
+		{"missing UP flag", func(c *ceremony) { c.flags = flagUV }, webauthn.ErrUserNotPresent},
+		{"challenge mismatch", func(c *ceremony) { c.challenge = webauthn.NewChallenge() }, webauthn.ErrChallengeMismatch},
+		{"bad signature", func(c *ceremony) { c.breakSignature = true }, webauthn.ErrInvalidSignature},
+		{"registration type", func(c *ceremony) { c.typ = "webauthn.create" }, webauthn.ErrInvalidClientData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := relyingParty()
+			authenticator := newSoftAuthenticator(t)
+
+			challenge := webauthn.NewChallenge()
+			create := validCeremony("webauthn.create", challenge)
+
+			credential, err := rp.VerifyRegistration(challenge, authenticator.register(t, &create))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			challenge = webauthn.NewChallenge()
+			c := validCeremony("webauthn.get", challenge)
+			if tt.change != nil {
+				tt.change(&c)
+			}
+
+			assertion, err := rp.VerifyAssertion(challenge, credential.PublicKey, authenticator.login(t, &c))
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil && assertion.SignCount != authenticator.signCount {
+				t.Errorf("got sign count %d, want %d", assertion.SignCount, authenticator.signCount)
+			}
+		})
+	}
+}
+
+func TestSignCountValid(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   uint32
+		received uint32
+		want     bool
+	}{
+		{"no counter", 0, 0, true},
+		{"first use", 0, 1, true},
+		{"grows", 5, 6, true},
+		{"repeats", 5, 5, false},
+		{"goes back", 5, 3, false},
+		{"drops to zero", 5, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := webauthn.SignCountValid(tt.stored, tt.received); got != tt.want {
+				t.Errorf("SignCountValid(%d, %d) = %v, want %v", tt.stored, tt.received, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiscoverableLogin runs a login without user name against the store:
+// the ceremony is found by the challenge of the client data, the user by the
+// returned user handle, and a cloned authenticator whose counter goes back is
+// refused by the store with ErrConflict
+func TestDiscoverableLogin(t *testing.T) {
+	ctx := context.Background()
+	rp := relyingParty()
+	store := memoryRepo.NewMemoryDB()
+	authenticator := newSoftAuthenticator(t)
+
+	challenge := webauthn.NewChallenge()
+	create := validCeremony("webauthn.create", challenge)
+	create.format = "packed"
+
+	credential, err := rp.VerifyRegistration(challenge, authenticator.register(t, &create))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &models.WebAuthnCredential{
+		ID:        webauthn.Base64URL(credential.ID).String(),
+		UserID:    string(authenticator.userHandle),
+		PublicKey: credential.PublicKey,
+		SignCount: int64(credential.SignCount),
+	}
+
+	if err := store.AddWebAuthnCredential(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	//no allow list, the authenticator picks its discoverable credential
+	options := rp.RequestOptions(webauthn.NewChallenge(), nil, time.Minute)
+	if len(options.AllowCredentials) != 0 {
+		t.Fatalf("got %d allowed credentials, want none", len(options.AllowCredentials))
+	}
+
+	get := validCeremony("webauthn.get", options.Challenge)
+	resp := authenticator.login(t, &get)
+
+	challenge, err = webauthn.Challenge(resp.Response.ClientDataJSON)
+	if err != nil || !bytes.Equal(challenge, options.Challenge) {
+		t.Fatalf("challenge of the client data: %v", err)
+	}
+
+	found, err := store.GetWebAuthnCredential(ctx, resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion, err := rp.VerifyAssertion(challenge, found.PublicKey, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(assertion.UserHandle) != found.UserID {
+		t.Errorf("got user handle %q, want %q", assertion.UserHandle, found.UserID)
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+
+	if err := store.UseWebAuthnCredential(ctx, found.ID, int64(assertion.SignCount), now); err != nil {
+		t.Fatal(err)
+	}
+
+	//a clone replays with the counter it had before
+	authenticator.signCount = 0
+	get = validCeremony("webauthn.get", challenge)
+
+	assertion, err = rp.VerifyAssertion(challenge, found.PublicKey, authenticator.login(t, &get))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = store.GetWebAuthnCredential(ctx, resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if webauthn.SignCountValid(uint32(found.SignCount), assertion.SignCount) {
+		t.Error("a counter that did not grow was accepted")
+	}
+
+	err = store.UseWebAuthnCredential(ctx, found.ID, int64(assertion.SignCount), now)
+	if !errors.Is(err, repositores.ErrConflict) {
+		t.Fatalf("got error %v, want %v", err, repositores.ErrConflict)
+	}
+}